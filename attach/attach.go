@@ -0,0 +1,125 @@
+// Package attach stores files attached to the notes in the session directory.
+package attach
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store copies src into dir and returns its name there. Files are stored once,
+// when dir already holds a file with the same content that file is used.
+func Store(dir, src string) (string, error) {
+	sum, size, err := fileHash(src)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("%v", err)
+	}
+	taken := make(map[string]bool)
+	for _, e := range entries {
+		taken[e.Name()] = true
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Size() != size {
+			continue
+		}
+		if s, _, err := fileHash(filepath.Join(dir, e.Name())); err == nil && bytes.Equal(s, sum) {
+			return e.Name(), nil
+		}
+	}
+
+	name := filepath.Base(src)
+	ext := filepath.Ext(name)
+	for i := 2; taken[name]; i++ {
+		name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(filepath.Base(src), ext), i, ext)
+	}
+
+	if err := copyFile(src, filepath.Join(dir, name)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func fileHash(p string) ([]byte, int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%v", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%v", err)
+	}
+	return h.Sum(nil), n, nil
+}
+
+// copyFile writes to a temporary file first, so a NSM save never sees half a file.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".attach-*")
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("%v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("%v", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
+
+// IsImage is true for the files a link to is shown as image.
+func IsImage(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".jpg", ".jpeg", ".bmp", ".svg":
+		return true
+	}
+	return false
+}
+
+// DroppedFiles turns the text of a drop event into paths. Dropped files come as
+// file:// urls or absolute paths, one per line. Other text, like a dragged
+// selection, isn't taken for files, ok is false.
+func DroppedFiles(text string) (files []string, ok bool) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if u, err := url.Parse(line); err == nil && u.Scheme == "file" && filepath.IsAbs(u.Path) {
+			files = append(files, u.Path)
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			return nil, false
+		}
+		if _, err := os.Stat(line); err != nil {
+			return nil, false
+		}
+		files = append(files, line)
+	}
+	return files, len(files) > 0
+}
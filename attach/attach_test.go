@@ -0,0 +1,91 @@
+package attach
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, p, data string) {
+	t.Helper()
+	if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	src, dir := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(src, "take.wav"), "take one")
+	writeFile(t, filepath.Join(src, "copy.wav"), "take one")
+	if err := os.Mkdir(filepath.Join(src, "other"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "other", "take.wav"), "take two")
+	writeFile(t, filepath.Join(dir, "take-2.wav"), "taken")
+
+	for _, test := range []struct {
+		src, want string
+	}{
+		{"take.wav", "take.wav"},
+		{"take.wav", "take.wav"},         // stored again
+		{"copy.wav", "take.wav"},         // same content, other name
+		{"other/take.wav", "take-3.wav"}, // name taken twice
+	} {
+		got, err := Store(dir, filepath.Join(src, test.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%s: stored as %q, want %q", test.src, got, test.want)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"take-2.wav", "take-3.wav", "take.wav"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("directory holds %q, want %q", names, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "take-3.wav")); string(data) != "take two" {
+		t.Fatalf("take-3.wav holds %q", data)
+	}
+}
+
+func TestDroppedFiles(t *testing.T) {
+	dir := t.TempDir()
+	mic := filepath.Join(dir, "mic.png")
+	writeFile(t, mic, "png")
+
+	for _, test := range []struct {
+		name, text string
+		want       []string
+	}{
+		{"uri", "file:///tmp/a%20b.wav", []string{"/tmp/a b.wav"}},
+		{"uris", "file:///a.wav\r\nfile:///b.png\r\n", []string{"/a.wav", "/b.png"}},
+		{"path", mic + "\n", []string{mic}},
+		{"uri and path", "file:///a.wav\n" + mic, []string{"/a.wav", mic}},
+		{"missing path", filepath.Join(dir, "gone.png"), nil},
+		{"text", "some dragged words", nil},
+		{"text after uri", "file:///a.wav\nverse two", nil},
+		{"relative path", "mic.png", nil},
+		{"web url", "https://example.org/a.png", nil},
+		{"empty", "\n", nil},
+	} {
+		got, ok := DroppedFiles(test.text)
+		if ok != (test.want != nil) || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, %v, want %q", test.name, got, ok, test.want)
+		}
+	}
+}
+
+func TestIsImage(t *testing.T) {
+	if !IsImage("Mic.PNG") || IsImage("take.wav") || IsImage("png") {
+		t.Fatal("wrong image check")
+	}
+}
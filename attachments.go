@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"nsm-notes/attach"
+)
+
+// attachmentsDir is next to the notes file, in the directory NSM gave us, so
+// the session stays self-contained.
+func (a *app) attachmentsDir() string {
+	return a.fileName + attachmentsDirSuffix
+}
+
+// attachFile copies src into the attachments directory and returns the link to insert.
+func (a *app) attachFile(src string) (string, error) {
+	if a.fileName == "" {
+		return "", fmt.Errorf("no session open")
+	}
+
+	dir := a.attachmentsDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("%v", err)
+	}
+
+	name, err := attach.Store(dir, src)
+	if err != nil {
+		return "", err
+	}
+
+	rel := (&url.URL{Path: filepath.Base(dir) + "/" + name}).String()
+	if attach.IsImage(name) {
+		return fmt.Sprintf("![%s](%s)", name, rel), nil
+	}
+	return fmt.Sprintf("[%s](%s)", name, rel), nil
+}
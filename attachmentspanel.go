@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/attach"
+)
+
+type thumbnail interface {
+	fltk.Image
+	Scale(width, height int, proportional, canExpand bool)
+	Destroy()
+}
+
+type attachmentsPanel struct {
+	win     *fltk.Window
+	browser *fltk.HoldBrowser
+	images  []thumbnail
+}
+
+func (a *app) buildAttachmentsPanel() {
+	p := &attachmentsPanel{}
+	p.win = fltk.NewWindow(attachmentsWidth, attachmentsHeight)
+	p.win.SetLabel(APP_TITLE + " attachments")
+	p.win.SetColor(windowColor)
+
+	p.browser = fltk.NewHoldBrowser(0, 0, attachmentsWidth, attachmentsHeight)
+	p.browser.SetTooltip("double click to insert a link")
+	p.browser.SetCallback(func() {
+		if fltk.EventClicks() == 0 {
+			return
+		}
+		if line := p.browser.Value(); line > 0 {
+			a.insertAttachmentLink(filepath.Join(a.attachmentsDir(), p.browser.Text(line)))
+		}
+	})
+
+	p.win.End()
+	p.win.Resizable(p.browser)
+
+	a.attachments = p
+}
+
+func (a *app) showAttachmentsPanel() {
+	a.refreshAttachmentsPanel()
+	a.attachments.win.Show()
+}
+
+// refreshAttachmentsPanel lists the attachments directory, images get a thumbnail.
+func (a *app) refreshAttachmentsPanel() {
+	p := a.attachments
+	p.browser.Clear()
+	for _, img := range p.images {
+		img.Destroy()
+	}
+	p.images = nil
+
+	entries, err := os.ReadDir(a.attachmentsDir())
+	if err != nil {
+		return // nothing attached yet
+	}
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		p.browser.Add(e.Name())
+		if img := loadThumbnail(filepath.Join(a.attachmentsDir(), e.Name())); img != nil {
+			p.browser.SetIcon(p.browser.Size(), img)
+			p.images = append(p.images, img)
+		}
+	}
+}
+
+func loadThumbnail(path string) thumbnail {
+	var (
+		img thumbnail
+		err error
+	)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		img, err = fltk.NewPngImageLoad(path)
+	case ".jpg", ".jpeg":
+		img, err = fltk.NewJpegImageLoad(path)
+	case ".bmp":
+		img, err = fltk.NewBmpImageLoad(path)
+	case ".svg":
+		img, err = fltk.NewSvgImageLoad(path)
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	img.Scale(thumbnailSize, thumbnailSize, true, true)
+	return img
+}
+
+func (a *app) callbackMenuFileAttach() {
+	path, ok := fltk.ChooseFile("Attach file", "*", "", false)
+	if !ok {
+		return
+	}
+	a.attachFiles([]string{path})
+}
+
+// attachFiles copies files into the session and inserts a link for each at the cursor.
+func (a *app) attachFiles(paths []string) {
+	for _, p := range paths {
+		link, err := a.attachFile(p)
+		if err != nil {
+			a.logf("%v", err)
+			fltk.MessageBox(APP_TITLE, fmt.Sprintf("Attaching %s failed: %v", filepath.Base(p), err))
+			continue
+		}
+		a.TextEditor.InsertText(link + "\n")
+		a.setAppDirty()
+	}
+
+	if a.attachments.win.IsShown() {
+		a.refreshAttachmentsPanel()
+	}
+}
+
+func (a *app) insertAttachmentLink(path string) {
+	link, err := a.attachFile(path) // already stored, this only builds the link
+	if err != nil {
+		a.logf("%v", err)
+		return
+	}
+	a.TextEditor.InsertText(link)
+	a.setAppDirty()
+}
+
+// handleEditorDrop accepts files dropped on the editor. Fltk delivers the
+// dropped paths as a paste event right after the drop. Dropped text is left to
+// the editor.
+func (a *app) handleEditorDrop(e fltk.Event) bool {
+	switch e {
+	case fltk.DND_RELEASE:
+		a.dropPending = true
+	case fltk.PASTE:
+		if !a.dropPending {
+			return false
+		}
+		a.dropPending = false
+		if files, ok := attach.DroppedFiles(fltk.EventText()); ok {
+			a.attachFiles(files)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/notes"
+)
+
+type clientNotesSidebar struct {
+	browser *fltk.HoldBrowser
+	ids     []string // of the browser lines
+	changes uint64   // of a.ray when the browser was last filled
+	stale   bool     // the notes changed since
+}
+
+// buildClientNotesSidebar adds the sidebar to the editor row, next to the editor.
+func (a *app) buildClientNotesSidebar() {
+	s := &clientNotesSidebar{stale: true}
+	s.browser = fltk.NewHoldBrowser(0, 0, clientNotesWidth, widgetHeight)
+	s.browser.SetTooltip("the clients of the session, bold ones have notes.\ndouble click to go to the notes about a client")
+	s.browser.SetCallback(func() {
+		if fltk.EventClicks() == 0 {
+			return
+		}
+		if line := s.browser.Value(); line > 0 {
+			a.gotoClientNotes(s.ids[line-1])
+		}
+	})
+	s.browser.Hide()
+	a.editorRow.Fixed(s.browser, clientNotesWidth)
+
+	a.TextBuffer.AddModifyCallback(func(int, int, int, int, string) {
+		s.stale = true
+	})
+	a.clientNotes = s
+}
+
+func (a *app) showClientNotes(show bool) {
+	s := a.clientNotes
+	a.view.ClientNotes = show
+	if show {
+		s.browser.Show()
+		a.refreshClientNotes()
+	} else {
+		s.browser.Hide()
+	}
+	a.layoutEditorRow()
+}
+
+func (a *app) toggleClientNotes() {
+	a.showClientNotes(!a.view.ClientNotes)
+}
+
+// checkClientNotes is polled from the main loop. Renamed clients get their headings
+// updated, even while the sidebar is hidden.
+func (a *app) checkClientNotes() {
+	s := a.clientNotes
+	if s == nil || (!s.stale && s.changes == a.ray.Changes()) {
+		return
+	}
+	if changes := a.ray.Changes(); s.changes != changes {
+		a.renameClientSections()
+		s.changes = changes
+	}
+	if s.browser.Visible() {
+		a.refreshClientNotes()
+	}
+}
+
+// renameClientSections writes the current client names into the section headings.
+func (a *app) renameClientSections() {
+	names := make(map[string]string)
+	for _, c := range a.ray.Clients() {
+		names[c.ID] = c.Name
+	}
+	for _, r := range notes.Renames(a.TextBuffer.Text(), names) {
+		a.TextBuffer.ReplaceRange(r.Start, r.End, r.Text)
+		a.setAppDirty()
+	}
+}
+
+// refreshClientNotes lists the clients of the session and the ones with notes,
+// clients that left the session are listed in italics.
+func (a *app) refreshClientNotes() {
+	s := a.clientNotes
+	s.changes = a.ray.Changes()
+	s.stale = false
+
+	type entry struct {
+		name               string
+		running, annotated bool
+	}
+	var (
+		ids     []string
+		entries = make(map[string]*entry)
+	)
+	for _, c := range a.ray.Clients() {
+		ids = append(ids, c.ID)
+		entries[c.ID] = &entry{name: c.Name, running: true}
+	}
+	for _, sec := range notes.ClientSections(a.TextBuffer.Text()) {
+		e, ok := entries[sec.ID]
+		if !ok {
+			ids = append(ids, sec.ID)
+			e = &entry{name: sec.Name}
+			entries[sec.ID] = e
+		}
+		e.annotated = true
+	}
+
+	selected := ""
+	if line := s.browser.Value(); line > 0 && line <= len(s.ids) {
+		selected = s.ids[line-1]
+	}
+	s.browser.Clear()
+	s.ids = ids
+	for i, id := range ids {
+		e := entries[id]
+		format := ""
+		if e.annotated {
+			format += "@b"
+		}
+		if !e.running {
+			format += "@i"
+		}
+		s.browser.Add(format + "@." + e.name)
+		if id == selected {
+			s.browser.SetValue(i + 1)
+		}
+	}
+	if len(ids) == 0 && !a.ray.Monitored() {
+		s.browser.Add("@i@.the session manager doesn't list its clients")
+	}
+}
+
+// gotoClientNotes puts the cursor in the section of client id, a missing section
+// is added at the end of the notes.
+func (a *app) gotoClientNotes(id string) {
+	for _, sec := range notes.ClientSections(a.TextBuffer.Text()) {
+		if sec.ID == id {
+			a.TextEditor.SetInsertPosition(sec.Body)
+			a.TextEditor.ShowInsertPosition()
+			a.TextEditor.TakeFocus()
+			return
+		}
+	}
+
+	name := id
+	for _, c := range a.ray.Clients() {
+		if c.ID == id && c.Name != "" {
+			name = c.Name
+		}
+	}
+	text := a.TextBuffer.Text()
+	heading := notes.HeadingLine(name, id)
+	switch {
+	case text == "":
+	case strings.HasSuffix(text, "\n\n"):
+	case strings.HasSuffix(text, "\n"):
+		heading = "\n" + heading
+	default:
+		heading = "\n\n" + heading
+	}
+	a.TextBuffer.Append(heading + "\n")
+	a.setAppDirty()
+	a.TextEditor.SetInsertPosition(a.TextBuffer.Length())
+	a.TextEditor.ShowInsertPosition()
+	a.TextEditor.TakeFocus()
+}
@@ -0,0 +1,193 @@
+// nsm-ctl controls a running NSM server from the shell.
+//
+//	nsm-ctl [-url osc.udp://host:port/] [-json] [-timeout 30s] command [argument]
+//
+// The commands are list, new <name>, open <name>, save, close, abort,
+// duplicate <name>, add <executable> and watch, which prints the client status
+// updates until interrupted. Without -url the server is found through NSM_URL.
+//
+// The exit code is 0 on success, the NSM error code without its sign for an
+// error of the server (5 for NSM_ERR_NO_SUCH_FILE), 20 when the server didn't
+// reply, 21 for other failures and 64 for usage errors.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+const (
+	exitOk      = 0
+	exitTimeout = 20
+	exitFailure = 21
+	exitUsage   = 64
+)
+
+// commands maps the commands to their server address, and whether they take an argument.
+var commands = map[string]struct {
+	addr string
+	arg  string
+}{
+	"list":      {nsm.NsmAddrServerList, ""},
+	"new":       {nsm.NsmAddrServerNew, "name"},
+	"open":      {nsm.NsmAddrServerOpen, "name"},
+	"save":      {nsm.NsmAddrServerSave, ""},
+	"close":     {nsm.NsmAddrServerClose, ""},
+	"abort":     {nsm.NsmAddrServerAbort, ""},
+	"duplicate": {nsm.NsmAddrServerDuplicate, "name"},
+	"add":       {nsm.NsmAddrServerAdd, "executable"},
+	"watch":     {"", ""},
+}
+
+// result is the JSON output of a command, watch prints an update per line instead.
+type result struct {
+	Command  string   `json:"command"`
+	Ok       bool     `json:"ok"`
+	Message  string   `json:"message,omitempty"`
+	Sessions []string `json:"sessions,omitempty"`
+	Code     int      `json:"code,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type update struct {
+	Time    time.Time           `json:"time"`
+	Event   string              `json:"event"`
+	Client  string              `json:"client,omitempty"`
+	Args    []nsm.NsmCaptureArg `json:"args,omitempty"`
+	Address string              `json:"address"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintf(flags.Output(), "usage: nsm-ctl [-url url] [-json] [-timeout 30s] command [argument]\n")
+	fmt.Fprintf(flags.Output(), "commands: list, new name, open name, save, close, abort, duplicate name, add executable, watch\n")
+	flags.PrintDefaults()
+}
+
+// run runs the command line args, the output goes to stdout.
+func run(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("nsm-ctl", flag.ContinueOnError)
+	url := flags.String("url", os.Getenv(nsm.NsmEnvUrl), "NSM server url, NSM_URL by default")
+	jsonOut := flags.Bool("json", false, "print JSON")
+	timeout := flags.Duration("timeout", 30*time.Second, "how long to wait for the reply")
+	flags.Usage = func() { usage(flags) }
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		usage(flags)
+		return exitUsage
+	}
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok || (cmd.arg == "") != (flags.NArg() == 1) || flags.NArg() > 2 {
+		usage(flags)
+		return exitUsage
+	}
+	if *url == "" {
+		fmt.Fprintf(os.Stderr, "no server: set %s or -url\n", nsm.NsmEnvUrl)
+		return exitUsage
+	}
+
+	c, err := nsm.NsmNewControl(*url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitFailure
+	}
+	defer c.NsmClose()
+	c.NsmSetTimeout(*timeout)
+
+	if name == "watch" {
+		return watch(c, *jsonOut, stdout)
+	}
+
+	r := result{Command: name}
+	if name == "list" {
+		r.Sessions, err = c.NsmList()
+	} else {
+		r.Message, err = c.NsmRequest(cmd.addr, flags.Args()[1:]...)
+	}
+	status := exitCode(err)
+	r.Ok = err == nil
+	if err != nil {
+		r.Error = err.Error()
+		var nsmErr *nsm.NsmError
+		if errors.As(err, &nsmErr) {
+			r.Code = int(nsmErr.Code())
+		}
+	}
+
+	if *jsonOut {
+		if r.Sessions == nil && name == "list" && err == nil {
+			r.Sessions = []string{}
+		}
+		json.NewEncoder(stdout).Encode(r)
+		return status
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return status
+	}
+	for _, s := range r.Sessions {
+		fmt.Fprintln(stdout, s)
+	}
+	if r.Message != "" {
+		fmt.Fprintln(stdout, r.Message)
+	}
+	return status
+}
+
+func exitCode(err error) int {
+	var nsmErr *nsm.NsmError
+	switch {
+	case err == nil:
+		return exitOk
+	case errors.Is(err, nsm.NsmControlTimeoutErr):
+		return exitTimeout
+	case errors.As(err, &nsmErr) && nsmErr.Code() < 0:
+		return int(-nsmErr.Code())
+	}
+	return exitFailure
+}
+
+// watch prints the server's updates to its GUIs until SIGINT or SIGTERM.
+func watch(c *nsm.NsmControl, jsonOut bool, stdout io.Writer) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	enc := json.NewEncoder(stdout)
+
+	err := c.NsmWatch(ctx, func(u nsm.NsmGuiUpdate) error {
+		event := strings.TrimPrefix(u.Address, "/nsm/gui/")
+		if jsonOut {
+			return enc.Encode(update{Time: time.Now(), Event: event, Client: u.Client, Args: u.Args, Address: u.Address})
+		}
+		fields := []string{event}
+		if u.Client != "" {
+			fields = append(fields, u.Client)
+		}
+		for _, a := range u.Args {
+			fields = append(fields, fmt.Sprint(a.Value))
+		}
+		_, err := fmt.Fprintln(stdout, strings.Join(fields, " "))
+		return err
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "watch: %v\n", err)
+		return exitFailure
+	}
+	return exitOk
+}
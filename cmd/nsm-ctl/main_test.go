@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	nsm "nsm-notes/nsmclient"
+	"nsm-notes/nsmserver"
+)
+
+func newTestServer(t *testing.T) *nsmserver.Server {
+	t.Helper()
+	s, err := nsmserver.New(nsmserver.Config{
+		SessionRoot:     t.TempDir(),
+		Listen:          "127.0.0.1:0",
+		Host:            "127.0.0.1",
+		AnnounceTimeout: 5 * time.Second,
+		ReplyTimeout:    5 * time.Second,
+		QuitTimeout:     2 * time.Second,
+		Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// ctl runs nsm-ctl with args and returns its exit code and output.
+func ctl(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	var out bytes.Buffer
+	code := run(args, &out)
+	return code, out.String()
+}
+
+func TestRun(t *testing.T) {
+	s := newTestServer(t)
+
+	if code, out := ctl(t, "-url", s.Url(), "new", "album/song"); code != exitOk {
+		t.Fatalf("new: exit code %d, %q", code, out)
+	}
+	code, out := ctl(t, "-url", s.Url(), "-json", "list")
+	if code != exitOk {
+		t.Fatalf("list: exit code %d, %q", code, out)
+	}
+	var r result
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("list: %v in %q", err, out)
+	}
+	if want := (result{Command: "list", Ok: true, Sessions: []string{"album/song"}}); !reflect.DeepEqual(r, want) {
+		t.Fatalf("list: %+v, want %+v", r, want)
+	}
+
+	// the server's error code is the exit code.
+	code, out = ctl(t, "-url", s.Url(), "-json", "open", "missing")
+	if code != 5 {
+		t.Fatalf("open missing: exit code %d, %q", code, out)
+	}
+	r = result{}
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("open missing: %v in %q", err, out)
+	}
+	if r.Command != "open" || r.Ok || r.Code != int(nsm.NSM_ERR_NO_SUCH_FILE) || r.Error == "" {
+		t.Fatalf("open missing: %+v", r)
+	}
+
+	if code, _ := ctl(t, "-url", s.Url(), "open"); code != exitUsage {
+		t.Fatalf("open without name: exit code %d", code)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	// a server that never answers.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	url := "osc.udp://" + conn.LocalAddr().String() + "/"
+
+	if code, out := ctl(t, "-url", url, "-timeout", "200ms", "save"); code != exitTimeout {
+		t.Fatalf("exit code %d, %q, want %d", code, out, exitTimeout)
+	}
+	if got := exitCode(nsm.NsmControlTimeoutErr); got != exitTimeout {
+		t.Fatalf("exitCode(timeout) = %d", got)
+	}
+}
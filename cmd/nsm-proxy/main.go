@@ -0,0 +1,453 @@
+// nsm-proxy brings programs that don't speak NSM into a session. It announces as
+// a client and runs the program for the session the server opens.
+//
+//	nsm-proxy [-label name] [-config-file file] [-save-signal USR1] [-stop-signal TERM] executable [arguments]
+//
+// The settings are kept in nsm-proxy.config in the client's session directory,
+// in the format of the nsm-proxy of New Session Manager; the command line only
+// sets them for a new client. The config file is copied into the session
+// directory, the arguments and the CONFIG_FILE environment variable name the copy.
+// Like there, the arguments are stored as shell words and the program is run
+// by /bin/sh, which expands $CONFIG_FILE.
+// The program runs in the session directory, with NSM_CLIENT_ID and
+// NSM_SESSION_NAME set. It is restarted when the server opens another session,
+// gets the save signal on save and the stop signal when the session closes.
+// Its stderr lines are passed on to the server as messages.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+const (
+	proxyConfigName = "nsm-proxy.config"
+	proxyWait       = 100  // milliseconds, NsmCheckWait timeout
+	proxyAnnounce   = 5000 // milliseconds
+	envConfigFile   = "CONFIG_FILE"
+	envClientId     = "NSM_CLIENT_ID"
+	envSessionName  = "NSM_SESSION_NAME"
+	shell           = "/bin/sh"
+)
+
+// stopTimeout is how long the program gets to exit on the stop signal, killTimeout
+// how long it gets once killed.
+var (
+	stopTimeout = 10 * time.Second
+	killTimeout = 2 * time.Second
+)
+
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+	"CONT": syscall.SIGCONT,
+	"STOP": syscall.SIGSTOP,
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	flags := flag.NewFlagSet("nsm-proxy", flag.ContinueOnError)
+	label := flags.String("label", "", "label shown in the session manager (default: the executable)")
+	configFile := flags.String("config-file", "", "config file of the program, copied into the session")
+	saveSignal := flags.String("save-signal", "", "signal that makes the program save, like USR1")
+	stopSignal := flags.String("stop-signal", "TERM", "signal that stops the program")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: nsm-proxy [-label name] [-config-file file] [-save-signal USR1] [-stop-signal TERM] executable [arguments]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		return 2
+	}
+
+	defaults := proxyConfig{label: *label, configFile: *configFile}
+	if flags.NArg() > 0 {
+		defaults.executable = flags.Arg(0)
+		defaults.arguments = joinArguments(flags.Args()[1:])
+	}
+	var err error
+	if defaults.saveSignal, err = parseSignal(*saveSignal); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	if defaults.stopSignal, err = parseSignal(*stopSignal); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	nsmUrl := os.Getenv(nsm.NsmEnvUrl)
+	if nsmUrl == "" {
+		fmt.Fprintf(os.Stderr, "%s not set, nsm-proxy runs in an NSM session\n", nsm.NsmEnvUrl)
+		return 1
+	}
+
+	p := &proxy{NsmClient: nsm.NsmNewClient(), defaults: defaults}
+	p.NsmSetOpenCallback(func(path, displayName, clientId string) (string, error) {
+		if err := p.open(path, displayName, clientId); err != nil {
+			return err.Error(), err
+		}
+		return "", nil
+	})
+	p.NsmSetSaveCallback(func() (string, error) {
+		if err := p.save(); err != nil {
+			return err.Error(), err
+		}
+		return "", nil
+	})
+	p.NsmSetSessionIsLoadedCallback(func() error {
+		return nil
+	})
+	p.NsmSetShutdownCallback(func() error {
+		return p.stopProgram()
+	})
+
+	if err := p.NsmInit(nsmUrl); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer func() {
+		if err := p.NsmStop(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}()
+	p.NsmHandleSigterm()
+
+	if err := p.NsmSetClientCapabilities(nsm.NSM_SWITCH, nsm.NSM_MESSAGE); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	p.NsmSetPrettyName("NSM Proxy")
+	p.NsmSetAnnounceTimeout(proxyAnnounce)
+	if err := p.NsmAnnounce(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	for {
+		if err := p.NsmCheckWait(proxyWait); err != nil {
+			if errors.Is(err, nsm.NsmGotSigtermErr) {
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+}
+
+func parseSignal(s string) (syscall.Signal, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", s)
+}
+
+// joinArguments quotes args for the shell. $CONFIG_FILE is left for the shell
+// to expand, it names the config file in the session the program runs for.
+func joinArguments(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+		// "${CONFIG_FILE}" also ends the name in front of letters.
+		arg = strings.ReplaceAll(arg, "${"+envConfigFile+"}", "$"+envConfigFile)
+		if strings.Contains(arg, "$"+envConfigFile) {
+			parts := strings.Split(arg, "$"+envConfigFile)
+			for j, part := range parts {
+				parts[j] = shellEscaper.Replace(part)
+			}
+			quoted[i] = `"` + strings.Join(parts, "${"+envConfigFile+"}") + `"`
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellEscaper escapes the characters the shell interprets in double quotes.
+var shellEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", "$", `\$`)
+
+// shellQuote leaves plain words alone and puts others in double quotes.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") == "" {
+		return s
+	}
+	return `"` + shellEscaper.Replace(s) + `"`
+}
+
+// proxyConfig is nsm-proxy.config, signals are stored as numbers, 0 is none.
+type proxyConfig struct {
+	executable string
+	arguments  string
+	configFile string
+	label      string
+	saveSignal syscall.Signal
+	stopSignal syscall.Signal
+}
+
+// readProxyConfig reads the "key\n\tvalue\n" pairs of nsm-proxy.config, the keys
+// are the ones NSM's nsm-proxy writes, with spaces.
+func readProxyConfig(path string) (proxyConfig, error) {
+	var cfg proxyConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i+1 < len(lines); i += 2 {
+		key := strings.TrimSpace(lines[i])
+		value := strings.TrimPrefix(lines[i+1], "\t")
+		switch key {
+		case "executable":
+			cfg.executable = value
+		case "arguments":
+			cfg.arguments = value
+		case "config file":
+			cfg.configFile = value
+		case "label":
+			cfg.label = value
+		case "save signal", "stop signal":
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return cfg, fmt.Errorf("%s: %s: %v", path, key, err)
+			}
+			if key == "save signal" {
+				cfg.saveSignal = syscall.Signal(n)
+			} else {
+				cfg.stopSignal = syscall.Signal(n)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// writeProxyConfig writes the keys in NSM's order. Empty values are left out
+// like NSM does, its reader stops at an empty line.
+func writeProxyConfig(path string, cfg proxyConfig) error {
+	var sb strings.Builder
+	for _, kv := range [][2]string{
+		{"label", cfg.label},
+		{"executable", cfg.executable},
+		{"arguments", cfg.arguments},
+		{"config file", cfg.configFile},
+		{"save signal", strconv.Itoa(int(cfg.saveSignal))},
+		{"stop signal", strconv.Itoa(int(cfg.stopSignal))},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s\n\t%s\n", kv[0], kv[1])
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+type proxy struct {
+	*nsm.NsmClient
+	defaults proxyConfig
+	cfg      proxyConfig
+	dir      string
+
+	program *exec.Cmd
+	exited  chan struct{} // closed when the program exited, its stderr may still be open
+}
+
+// open (re)starts the program for the session directory path.
+func (p *proxy) open(path, displayName, clientId string) error {
+	if err := p.stopProgram(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	cfg, err := readProxyConfig(filepath.Join(path, proxyConfigName))
+	if errors.Is(err, os.ErrNotExist) {
+		cfg = p.defaults
+		if cfg.configFile != "" {
+			// the session gets its own copy, the config stores its name in the session.
+			copied := filepath.Join(path, filepath.Base(cfg.configFile))
+			if err := copyFile(cfg.configFile, copied); err != nil {
+				return fmt.Errorf("config file: %v", err)
+			}
+			cfg.configFile = filepath.Base(cfg.configFile)
+		}
+		if err := writeProxyConfig(filepath.Join(path, proxyConfigName), cfg); err != nil {
+			return fmt.Errorf("%v", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if cfg.executable == "" {
+		return fmt.Errorf("no executable configured, start nsm-proxy with one")
+	}
+	p.cfg, p.dir = cfg, path
+
+	label := cfg.label
+	if label == "" {
+		label = filepath.Base(cfg.executable)
+	}
+	if err := p.NsmSendLabel(label); err != nil && !errors.Is(err, nsm.NsmUnsupportedErr) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	return p.startProgram(clientId, displayName)
+}
+
+func (p *proxy) configFilePath() string {
+	if p.cfg.configFile == "" {
+		return ""
+	}
+	return filepath.Join(p.dir, p.cfg.configFile)
+}
+
+func (p *proxy) startProgram(clientId, sessionName string) error {
+	// exec, so the signals reach the program and not the shell.
+	cmd := exec.Command(shell, "-c", "exec "+shellQuote(p.cfg.executable)+" "+p.cfg.arguments)
+	cmd.Dir = p.dir
+	// a program that does speak NSM mustn't announce itself.
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, nsm.NsmEnvUrl+"=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		envClientId+"="+clientId,
+		envSessionName+"="+sessionName,
+		envConfigFile+"="+p.configFilePath())
+	cmd.Stdout = os.Stdout
+	// children of the program can keep stderr open after it exited, so the pipe
+	// isn't Wait's: its end is read on its own.
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	cmd.Stderr = stderrWriter
+	// they are killed with it.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = cmd.Start()
+	stderrWriter.Close()
+	if err != nil {
+		stderr.Close()
+		return fmt.Errorf("%v", err)
+	}
+
+	exited, forwarded := make(chan struct{}), make(chan struct{})
+	p.program, p.exited = cmd, exited
+	name := filepath.Base(p.cfg.executable)
+	go func() {
+		p.forwardStderr(stderr)
+		stderr.Close()
+		close(forwarded)
+	}()
+	go func() {
+		err := cmd.Wait()
+		close(exited)
+		if err != nil {
+			// after its last lines, if they come.
+			select {
+			case <-forwarded:
+			case <-time.After(killTimeout):
+			}
+			p.message(nsm.NSM_MESSAGE_PRIORITY_HIGH, fmt.Sprintf("%s exited: %v", name, err))
+		}
+	}()
+	return nil
+}
+
+// forwardStderr passes the program's stderr on, to the terminal and the server.
+func (p *proxy) forwardStderr(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fmt.Fprintln(os.Stderr, line)
+		p.message(nsm.NSM_MESSAGE_PRIORITY_MED, line)
+	}
+}
+
+func (p *proxy) message(priority nsm.NsmMsgLevel, text string) {
+	if err := p.NsmSendMessage(priority, text); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+}
+
+func (p *proxy) save() error {
+	if p.dir == "" {
+		return fmt.Errorf("no session open")
+	}
+	if err := writeProxyConfig(filepath.Join(p.dir, proxyConfigName), p.cfg); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if p.cfg.saveSignal == 0 || p.program == nil {
+		return nil
+	}
+	select {
+	case <-p.exited:
+		return nil
+	default:
+	}
+	if err := p.program.Process.Signal(p.cfg.saveSignal); err != nil {
+		return fmt.Errorf("save signal: %v", err)
+	}
+	return nil
+}
+
+// stopProgram sends the stop signal, and kills the program when it doesn't exit in time.
+func (p *proxy) stopProgram() error {
+	if p.program == nil {
+		return nil
+	}
+	program, exited := p.program, p.exited
+	p.program = nil
+
+	stop := p.cfg.stopSignal
+	if stop == 0 {
+		stop = syscall.SIGTERM
+	}
+	select {
+	case <-exited:
+		return nil
+	default:
+	}
+	if err := program.Process.Signal(stop); err != nil {
+		return fmt.Errorf("stop signal: %v", err)
+	}
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(stopTimeout):
+	}
+	// the whole process group, the children too.
+	syscall.Kill(-program.Process.Pid, syscall.SIGKILL)
+	select {
+	case <-exited:
+	case <-time.After(killTimeout):
+		return fmt.Errorf("%s didn't stop, not even killed", filepath.Base(p.cfg.executable))
+	}
+	return fmt.Errorf("%s didn't stop, killed it", filepath.Base(p.cfg.executable))
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+func TestParseSignal(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want syscall.Signal
+	}{
+		{"", 0},
+		{"USR1", syscall.SIGUSR1},
+		{"usr2", syscall.SIGUSR2},
+		{"SIGTERM", syscall.SIGTERM},
+		{"sigint", syscall.SIGINT},
+		{"10", syscall.Signal(10)},
+	} {
+		got, err := parseSignal(test.s)
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %v, %v, want %v", test.s, got, err, test.want)
+		}
+	}
+	for _, s := range []string{"USR3", "SIG", "-"} {
+		if _, err := parseSignal(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), proxyConfigName)
+	cfg := proxyConfig{
+		executable: "/usr/bin/synth",
+		arguments:  joinArguments([]string{"--patch", "Warm Pad.syx", "--config=$CONFIG_FILE"}),
+		configFile: "synth.conf",
+		label:      "Pad synth",
+		saveSignal: syscall.SIGUSR1,
+		stopSignal: syscall.SIGINT,
+	}
+	if err := writeProxyConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readProxyConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cfg) {
+		t.Fatalf("got %+v, want %+v", got, cfg)
+	}
+}
+
+// TestNsmProxyConfig reads a config written by NSM's nsm-proxy and writes it back
+// the same way.
+func TestNsmProxyConfig(t *testing.T) {
+	const nsmConfig = "label\n\tPad synth\n" +
+		"executable\n\tsynth\n" +
+		"arguments\n\t--config $CONFIG_FILE\n" +
+		"config file\n\tsynth.conf\n" +
+		"save signal\n\t10\n" +
+		"stop signal\n\t15\n"
+	path := filepath.Join(t.TempDir(), proxyConfigName)
+	if err := os.WriteFile(path, []byte(nsmConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readProxyConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := proxyConfig{
+		executable: "synth",
+		arguments:  "--config $CONFIG_FILE",
+		configFile: "synth.conf",
+		label:      "Pad synth",
+		saveSignal: syscall.SIGUSR1,
+		stopSignal: syscall.SIGTERM,
+	}
+	if cfg != want {
+		t.Fatalf("got %+v, want %+v", cfg, want)
+	}
+
+	if err := writeProxyConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != nsmConfig {
+		t.Fatalf("wrote %q, want %q", data, nsmConfig)
+	}
+}
+
+func TestJoinArguments(t *testing.T) {
+	args := []string{
+		"plain", "two words", "", `quote " and \ backslash`, "$HOME", "`id`", "it's",
+		"$CONFIG_FILE", "--config=$CONFIG_FILE", "${CONFIG_FILE}.bak", `\$CONFIG_FILE`,
+	}
+	// the shell prints each argument on a line, with CONFIG_FILE set like for the program.
+	cmd := exec.Command(shell, "-c", "printf '%s\\n' "+joinArguments(args))
+	cmd.Env = []string{envConfigFile + "=/session dir/synth.conf", "HOME=/home"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"plain", "two words", "", `quote " and \ backslash`, "$HOME", "`id`", "it's",
+		"/session dir/synth.conf", "--config=/session dir/synth.conf", "/session dir/synth.conf.bak",
+		`\/session dir/synth.conf`,
+	}
+	if got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+// TestStopProgram stops programs whose children keep stderr open.
+func TestStopProgram(t *testing.T) {
+	defer func(timeout time.Duration) { stopTimeout = timeout }(stopTimeout)
+	stopTimeout = 200 * time.Millisecond
+
+	for _, test := range []struct {
+		name, arguments string
+		killed          bool
+	}{
+		{"exits", `-c 'sleep 60 & wait'`, false},
+		{"ignores the stop signal", `-c 'trap "" TERM; sleep 60 & wait'`, true},
+	} {
+		p := &proxy{NsmClient: nsm.NsmNewClient(), dir: t.TempDir(),
+			cfg: proxyConfig{executable: shell, arguments: test.arguments, stopSignal: syscall.SIGTERM}}
+		if err := p.startProgram("nTEST", "test"); err != nil {
+			t.Fatal(err)
+		}
+		pid := p.program.Process.Pid
+		time.Sleep(100 * time.Millisecond) // for the trap
+		start := time.Now()
+		err := p.stopProgram()
+		syscall.Kill(-pid, syscall.SIGKILL)
+		if (err != nil) != test.killed {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if d := time.Since(start); d > stopTimeout+killTimeout {
+			t.Errorf("%s: stopped after %v", test.name, d)
+		}
+	}
+}
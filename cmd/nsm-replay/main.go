@@ -0,0 +1,323 @@
+// nsm-replay feeds a capture written with NSM_CLIENT_CAPTURE back into an nsmclient,
+// through an in-memory connection instead of an NSM server.
+//
+//	nsm-replay [-speed 1] capture.jsonl
+//
+// The server's messages are delivered at their recorded time (divided by -speed,
+// 0 doesn't wait), the messages the application sent by itself (is_dirty, gui_is_shown, ...)
+// are sent again. The open and save callbacks fail where the application failed.
+// Every callback and message is printed, replies that differ from the capture are
+// marked and make nsm-replay exit with 1.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+const (
+	exitOk       = 0
+	exitMismatch = 1
+	exitError    = 2
+)
+
+const (
+	replayWait  = 10  // milliseconds, NsmCheckWait timeout
+	replayQuiet = 500 // milliseconds without messages after the last record
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	flags := flag.NewFlagSet("nsm-replay", flag.ContinueOnError)
+	speed := flags.Float64("speed", 1, "replay speed, 0 replays without waiting")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: nsm-replay [-speed 1] capture.jsonl\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		return exitError
+	}
+	if flags.NArg() != 1 || *speed < 0 {
+		flags.Usage()
+		return exitError
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	records, err := nsm.NsmReadCapture(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	if len(records) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: empty capture\n", flags.Arg(0))
+		return exitError
+	}
+
+	r := &replay{records: records, speed: *speed, start: time.Now(), stopped: make(chan struct{})}
+	return r.run()
+}
+
+type replay struct {
+	records []nsm.NsmCaptureRecord
+	speed   float64
+	start   time.Time
+	conn    *nsm.FakeConn
+	client  *nsm.NsmClient
+	stopped chan struct{}                     // closed after NsmStop
+	answers map[string][]nsm.NsmCaptureRecord // captured replies per request, used by the callbacks
+
+	mu         sync.Mutex
+	mismatches int
+}
+
+func (r *replay) printf(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Printf("%8.3f ", time.Since(r.start).Seconds())
+	fmt.Printf(format, args...)
+	fmt.Println()
+}
+
+func (r *replay) run() int {
+	r.conn = nsm.NewFakeConn()
+	r.client = nsm.NsmNewClient()
+	r.setCallbacks()
+	r.client.NsmSetErrorCallback(func(err error) {
+		r.printf("error %v", err)
+	})
+
+	if err := r.client.NsmInitWithConn(r.conn, r.conn.RemoteAddr()); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	defer r.client.NsmStop()
+
+	if err := r.announceSettings(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+
+	sentDone := make(chan struct{})
+	go r.compareSent(sentDone)
+	playDone := make(chan struct{})
+	go r.play(playDone)
+
+	if err := r.client.NsmAnnounce(); err != nil {
+		fmt.Fprintf(os.Stderr, "announce: %v\n", err)
+		return exitError
+	}
+
+	var quiet <-chan time.Time
+	for {
+		select {
+		case <-playDone:
+			playDone = nil
+			quiet = time.After(replayQuiet * time.Millisecond)
+		case <-quiet:
+			r.client.NsmStop()
+			close(r.stopped)
+			<-sentDone
+			if r.mismatches > 0 {
+				fmt.Printf("%d replies differ from the capture\n", r.mismatches)
+				return exitMismatch
+			}
+			return exitOk
+		default:
+		}
+		if err := r.client.NsmCheckWait(replayWait); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitError
+		}
+	}
+}
+
+func (r *replay) setCallbacks() {
+	r.answers = make(map[string][]nsm.NsmCaptureRecord)
+	for _, record := range r.records {
+		if record.Dir == nsm.NsmCaptureOut && (record.Address == nsm.NsmAddrReply || record.Address == nsm.NsmAddrError) && len(record.Args) > 0 {
+			if request, ok := record.Args[0].Value.(string); ok {
+				r.answers[request] = append(r.answers[request], record)
+			}
+		}
+	}
+
+	c := r.client
+	c.NsmSetOpenCallback(func(path, displayName, clientId string) (string, error) {
+		r.printf("callback open %q %q %q", path, displayName, clientId)
+		return r.answer(nsm.NsmAddrClientOpen)
+	})
+	c.NsmSetSaveCallback(func() (string, error) {
+		r.printf("callback save")
+		return r.answer(nsm.NsmAddrClientSave)
+	})
+	c.NsmSetSessionIsLoadedCallback(func() error {
+		r.printf("callback session_is_loaded")
+		return nil
+	})
+	c.NsmSetShowCallback(func() error {
+		r.printf("callback show_optional_gui")
+		return nil
+	})
+	c.NsmSetHideCallback(func() error {
+		r.printf("callback hide_optional_gui")
+		return nil
+	})
+}
+
+// answer fails like the application did, when the capture has an error reply to the request.
+func (r *replay) answer(request string) (string, error) {
+	answers := r.answers[request]
+	if len(answers) == 0 {
+		return "", nil
+	}
+	r.answers[request] = answers[1:]
+	if answers[0].Address != nsm.NsmAddrError || len(answers[0].Args) < 3 {
+		return "", nil
+	}
+	msg, _ := answers[0].Args[2].Value.(string)
+	return msg, errors.New(msg)
+}
+
+// announceSettings announces with the name, capabilities and executable of the captured announce.
+func (r *replay) announceSettings() error {
+	for _, record := range r.records {
+		if record.Dir != nsm.NsmCaptureOut || record.Address != nsm.NsmAddrServerAnnouce {
+			continue
+		}
+		msg, err := record.Message()
+		if err != nil {
+			return err
+		}
+		if len(msg.Arguments) < 2 {
+			return fmt.Errorf("announce with %d arguments", len(msg.Arguments))
+		}
+		name, _ := msg.Arguments[0].ReadString()
+		capabilities, _ := msg.Arguments[1].ReadString()
+		r.client.NsmSetPrettyName(name)
+		if len(msg.Arguments) > 2 {
+			if executable, err := msg.Arguments[2].ReadString(); err == nil && executable != "" {
+				r.client.NsmSetExecutableName(executable)
+			}
+		}
+		return r.client.NsmSetClientCapabilitiesString(capabilities)
+	}
+	return errors.New("capture has no announce")
+}
+
+// play delivers the recorded server messages and repeats the ones the application sent by itself.
+func (r *replay) play(done chan<- struct{}) {
+	defer close(done)
+	first := r.records[0].Time
+	afterSave := false
+	for _, record := range r.records {
+		if r.speed > 0 {
+			at := time.Duration(float64(record.Time.Sub(first)) / r.speed)
+			time.Sleep(time.Until(r.start.Add(at)))
+		}
+
+		if record.Dir == nsm.NsmCaptureIn {
+			msg, err := record.Message()
+			if err != nil {
+				r.printf("skip %v", err)
+				continue
+			}
+			r.printf("%s", record)
+			if err := r.conn.Deliver(msg); err != nil {
+				r.printf("error %v", err)
+			}
+			continue
+		}
+
+		// nsmclient sends is_clean itself after replying to a save.
+		wasAfterSave := afterSave
+		afterSave = record.Address == nsm.NsmAddrReply && len(record.Args) > 0 && record.Args[0].Value == nsm.NsmAddrClientSave
+
+		var err error
+		switch record.Address {
+		case nsm.NsmAddrClientIsDirty:
+			err = r.client.NsmSendIsDirty()
+		case nsm.NsmAddrClientIsClean:
+			if !wasAfterSave {
+				err = r.client.NsmSendIsClean()
+			}
+		case nsm.NsmAddrClientGuiIsShown:
+			err = r.client.NsmSendGuiShown()
+		case nsm.NsmAddrClientGuiIsHidden:
+			err = r.client.NsmSendGuiHidden()
+		case nsm.NsmAddrServerSave:
+			err = r.client.NsmSendServerSave()
+		case nsm.NsmAddrServerAnnouce, nsm.NsmAddrReply, nsm.NsmAddrError:
+			// answered by nsmclient, compared in compareSent.
+		default:
+			r.printf("not replayed: %s", record)
+		}
+		if err != nil {
+			r.printf("error %v", err)
+		}
+	}
+}
+
+// compareSent prints what the client sends and compares its replies to the captured ones.
+func (r *replay) compareSent(done chan<- struct{}) {
+	defer close(done)
+	var want []nsm.NsmCaptureRecord
+	for _, record := range r.records {
+		if record.Dir == nsm.NsmCaptureOut && (record.Address == nsm.NsmAddrReply || record.Address == nsm.NsmAddrError) {
+			want = append(want, record)
+		}
+	}
+
+	compare := func(got nsm.NsmCaptureRecord) {
+		switch {
+		case got.Address != nsm.NsmAddrReply && got.Address != nsm.NsmAddrError:
+			r.printf("%s", got)
+		case len(want) == 0:
+			r.countMismatch()
+			r.printf("%s  <- not in the capture", got)
+		case got.String() != want[0].String():
+			r.countMismatch()
+			r.printf("%s  <- capture has: %s", got, want[0])
+			want = want[1:]
+		default:
+			r.printf("%s", got)
+			want = want[1:]
+		}
+	}
+
+	for {
+		select {
+		case msg := <-r.conn.Sent():
+			compare(nsm.NsmCaptureRecordOf(nsm.NsmCaptureOut, msg, nil))
+		case <-r.stopped:
+			// NsmStop flushed the queue, everything sent is in the channel.
+			for len(r.conn.Sent()) > 0 {
+				compare(nsm.NsmCaptureRecordOf(nsm.NsmCaptureOut, <-r.conn.Sent(), nil))
+			}
+			for _, w := range want {
+				r.countMismatch()
+				r.printf("missing: %s", w)
+			}
+			return
+		}
+	}
+}
+
+func (r *replay) countMismatch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mismatches++
+}
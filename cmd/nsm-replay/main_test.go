@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scgolang/osc"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+// TestAnnounceSettings replays the announce of a client with its own executable name.
+func TestAnnounceSettings(t *testing.T) {
+	captured := osc.Message{Address: nsm.NsmAddrServerAnnouce, Arguments: osc.Arguments{
+		osc.String("Carla"), osc.String(":dirty:"), osc.String("carla-rack"),
+		osc.Int(1), osc.Int(1), osc.Int(4242)}}
+	r := &replay{records: []nsm.NsmCaptureRecord{nsm.NsmCaptureRecordOf(nsm.NsmCaptureOut, captured, nil)}}
+	r.conn = nsm.NewFakeConn()
+	r.client = nsm.NsmNewClient()
+	if err := r.client.NsmInitWithConn(r.conn, r.conn.RemoteAddr()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.client.NsmStop() })
+	if err := r.announceSettings(); err != nil {
+		t.Fatal(err)
+	}
+
+	announced := r.conn.AcceptAnnounce("fake", "")
+	r.client.NsmSetAnnounceTimeout(2000)
+	if err := r.client.NsmAnnounce(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-announced:
+		for i, want := range []string{"Carla", ":dirty:", "carla-rack"} {
+			if got, _ := msg.Arguments[i].ReadString(); got != want {
+				t.Errorf("announce argument %d: %q, want %q", i, got, want)
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no announce")
+	}
+}
@@ -0,0 +1,76 @@
+// nsmd is an NSM session daemon, it takes the flags of the nsmd of New Session Manager.
+//
+//	nsmd [-session-root dir] [-osc-port port] [-load-session name]
+//
+// It prints NSM_URL for the clients and GUIs to connect with. SIGTERM and SIGINT
+// quit the clients of the open session without saving, like /nsm/server/abort.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	nsm "nsm-notes/nsmclient"
+	"nsm-notes/nsmserver"
+)
+
+const defaultSessionRoot = "NSM Sessions" // in the home directory
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	flags := flag.NewFlagSet("nsmd", flag.ContinueOnError)
+	root := flags.String("session-root", "", "session root directory (default ~/"+defaultSessionRoot+")")
+	port := flags.Int("osc-port", 0, "OSC port, 0 picks a free one")
+	load := flags.String("load-session", "", "session to open at start")
+	if err := flags.Parse(os.Args[1:]); err != nil || flags.NArg() != 0 {
+		return 2
+	}
+
+	if *root == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		*root = filepath.Join(home, defaultSessionRoot)
+	}
+
+	s, err := nsmserver.New(nsmserver.Config{SessionRoot: *root, Listen: ":" + strconv.Itoa(*port)})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("%s=%s\n", nsm.NsmEnvUrl, s.Url())
+
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	if *load != "" {
+		if err := s.Open(*load); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+	status := 0
+	select {
+	case sig := <-signals:
+		fmt.Fprintf(os.Stderr, "got %v, closing the session\n", sig)
+	case <-s.Done():
+	case err := <-served:
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		status = 1
+	}
+	if err := s.Close(); err != nil && status == 0 {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	return status
+}
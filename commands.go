@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/commands"
+)
+
+// command is an action that shows up in the menu bar and the command palette.
+type command struct {
+	id       string // used in the shortcuts file
+	menuPath string // fltk menu path, e.g. "&File/&Save"
+	shortcut int
+	action   func()
+}
+
+// title is the menu path as shown in the command palette, "File: Save".
+func (c *command) title() string {
+	return strings.ReplaceAll(strings.ReplaceAll(c.menuPath, "&", ""), "/", ": ")
+}
+
+type commandRegistry struct {
+	commands []*command
+}
+
+func (r *commandRegistry) register(id, menuPath string, shortcut int, action func()) {
+	r.commands = append(r.commands, &command{id: id, menuPath: menuPath, shortcut: shortcut, action: action})
+}
+
+func (r *commandRegistry) lookup(id string) *command {
+	for _, c := range r.commands {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (r *commandRegistry) addToMenu(m *fltk.MenuBar) {
+	for _, c := range r.commands {
+		action := c.action
+		m.AddEx(c.menuPath, c.shortcut, func() { action() }, 0)
+	}
+}
+
+// loadShortcuts overrides the default shortcuts with lines like "file.save = Ctrl+S".
+// A shortcut of "none" removes it. A missing file is not an error.
+func (r *commandRegistry) loadShortcuts(path string) error {
+	entries, err := readConfFile(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		c := r.lookup(e.key)
+		if c == nil {
+			return fmt.Errorf("%s:%d: unknown command %q", path, e.line, e.key)
+		}
+		shortcut, err := shortcutKeys().ParseShortcut(e.value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, e.line, err)
+		}
+		c.shortcut = shortcut
+	}
+	return nil
+}
+
+// shortcutKeys are fltk's modifiers and keys, by the names of the shortcuts file.
+func shortcutKeys() commands.Keys {
+	keys := map[string]int{
+		"esc": fltk.ESCAPE, "tab": fltk.TAB, "enter": fltk.ENTER_KEY,
+		"home": fltk.HOME, "end": fltk.END, "pageup": fltk.PAGE_UP, "pagedown": fltk.PAGE_DOWN,
+		"left": fltk.LEFT, "right": fltk.RIGHT, "up": fltk.UP, "down": fltk.DOWN,
+		"delete": fltk.DELETE, "backspace": fltk.BACKSPACE, "insert": fltk.INSERT,
+		"plus": '+', "minus": '-', "space": ' ',
+	}
+	for i, f := range []int{fltk.F1, fltk.F2, fltk.F3, fltk.F4, fltk.F5, fltk.F6, fltk.F7, fltk.F8, fltk.F9, fltk.F10, fltk.F11, fltk.F12} {
+		keys[fmt.Sprintf("f%d", i+1)] = f
+	}
+	return commands.Keys{Ctrl: fltk.CTRL, Shift: fltk.SHIFT, Alt: fltk.ALT, Named: keys}
+}
@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"strings"
+	"unicode"
+)
+
+// FuzzyScore matches the letters of pattern in order against s, case insensitive.
+// Consecutive letters and letters at the start of a word score higher.
+func FuzzyScore(pattern, s string) (int, bool) {
+	p := []rune(strings.ToLower(pattern))
+	t := []rune(strings.ToLower(s))
+
+	score, pi, prev := 0, 0, -2
+	for ti := 0; ti < len(t) && pi < len(p); ti++ {
+		if p[pi] == ' ' {
+			pi++
+			if pi == len(p) {
+				break
+			}
+		}
+		if t[ti] != p[pi] {
+			continue
+		}
+		score++
+		if ti == prev+1 {
+			score += 2
+		}
+		if ti == 0 || !unicode.IsLetter(t[ti-1]) {
+			score += 3
+		}
+		prev = ti
+		pi++
+	}
+	if pi < len(p) {
+		return 0, false
+	}
+	return score, true
+}
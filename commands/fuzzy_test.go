@@ -0,0 +1,35 @@
+package commands
+
+import "testing"
+
+func TestFuzzyScore(t *testing.T) {
+	for _, test := range []struct {
+		pattern, s string
+		ok         bool
+	}{
+		{"", "File: Save", true},
+		{"save", "File: Save", true},
+		{"fs", "File: Save", true},
+		{"f s", "File: Save", true},
+		{"SAVE", "File: Save", true},
+		{"sf", "File: Save", false},
+		{"saves", "File: Save", false},
+	} {
+		if _, ok := FuzzyScore(test.pattern, test.s); ok != test.ok {
+			t.Errorf("%q in %q: %t, want %t", test.pattern, test.s, ok, test.ok)
+		}
+	}
+
+	// consecutive letters and word starts go first.
+	for _, test := range []struct{ pattern, better, worse string }{
+		{"zo", "View: Zoom in", "View: Zen mode"},
+		{"s", "File: Save", "Edit: Paste"},
+		{"fs", "View: Font size: 12", "View: Font: Helvetica, 12 pt"},
+	} {
+		b, _ := FuzzyScore(test.pattern, test.better)
+		w, _ := FuzzyScore(test.pattern, test.worse)
+		if b <= w {
+			t.Errorf("%q: %q scores %d, %q %d", test.pattern, test.better, b, test.worse, w)
+		}
+	}
+}
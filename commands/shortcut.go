@@ -0,0 +1,80 @@
+// Package commands has the parts of the menu commands that don't need fltk:
+// the shortcuts of the shortcuts file and the matching of the command palette.
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Keys are the toolkit's values of the modifiers and the named keys.
+type Keys struct {
+	Ctrl, Shift, Alt int
+	Named            map[string]int // by lower case name, like "pageup"
+}
+
+// ParseShortcut parses "Ctrl+Shift+P" style shortcuts, "none" and "" are no shortcut.
+func (k Keys) ParseShortcut(s string) (int, error) {
+	if strings.EqualFold(s, "none") || s == "" {
+		return 0, nil
+	}
+
+	var shortcut int
+	parts := strings.Split(s, "+")
+	for i, p := range parts {
+		if p == "" && i == len(parts)-1 { // "Ctrl++"
+			p = "+"
+		}
+		switch strings.ToLower(p) {
+		case "ctrl":
+			shortcut += k.Ctrl
+		case "shift":
+			shortcut += k.Shift
+		case "alt":
+			shortcut += k.Alt
+		case "":
+		default:
+			if i != len(parts)-1 {
+				return 0, fmt.Errorf("bad shortcut %q", s)
+			}
+			if key, ok := k.Named[strings.ToLower(p)]; ok {
+				return shortcut + key, nil
+			}
+			if r := []rune(p); len(r) == 1 {
+				return shortcut + int(unicode.ToLower(r[0])), nil
+			}
+			return 0, fmt.Errorf("unknown key %q in shortcut %q", p, s)
+		}
+	}
+	return 0, fmt.Errorf("shortcut %q has no key", s)
+}
+
+// ShortcutString is the reverse of ParseShortcut.
+func (k Keys) ShortcutString(shortcut int) string {
+	if shortcut == 0 {
+		return ""
+	}
+
+	var parts []string
+	for _, m := range []struct {
+		mask int
+		name string
+	}{{k.Ctrl, "Ctrl"}, {k.Alt, "Alt"}, {k.Shift, "Shift"}} {
+		if shortcut&m.mask != 0 {
+			parts = append(parts, m.name)
+			shortcut &^= m.mask
+		}
+	}
+
+	key := ""
+	for name, named := range k.Named {
+		if named == shortcut {
+			key = strings.ToUpper(name[:1]) + name[1:]
+		}
+	}
+	if key == "" {
+		key = strings.ToUpper(string(rune(shortcut)))
+	}
+	return strings.Join(append(parts, key), "+")
+}
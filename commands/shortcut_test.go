@@ -0,0 +1,60 @@
+package commands
+
+import "testing"
+
+// testKeys has fltk's values.
+var testKeys = Keys{Ctrl: 0x40000, Shift: 0x10000, Alt: 0x80000,
+	Named: map[string]int{"plus": '+', "minus": '-', "pageup": 0xff55, "f1": 0xffbe}}
+
+func TestParseShortcut(t *testing.T) {
+	k := testKeys
+	for _, test := range []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"None", 0},
+		{"Ctrl+S", k.Ctrl + 's'},
+		{"ctrl+shift+p", k.Ctrl + k.Shift + 'p'},
+		{"Alt+PageUp", k.Alt + 0xff55},
+		{"F1", 0xffbe},
+		// the zoom in default, '=' isn't the shifted '+'.
+		{"Ctrl+=", k.Ctrl + '='},
+		{"Ctrl++", k.Ctrl + '+'},
+		{"Ctrl+Plus", k.Ctrl + '+'},
+		{"Ctrl+-", k.Ctrl + '-'},
+	} {
+		got, err := k.ParseShortcut(test.s)
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %#x, %v, want %#x", test.s, got, err, test.want)
+		}
+	}
+	for _, s := range []string{"Ctrl", "Ctrl+Shift", "S+Ctrl", "Ctrl+Home2"} {
+		if _, err := k.ParseShortcut(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestShortcutString(t *testing.T) {
+	k := testKeys
+	for _, test := range []struct {
+		shortcut int
+		want     string
+	}{
+		{0, ""},
+		{k.Ctrl + 's', "Ctrl+S"},
+		{k.Ctrl + k.Shift + 'p', "Ctrl+Shift+P"},
+		{k.Ctrl + '=', "Ctrl+="},
+		{k.Ctrl + '+', "Ctrl+Plus"},
+		{k.Alt + 0xff55, "Alt+Pageup"},
+	} {
+		got := k.ShortcutString(test.shortcut)
+		if got != test.want {
+			t.Errorf("%#x: got %q, want %q", test.shortcut, got, test.want)
+		}
+		if back, err := k.ParseShortcut(got); err != nil || back != test.shortcut {
+			t.Errorf("%q: parsed back to %#x, %v", got, back, err)
+		}
+	}
+}
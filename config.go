@@ -4,7 +4,7 @@ const (
 	hideWinAtLaunch    = true
 	fltkScheme         = "gtk+" // "oxy"
 	wrapTextAtLine     = 40
-	resizableWin       = false
+	resizableWin       = true
 	windowColor        = 41
 	widgetHeight       = 300
 	widgetWidth        = 320
@@ -22,6 +22,70 @@ const (
 	fltkScreen         = 0
 )
 
+const (
+	statusBarHeight    = 12
+	statusBarLabelSize = 10
+	statusFieldLength  = 32 // characters of the capabilities, display name and error in the status bar
+)
+
+const (
+	menuBarHeight     = 20
+	exportSessionMeta = true // add session manager, display name and client id to exports
+)
+
+const (
+	minWidgetWidth  = 200
+	minWidgetHeight = 150
+	defaultFont     = "helvetica"  // see editorFonts
+	undoSuffix      = ".undo.json" // with undo.persist, the undo history is stored in the notes file name plus this
+)
+
+const (
+	paletteWidth       = 360
+	paletteHeight      = 240
+	paletteInputHeight = 25
+	logWidth           = 480
+	logHeight          = 240
+	clientNotesWidth   = 160
+	configDirName      = "nsm-notes" // in the user config dir
+	shortcutsFileName  = "shortcuts.conf"
+	settingsFileName   = "nsm-notes.conf"
+	defaultSessionRoot = "NSM Sessions" // in the home directory, like nsmd
+)
+
+const (
+	attachmentsDirSuffix = ".attachments" // the attachments directory is the notes file name plus this
+	attachmentsWidth     = 240
+	attachmentsHeight    = 300
+	thumbnailSize        = 32
+)
+
+const (
+	metaFormWidth   = 320
+	metaLabelWidth  = 60
+	metaInputHeight = 25
+)
+
+const (
+	passphraseWidth       = 320
+	passphraseHeight      = 185
+	passphraseInputHeight = 25
+)
+
+const (
+	personalWordsFileName = "words.txt" // the personal spell check word list
+	defaultSpellLanguage  = "en_US"
+	maxSpellSuggestions   = 8
+)
+
+const (
+	headlessWait = 100 // milliseconds, NsmCheckWait timeout without a gui
+)
+
+const (
+	fileWatchPollInterval = 1000 // milliseconds, only used where inotify is not available
+)
+
 const (
 	APP_TITLE = "NSM-Notes"
 )
@@ -0,0 +1,173 @@
+// Package crypt encrypts notes files with a key derived from a passphrase,
+// argon2id for the key and AES-256-GCM for the text.
+//
+// An encrypted file is text: a header line with the key derivation parameters
+// and the salt, then the nonce and the sealed notes in base64. The header is
+// authenticated with the notes.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	Header = "nsm-notes encrypted 1" // the first line of an encrypted file starts with it
+
+	saltSize   = 16
+	keySize    = 32
+	lineLength = 64 // of the base64 lines
+)
+
+// the argon2id parameters of new keys, the RFC 9106 second recommendation.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+)
+
+// the limits of the parameters read from a header, a damaged or hostile file
+// mustn't keep the program busy for hours or take all the memory.
+const (
+	maxArgonTime    = 64
+	maxArgonMemory  = 1024 * 1024 // KiB
+	maxArgonThreads = 16
+)
+
+var (
+	ErrPassphrase   = errors.New("wrong passphrase or damaged notes")
+	ErrNotEncrypted = errors.New("the notes are not encrypted")
+	ErrOtherKey     = errors.New("the notes were encrypted with another passphrase")
+)
+
+// Key encrypts and decrypts the notes of one passphrase and salt.
+type Key struct {
+	header string // the header line, with the salt
+	aead   cipher.AEAD
+}
+
+// IsEncrypted reports whether data is an encrypted notes file.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Header+" "))
+}
+
+// NewKey derives a key with a new salt, for notes that weren't encrypted
+// before or get a new passphrase.
+func NewKey(passphrase string) (*Key, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	header := fmt.Sprintf("%s argon2id t=%d m=%d p=%d %s", Header, argonTime, argonMemory, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt))
+	return deriveKey(header, passphrase)
+}
+
+// Unlock derives the key of the encrypted notes data and decrypts them.
+// The key encrypts the notes again on save, without asking for the passphrase.
+func Unlock(data []byte, passphrase string) (*Key, []byte, error) {
+	header, _, err := split(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := deriveKey(header, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := k.Decrypt(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k, plain, nil
+}
+
+func deriveKey(header, passphrase string) (*Key, error) {
+	var (
+		time, memory uint32
+		threads      uint8
+		salt64       string
+	)
+	rest := strings.TrimPrefix(header, Header+" ")
+	if _, err := fmt.Sscanf(rest, "argon2id t=%d m=%d p=%d %s", &time, &memory, &threads, &salt64); err != nil {
+		return nil, fmt.Errorf("header %q: %v", header, err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(salt64)
+	if err != nil || len(salt) < saltSize {
+		return nil, fmt.Errorf("header %q: bad salt", header)
+	}
+	if time == 0 || time > maxArgonTime || threads == 0 || threads > maxArgonThreads || memory > maxArgonMemory {
+		return nil, fmt.Errorf("header %q: bad parameters", header)
+	}
+
+	block, err := aes.NewCipher(argon2.IDKey([]byte(passphrase), salt, time, memory, threads, keySize))
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	return &Key{header: header, aead: aead}, nil
+}
+
+// split returns the header line and the decoded nonce and sealed notes.
+func split(data []byte) (string, []byte, error) {
+	if !IsEncrypted(data) {
+		return "", nil, ErrNotEncrypted
+	}
+	header, body, _ := strings.Cut(string(data), "\n")
+	header = strings.TrimSuffix(header, "\r")
+	sealed, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrPassphrase, err)
+	}
+	return header, sealed, nil
+}
+
+// Encrypt seals plain with a new nonce.
+func (k *Key) Encrypt(plain []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	body := base64.StdEncoding.EncodeToString(k.aead.Seal(nonce, nonce, plain, []byte(k.header)))
+
+	var b strings.Builder
+	b.WriteString(k.header + "\n")
+	for len(body) > lineLength {
+		b.WriteString(body[:lineLength] + "\n")
+		body = body[lineLength:]
+	}
+	b.WriteString(body + "\n")
+	return []byte(b.String()), nil
+}
+
+// Decrypt opens data encrypted with k. Notes encrypted with another passphrase
+// or salt, like after a passphrase change by another program, give ErrOtherKey.
+func (k *Key) Decrypt(data []byte) ([]byte, error) {
+	header, sealed, err := split(data)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(header), []byte(k.header)) != 1 {
+		return nil, ErrOtherKey
+	}
+	n := k.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, ErrPassphrase
+	}
+	plain, err := k.aead.Open(nil, sealed[:n], sealed[n:], []byte(header))
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return plain, nil
+}
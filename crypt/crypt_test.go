@@ -0,0 +1,130 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	k, err := NewKey("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := []byte("Carla rack: reverb preset B\n" + strings.Repeat("lyrics ", 100))
+	data, err := k.Encrypt(notes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(data) || IsEncrypted(notes) {
+		t.Fatal("IsEncrypted doesn't tell the files apart")
+	}
+	if bytes.Contains(data, []byte("reverb")) {
+		t.Fatal("notes in plain text")
+	}
+
+	unlocked, plain, err := Unlock(data, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, notes) {
+		t.Fatalf("got %q", plain)
+	}
+
+	// saves after the unlock don't need the passphrase.
+	again, err := unlocked.Encrypt([]byte("changed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, data) {
+		t.Fatal("nonce reused")
+	}
+	if plain, err := k.Decrypt(again); err != nil || string(plain) != "changed" {
+		t.Fatalf("got %q, %v", plain, err)
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	k, err := NewKey("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := k.Encrypt([]byte("notes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Unlock(data, "battery staple"); !errors.Is(err, ErrPassphrase) {
+		t.Fatalf("err = %v, want %v", err, ErrPassphrase)
+	}
+	if _, _, err := Unlock([]byte("notes"), "correct horse"); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("err = %v, want %v", err, ErrNotEncrypted)
+	}
+
+	other, err := NewKey("correct horse") // another salt
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Decrypt(data); !errors.Is(err, ErrOtherKey) {
+		t.Fatalf("err = %v, want %v", err, ErrOtherKey)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	k, err := NewKey("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := k.Encrypt([]byte("notes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, body, _ := strings.Cut(string(data), "\n")
+
+	flipped := []byte(body)
+	if flipped[10] == 'A' {
+		flipped[10] = 'B'
+	} else {
+		flipped[10] = 'A'
+	}
+	for name, tampered := range map[string]string{
+		"body":       header + "\n" + string(flipped),
+		"truncated":  header + "\n" + body[:8] + "\n",
+		"parameters": strings.Replace(header, "t=3", "t=2", 1) + "\n" + body,
+		"salt":       header[:len(header)-2] + "AA\n" + body,
+	} {
+		if _, _, err := Unlock([]byte(tampered), "correct horse"); err == nil {
+			t.Errorf("%s: tampered notes opened", name)
+		}
+	}
+}
+
+func TestOversizedParameters(t *testing.T) {
+	k, err := NewKey("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := k.Encrypt([]byte("notes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, body, _ := strings.Cut(string(data), "\n")
+
+	for _, params := range []string{
+		"t=4294967295 m=65536 p=4",
+		"t=65 m=65536 p=4",
+		"t=3 m=4194304 p=4",
+		"t=3 m=65536 p=255",
+		"t=3 m=65536 p=17",
+		"t=0 m=65536 p=4",
+	} {
+		oversized := strings.Replace(header, "t=3 m=65536 p=4", params, 1)
+		if oversized == header {
+			t.Fatalf("no parameters in %q", header)
+		}
+		_, _, err := Unlock([]byte(oversized+"\n"+body), "correct horse")
+		if err == nil || !strings.Contains(err.Error(), "bad parameters") {
+			t.Errorf("%s: err = %v", params, err)
+		}
+	}
+}
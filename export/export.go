@@ -0,0 +1,243 @@
+// Package export renders session notes to a self-contained html page, or bundles
+// them with the files they reference into a zip archive.
+package export
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"nsm-notes/frontmatter"
+)
+
+type Format string
+
+const (
+	FormatHTML   Format = "html"
+	FormatBundle Format = "zip"
+)
+
+const (
+	bundleNotesName = "notes.md"
+	bundleMetaName  = "session.json"
+)
+
+// Meta is the optional session information, as told by the NSM server.
+type Meta struct {
+	SessionManager string `json:"session_manager"`
+	DisplayName    string `json:"display_name"`
+	ClientId       string `json:"client_id"`
+}
+
+type Options struct {
+	Title string
+	Meta  *Meta // optional
+}
+
+var htmlPage = template.Must(template.New("page").Funcs(template.FuncMap{"join": strings.Join}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+{{with .Front.Tags}}<meta name="keywords" content="{{join . ", "}}">
+{{end}}<style>
+body { font-family: sans-serif; max-width: 48em; margin: 2em auto; padding: 0 1em; line-height: 1.4; }
+pre { background: #f4f4f4; padding: .5em; overflow-x: auto; }
+code { background: #f4f4f4; }
+blockquote { border-left: 3px solid #ccc; margin-left: 0; padding-left: 1em; color: #555; }
+img { max-width: 100%; }
+dl.meta { font-size: small; color: #555; border-bottom: 1px solid #ccc; padding-bottom: 1em; }
+dl.meta dt { float: left; clear: left; width: 10em; }
+dl.front dt { float: left; clear: left; width: 6em; font-weight: bold; }
+</style>
+</head>
+<body>
+{{with .Meta}}<dl class="meta">
+<dt>Session manager</dt><dd>{{.SessionManager}}</dd>
+<dt>Client</dt><dd>{{.DisplayName}}</dd>
+<dt>Client ID</dt><dd>{{.ClientId}}</dd>
+</dl>
+{{end}}{{with .Front}}{{if or .Artist .Tempo .Key .Status .Tags}}<dl class="front">
+{{with .Artist}}<dt>Artist</dt><dd>{{.}}</dd>
+{{end}}{{with .Tempo}}<dt>Tempo</dt><dd>{{.}}</dd>
+{{end}}{{with .Key}}<dt>Key</dt><dd>{{.}}</dd>
+{{end}}{{with .Status}}<dt>Status</dt><dd>{{.}}</dd>
+{{end}}{{with .Tags}}<dt>Tags</dt><dd>{{join . ", "}}</dd>
+{{end}}</dl>
+{{end}}{{end}}{{.Body}}</body>
+</html>
+`))
+
+// HTML writes notes as a single html page. Images that refer to local files
+// relative to baseDir are embedded, so the page doesn't depend on other files.
+// The front matter is shown above the notes, its tags become keywords.
+func HTML(w io.Writer, notes, baseDir string, opts Options) error {
+	front, _, notes := frontmatter.Split(notes)
+	body := renderMarkdown(notes, func(target string) string {
+		p, ok := localPath(baseDir, target)
+		if !ok {
+			return target
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return target
+		}
+		mimeType := mime.TypeByExtension(filepath.Ext(p))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	})
+
+	return htmlPage.Execute(w, struct {
+		Title string
+		Meta  *Meta
+		Front frontmatter.Meta
+		Body  template.HTML
+	}{opts.Title, opts.Meta, front, template.HTML(body)})
+}
+
+// Bundle writes a zip archive with the notes, front matter included, and every local
+// file the notes link to, stored under the same relative path so the links keep working.
+func Bundle(w io.Writer, notes, baseDir string, opts Options) error {
+	zw := zip.NewWriter(w)
+
+	if err := zipWriteBytes(zw, bundleNotesName, []byte(notes)); err != nil {
+		return err
+	}
+
+	if opts.Meta != nil {
+		meta, err := json.MarshalIndent(opts.Meta, "", "  ")
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+		if err := zipWriteBytes(zw, bundleMetaName, meta); err != nil {
+			return err
+		}
+	}
+
+	// "a.png" and "./a.png" are the same entry.
+	written := make(map[string]bool)
+	for _, ref := range references(notes) {
+		p, ok := localPath(baseDir, ref)
+		if !ok {
+			continue
+		}
+		name := path.Clean(refPath(ref))
+		if written[name] {
+			continue
+		}
+		written[name] = true
+		if err := zipWriteFile(zw, name, p); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// WriteFile exports notes to outPath in the given format.
+func WriteFile(outPath string, format Format, notes, baseDir string, opts Options) error {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+
+	switch format {
+	case FormatHTML:
+		err = HTML(f, notes, baseDir, opts)
+	case FormatBundle:
+		err = Bundle(f, notes, baseDir, opts)
+	default:
+		err = fmt.Errorf("unknown export format: %s", format)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(outPath)
+	}
+	return err
+}
+
+// localPath resolves a link target to a regular file inside baseDir.
+// Urls, absolute paths and paths leaving baseDir, also through symlinks, are not local.
+func localPath(baseDir, target string) (string, bool) {
+	if u, err := url.Parse(target); err != nil || u.Scheme != "" || u.Host != "" {
+		return "", false
+	}
+	rel := refPath(target)
+	if rel == "" || path.IsAbs(rel) || filepath.IsAbs(rel) {
+		return "", false
+	}
+	rel = path.Clean(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	p := filepath.Join(baseDir, filepath.FromSlash(rel))
+	if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	// symlinks in baseDir may point out of it.
+	realBase, err := filepath.EvalSymlinks(baseDir)
+	if err != nil {
+		return "", false
+	}
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", false
+	}
+	if rel, err := filepath.Rel(realBase, real); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return p, true
+}
+
+// refPath strips the fragment or query of a link target and undoes url escaping.
+func refPath(target string) string {
+	if i := strings.IndexAny(target, "#?"); i >= 0 {
+		target = target[:i]
+	}
+	if p, err := url.PathUnescape(target); err == nil {
+		return p
+	}
+	return target
+}
+
+func zipWriteBytes(zw *zip.Writer, name string, data []byte) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
+
+func zipWriteFile(zw *zip.Writer, name, p string) error {
+	src, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	defer src.Close()
+
+	f, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
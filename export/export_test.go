@@ -0,0 +1,120 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, p, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	base := t.TempDir()
+	writeFile(t, filepath.Join(base, "a.png"), "a")
+	writeFile(t, filepath.Join(base, "takes/take 1.wav"), "take")
+	writeFile(t, filepath.Join(filepath.Dir(base), "outside.png"), "outside")
+	for link, target := range map[string]string{
+		"inside.png":   "a.png",
+		"link.png":     filepath.Join(filepath.Dir(base), "outside.png"),
+		"linkdir":      filepath.Dir(base),
+		"takes/up.png": "../../outside.png",
+	} {
+		if err := os.Symlink(target, filepath.Join(base, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		target string
+		want   string // relative to base, empty when not local
+	}{
+		{"a.png", "a.png"},
+		{"./a.png", "a.png"},
+		{"a.png#frag", "a.png"},
+		{"takes/take%201.wav", "takes/take 1.wav"},
+		{"takes/../a.png", "a.png"},
+		{"../outside.png", ""},
+		{"takes/../../outside.png", ""},
+		{filepath.Join(base, "a.png"), ""},
+		{"/a.png", ""},
+		{"file:///a.png", ""},
+		{"https://example.org/a.png", ""},
+		{"missing.png", ""},
+		{"takes", ""},
+		{"inside.png", "inside.png"},
+		{"link.png", ""},
+		{"linkdir/outside.png", ""},
+		{"takes/up.png", ""},
+	} {
+		p, ok := localPath(base, test.target)
+		want := ""
+		if test.want != "" {
+			want = filepath.Join(base, filepath.FromSlash(test.want))
+		}
+		if ok != (want != "") || p != want {
+			t.Errorf("%q: got %q, %v, want %q", test.target, p, ok, want)
+		}
+	}
+}
+
+func TestBundle(t *testing.T) {
+	base := t.TempDir()
+	writeFile(t, filepath.Join(base, "a.png"), "a")
+	writeFile(t, filepath.Join(base, "takes/take 1.wav"), "take")
+	writeFile(t, filepath.Join(filepath.Dir(base), "outside.png"), "outside")
+	for link, target := range map[string]string{
+		"inside.png":   "a.png",
+		"link.png":     filepath.Join(filepath.Dir(base), "outside.png"),
+		"linkdir":      filepath.Dir(base),
+		"takes/up.png": "../../outside.png",
+	} {
+		if err := os.Symlink(target, filepath.Join(base, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notes := "![a](a.png) ![same](./a.png) [take](takes/take%201.wav) [same take](takes/take 1.wav)\n" +
+		"[out](../outside.png) [web](https://example.org/b.png) [gone](missing.md)\n"
+
+	var buf bytes.Buffer
+	if err := Bundle(&buf, notes, base, Options{Meta: &Meta{ClientId: "nABCD"}}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	var names []string
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, f.Name)
+		got[f.Name] = string(data)
+	}
+	want := []string{bundleNotesName, bundleMetaName, "a.png", "takes/take 1.wav"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("entries %q, want %q", names, want)
+	}
+	if got[bundleNotesName] != notes || got["a.png"] != "a" || got["takes/take 1.wav"] != "take" {
+		t.Fatalf("contents %q", got)
+	}
+}
@@ -0,0 +1,197 @@
+package export
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	mdHeading   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdHeadingID = regexp.MustCompile(`^(.*?)\s*\{#([\w-]+)\}$`) // "## Carla {#client-nABCD}"
+	mdRule      = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+	mdBullet    = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdOrdered   = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdQuote     = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	mdFence     = regexp.MustCompile("^\\s*(```|~~~)")
+	mdImage     = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+	mdLink      = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdBold      = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	mdItalic    = regexp.MustCompile(`\*([^*]+)\*`)
+	mdReference = regexp.MustCompile(`\]\(([^)\s]+)\)`)
+
+	mdPlaceholder = regexp.MustCompile(`\x00\d+\x00`) // a rendered link or image in renderInline
+)
+
+const (
+	mdPlaceholderMark = "\x00"
+	mdCodeTicks       = "`"
+	mdLineBreak       = "<br>\n"
+)
+
+// renderMarkdown renders the subset of Markdown that is common in notes: headings (with {#id}), lists,
+// quotes, rules, fenced code, links, images, bold, italic and inline code.
+// Single newlines are kept as line breaks. src may rewrite image sources.
+func renderMarkdown(notes string, src func(target string) string) string {
+	var (
+		sb     strings.Builder
+		block  string // the open block element, if any
+		inCode bool
+	)
+
+	closeBlock := func() {
+		if block != "" {
+			sb.WriteString("</" + block + ">\n")
+			block = ""
+		}
+	}
+	openBlock := func(b string) {
+		if block == b {
+			return
+		}
+		closeBlock()
+		sb.WriteString("<" + b + ">\n")
+		block = b
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(notes, "\r\n", "\n"), "\n") {
+		if mdFence.MatchString(line) {
+			if inCode {
+				sb.WriteString("</code></pre>\n")
+			} else {
+				closeBlock()
+				sb.WriteString("<pre><code>")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			sb.WriteString(html.EscapeString(line) + "\n")
+			continue
+		}
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			closeBlock()
+		case mdHeading.MatchString(line):
+			closeBlock()
+			m := mdHeading.FindStringSubmatch(line)
+			n := string('0' + rune(len(m[1])))
+			text, attr := m[2], ""
+			if id := mdHeadingID.FindStringSubmatch(text); id != nil {
+				text, attr = id[1], ` id="`+id[2]+`"`
+			}
+			sb.WriteString("<h" + n + attr + ">" + renderInline(text, src) + "</h" + n + ">\n")
+		case mdRule.MatchString(line):
+			closeBlock()
+			sb.WriteString("<hr>\n")
+		case mdBullet.MatchString(line):
+			openBlock("ul")
+			sb.WriteString("<li>" + renderInline(mdBullet.FindStringSubmatch(line)[1], src) + "</li>\n")
+		case mdOrdered.MatchString(line):
+			openBlock("ol")
+			sb.WriteString("<li>" + renderInline(mdOrdered.FindStringSubmatch(line)[1], src) + "</li>\n")
+		case mdQuote.MatchString(line):
+			if block == "blockquote" {
+				sb.WriteString(mdLineBreak)
+			}
+			openBlock("blockquote")
+			sb.WriteString(renderInline(mdQuote.FindStringSubmatch(line)[1], src))
+		default:
+			if block == "p" {
+				sb.WriteString(mdLineBreak)
+			}
+			openBlock("p")
+			sb.WriteString(renderInline(line, src))
+		}
+	}
+
+	if inCode {
+		sb.WriteString("</code></pre>\n")
+	}
+	closeBlock()
+
+	return sb.String()
+}
+
+// renderInline renders one line of text, code spans are left untouched. Links
+// and images are built first and kept apart, emphasis markers in their targets
+// stay as they are.
+func renderInline(text string, src func(string) string) string {
+	var sb strings.Builder
+	for i, part := range strings.Split(text, mdCodeTicks) {
+		if i%2 == 1 {
+			sb.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+
+		var tags []string
+		resolve := func(s string) string {
+			return mdPlaceholder.ReplaceAllStringFunc(s, func(m string) string {
+				n, _ := strconv.Atoi(strings.Trim(m, mdPlaceholderMark))
+				return tags[n]
+			})
+		}
+		keep := func(tag string) string {
+			tags = append(tags, resolve(tag))
+			return mdPlaceholderMark + strconv.Itoa(len(tags)-1) + mdPlaceholderMark
+		}
+
+		s := html.EscapeString(strings.ReplaceAll(part, mdPlaceholderMark, ""))
+		s = mdImage.ReplaceAllStringFunc(s, func(m string) string {
+			sm := mdImage.FindStringSubmatch(m)
+			target := html.UnescapeString(sm[2])
+			if !allowedTarget(target) {
+				return keep(m)
+			}
+			if src != nil {
+				target = src(target)
+			}
+			return keep(`<img alt="` + sm[1] + `" src="` + html.EscapeString(target) + `">`)
+		})
+		s = mdLink.ReplaceAllStringFunc(s, func(m string) string {
+			sm := mdLink.FindStringSubmatch(m)
+			target := html.UnescapeString(sm[2])
+			if !allowedTarget(target) {
+				return keep(m)
+			}
+			return keep(`<a href="` + html.EscapeString(target) + `">` + renderEmphasis(sm[1]) + `</a>`)
+		})
+		sb.WriteString(resolve(renderEmphasis(s)))
+	}
+	return sb.String()
+}
+
+func renderEmphasis(s string) string {
+	s = mdBold.ReplaceAllString(s, "<strong>$1</strong>")
+	return mdItalic.ReplaceAllString(s, "<em>$1</em>")
+}
+
+// allowedTarget accepts web and mail links and relative paths. The page is
+// given to others, other schemes like javascript: are shown as text.
+func allowedTarget(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// references returns the link and image targets in notes, in order of appearance.
+func references(notes string) []string {
+	var refs []string
+	seen := make(map[string]bool)
+	for _, m := range mdReference.FindAllStringSubmatch(notes, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			refs = append(refs, m[1])
+		}
+	}
+	return refs
+}
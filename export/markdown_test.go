@@ -0,0 +1,45 @@
+package export
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	for _, test := range []struct {
+		name, notes, want string
+	}{
+		{"heading", "## Carla {#client-nABCD}", "<h2 id=\"client-nABCD\">Carla</h2>\n"},
+		{"paragraph", "reverb\npreset B", "<p>\nreverb<br>\npreset B</p>\n"},
+		{"list", "- vocals\n- *mix*", "<ul>\n<li>vocals</li>\n<li><em>mix</em></li>\n</ul>\n"},
+		{"code", "```\n<b>*x*</b>\n```", "<pre><code>&lt;b&gt;*x*&lt;/b&gt;\n</code></pre>\n"},
+		{"inline code", "use `**x**` here", "<p>\nuse <code>**x**</code> here</p>\n"},
+		{"escaped", "a < b & \"c\"", "<p>\na &lt; b &amp; &#34;c&#34;</p>\n"},
+		{"link", "[site](https://example.org/a?b=1&c=2)",
+			"<p>\n<a href=\"https://example.org/a?b=1&amp;c=2\">site</a></p>\n"},
+		{"relative link", "[take](takes/take%201.wav)", "<p>\n<a href=\"takes/take%201.wav\">take</a></p>\n"},
+		{"mail", "[me](mailto:me@example.org)", "<p>\n<a href=\"mailto:me@example.org\">me</a></p>\n"},
+		{"javascript", "[x](javascript:location=name)", "<p>\n[x](javascript:location=name)</p>\n"},
+		{"javascript case", "[x](JavaScript:alert(1))", "<p>\n[x](JavaScript:alert(1))</p>\n"},
+		{"data link", "[x](data:text/html,hi)", "<p>\n[x](data:text/html,hi)</p>\n"},
+		{"emphasis in href", "[x](https://example.org/*a*/b) and *y*",
+			"<p>\n<a href=\"https://example.org/*a*/b\">x</a> and <em>y</em></p>\n"},
+		{"emphasis in label", "[**x**](a.md)", "<p>\n<a href=\"a.md\"><strong>x</strong></a></p>\n"},
+		{"image", "![mic](mic*1*.png)", "<p>\n<img alt=\"mic\" src=\"mic*1*.png\"></p>\n"},
+		{"image link", "[![mic](mic.png)](https://example.org)",
+			"<p>\n<a href=\"https://example.org\"><img alt=\"mic\" src=\"mic.png\"></a></p>\n"},
+		{"javascript image", "![x](javascript:alert)", "<p>\n![x](javascript:alert)</p>\n"},
+	} {
+		if got := renderMarkdown(test.notes, nil); got != test.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	got := references("![a](a.png) [b](b.md) [a again](a.png) [c](https://example.org)")
+	want := []string{"a.png", "b.md", "https://example.org"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/crypt"
+	"nsm-notes/export"
+	"nsm-notes/frontmatter"
+)
+
+// runExportCommand implements "nsm-notes export", it doesn't need a NSM server.
+func runExportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", string(export.FormatHTML), "export format, html or zip")
+	out := fs.String("o", "", "output file (default: notes file with the extension of the format)")
+	title := fs.String("title", "", "document title (default: the title of the front matter or the name of the notes file)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s export [-format html|zip] [-o file] [-title title] notes-file\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	notesPath := fs.Arg(0)
+	textByte, err := os.ReadFile(notesPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if crypt.IsEncrypted(textByte) {
+		fmt.Fprintf(os.Stderr, "%s is encrypted, export it from the notes window\n", notesPath)
+		return 1
+	}
+
+	opts := export.Options{Title: *title}
+	if opts.Title == "" {
+		meta, _, _ := frontmatter.Split(string(textByte))
+		opts.Title = meta.Title
+	}
+	if opts.Title == "" {
+		opts.Title = strings.TrimSuffix(filepath.Base(notesPath), filepath.Ext(notesPath))
+	}
+
+	outPath := *out
+	if outPath == "" {
+		outPath = exportFileName(notesPath, export.Format(*format))
+	}
+
+	if err := export.WriteFile(outPath, export.Format(*format), string(textByte), filepath.Dir(notesPath), opts); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func exportFileName(notesPath string, format export.Format) string {
+	return strings.TrimSuffix(notesPath, filepath.Ext(notesPath)) + "." + string(format)
+}
+
+func (a *app) callbackMenuFileExport(format export.Format) {
+	pattern := "*." + string(format)
+	outPath, ok := fltk.ChooseFile("Export notes", pattern, exportFileName(a.fileName, format), false)
+	if !ok {
+		return
+	}
+
+	opts := export.Options{Title: a.meta.Title}
+	if opts.Title == "" {
+		opts.Title = a.NsmGetDisplayName()
+	}
+	if opts.Title == "" {
+		opts.Title = APP_TITLE
+	}
+	if exportSessionMeta && a.NsmIsActive() {
+		opts.Meta = &export.Meta{
+			SessionManager: a.NsmGetSessionManagerName(),
+			DisplayName:    a.NsmGetDisplayName(),
+			ClientId:       a.NsmGetClientId(),
+		}
+	}
+
+	if err := export.WriteFile(outPath, format, a.notesText(), filepath.Dir(a.fileName), opts); err != nil {
+		a.logf("%v", err)
+		fltk.MessageBox(APP_TITLE, fmt.Sprintf("Export failed: %v", err))
+	}
+}
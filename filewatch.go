@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/notes"
+)
+
+// watchFile (re)starts watching a.fileName for changes made by other programs.
+func (a *app) watchFile() {
+	if a.watcher != nil {
+		a.watcher.Close()
+		a.watcher = nil
+	}
+
+	w, err := newFileWatcher(a.fileName)
+	if err != nil {
+		a.logf("%v", err)
+		return
+	}
+	a.watcher = w
+}
+
+// checkFileChanged is polled from the main loop, fltk widgets are only touched from there.
+func (a *app) checkFileChanged() {
+	if m := a.savedMerge; m != nil {
+		a.savedMerge = nil
+		a.askSavedMerge(m)
+	}
+	if a.recoveryFile != "" && !a.locked {
+		a.askRecovery()
+	}
+
+	if a.watcher == nil {
+		return
+	}
+
+	select {
+	case <-a.watcher.Changed():
+		if err := a.reconcileFile(true); err != nil {
+			a.logf("%v", err)
+		}
+	default:
+	}
+}
+
+// savedMerge is a merge a NSM save made without asking, the user can still
+// pick one of the versions afterwards.
+type savedMerge struct {
+	mine, theirs string
+	conflicts    int
+}
+
+// reconcileFile compares the notes file with the text we last read or wrote.
+// A clean buffer is reloaded, a dirty buffer is merged or one side is kept.
+// Without ask, for NSM saves that can't wait for a dialog, a dirty buffer is
+// merged with conflict markers and the user is asked later from the main loop.
+func (a *app) reconcileFile(ask bool) error {
+	if a.locked {
+		return nil // read on unlock
+	}
+	theirs, err := a.readNotes()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // removed, the next save creates it again.
+		}
+		return fmt.Errorf("%v", err)
+	}
+
+	if theirs == a.diskText {
+		return nil
+	}
+
+	if !a.appIsDirty {
+		a.replaceNotes(theirs)
+		a.diskText = theirs
+		return nil
+	}
+
+	mine := a.notesText()
+	merged, conflicts := notes.Merge(a.diskText, mine, theirs)
+
+	if !ask {
+		a.replaceNotes(merged)
+		a.diskText = theirs
+		a.savedMerge = &savedMerge{mine: mine, theirs: theirs, conflicts: conflicts}
+		return nil
+	}
+
+	msg := "The notes file was changed by another program.\nMerge both versions?"
+	if conflicts > 0 {
+		msg = fmt.Sprintf("The notes file was changed by another program.\nMerge both versions? (%d conflicts will be marked)", conflicts)
+	}
+
+	if fltk.ChoiceDialog(msg, "Choose version", "Merge") == 1 {
+		a.replaceNotes(merged)
+	} else if fltk.ChoiceDialog("Keep your version or load the changed file?", "Keep mine", "Keep theirs") == 1 {
+		a.replaceNotes(theirs)
+		a.diskText = theirs
+		a.saveButton.SetValue(false)
+		a.setAppClean()
+		return nil
+	}
+
+	// the buffer stays dirty, the next save writes the result.
+	a.diskText = theirs
+
+	return nil
+}
+
+// askSavedMerge lets the user undo the merge of a NSM save. The merged notes
+// are on disk, the chosen version is saved with the next save.
+func (a *app) askSavedMerge(m *savedMerge) {
+	msg := "The notes file was changed by another program when the session was saved.\nBoth versions were merged."
+	if m.conflicts > 0 {
+		msg = fmt.Sprintf("The notes file was changed by another program when the session was saved.\nBoth versions were merged, %d conflicts are marked.", m.conflicts)
+	}
+	if fltk.ChoiceDialog(msg, "Choose version", "Keep merge") == 1 {
+		return
+	}
+	if fltk.ChoiceDialog("Keep your version or the changed file?", "Keep mine", "Keep theirs") == 1 {
+		a.replaceNotes(m.theirs)
+	} else {
+		a.replaceNotes(m.mine)
+	}
+	a.setAppDirty()
+}
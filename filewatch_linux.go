@@ -0,0 +1,87 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// fileWatcher uses inotify on the directory of the notes file, so files replaced
+// by a rename (as most editors and sync tools do) are noticed as well.
+type fileWatcher struct {
+	f       *os.File
+	name    string
+	changed chan bool
+}
+
+func newFileWatcher(fileName string) (*fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %v", err)
+	}
+
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE)
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(fileName), mask); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("inotify watch %s: %v", fileName, err)
+	}
+
+	w := &fileWatcher{
+		f:       os.NewFile(uintptr(fd), "inotify"), // non-blocking, so Close unblocks Read
+		name:    filepath.Base(fileName),
+		changed: make(chan bool, 1),
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// goroutine
+func (w *fileWatcher) run() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + syscall.SizeofInotifyEvent
+			name := buf[nameStart : nameStart+int(ev.Len)]
+			off = nameStart + int(ev.Len)
+
+			if cString(name) == w.name {
+				w.notify()
+			}
+		}
+	}
+}
+
+func (w *fileWatcher) notify() {
+	select {
+	case w.changed <- true:
+	default: // a change is already pending
+	}
+}
+
+func (w *fileWatcher) Changed() <-chan bool {
+	return w.changed
+}
+
+func (w *fileWatcher) Close() error {
+	return w.f.Close()
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
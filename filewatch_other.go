@@ -0,0 +1,71 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// fileWatcher polls the modification time and size of the notes file.
+type fileWatcher struct {
+	fileName string
+	changed  chan bool
+	done     chan bool
+}
+
+func newFileWatcher(fileName string) (*fileWatcher, error) {
+	w := &fileWatcher{
+		fileName: fileName,
+		changed:  make(chan bool, 1),
+		done:     make(chan bool),
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// goroutine
+func (w *fileWatcher) run() {
+	ticker := time.NewTicker(fileWatchPollInterval * time.Millisecond)
+	defer ticker.Stop()
+
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(w.fileName); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(w.fileName)
+			if err != nil {
+				continue
+			}
+			if !info.ModTime().Equal(modTime) || info.Size() != size {
+				modTime, size = info.ModTime(), info.Size()
+				w.notify()
+			}
+		}
+	}
+}
+
+func (w *fileWatcher) notify() {
+	select {
+	case w.changed <- true:
+	default: // a change is already pending
+	}
+}
+
+func (w *fileWatcher) Changed() <-chan bool {
+	return w.changed
+}
+
+func (w *fileWatcher) Close() error {
+	close(w.done)
+	return nil
+}
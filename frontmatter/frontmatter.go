@@ -0,0 +1,243 @@
+// Package frontmatter reads and writes the optional YAML block at the start of
+// the notes, between two "---" lines. Only the flat subset notes need is
+// understood, other lines are kept as they are.
+package frontmatter
+
+import (
+	"strconv"
+	"strings"
+)
+
+const delimiter = "---"
+
+// Meta is the structured data of the notes, empty fields aren't written.
+type Meta struct {
+	Title  string
+	Artist string
+	Tempo  string // "120", "96-100"
+	Key    string
+	Status string // "draft", "recording", "mixing", "done"
+	Tags   []string
+	Extra  []string // lines of other keys, kept in order
+}
+
+// IsZero is true for notes without front matter.
+func (m Meta) IsZero() bool {
+	return m.Title == "" && m.Artist == "" && m.Tempo == "" && m.Key == "" &&
+		m.Status == "" && len(m.Tags) == 0 && len(m.Extra) == 0
+}
+
+// HasTag compares case-insensitively, tags are typed by hand.
+func (m Meta) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Split separates the front matter block from the body of notes. Without a
+// closed block of keys at the first line, block is empty and body is notes.
+func Split(notes string) (m Meta, block, body string) {
+	first, rest, ok := strings.Cut(notes, "\n")
+	if !ok || strings.TrimRight(first, " \r") != delimiter {
+		return Meta{}, "", notes
+	}
+	pos := len(first) + 1
+	var lines []string
+	for rest != "" {
+		line, next, _ := strings.Cut(rest, "\n")
+		pos += len(line) + 1
+		if l := strings.TrimRight(line, " \r"); l == delimiter || l == "..." {
+			if !isFrontMatter(lines) {
+				break // notes starting with a horizontal rule
+			}
+			if pos > len(notes) {
+				pos = len(notes) // closed without a newline
+			}
+			return parse(lines), notes[:pos], notes[pos:]
+		}
+		lines = append(lines, strings.TrimRight(line, "\r"))
+		rest = next
+	}
+	return Meta{}, "", notes
+}
+
+// isFrontMatter is true when lines are "key: value" lines and the indented or
+// list lines that continue them, like the blocks Format writes. A section of
+// the notes between two horizontal rules isn't.
+func isFrontMatter(lines []string) bool {
+	keys := 0
+	for _, line := range lines {
+		switch {
+		case strings.TrimSpace(line) == "":
+		case strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "- "):
+			if keys == 0 {
+				return false
+			}
+		default:
+			key, value, ok := strings.Cut(line, ":")
+			if !ok || key == "" || strings.ContainsAny(key, " \t#") ||
+				(value != "" && !strings.HasPrefix(value, " ")) {
+				return false
+			}
+			keys++
+		}
+	}
+	return keys > 0
+}
+
+// Join puts the block of m in front of body.
+func Join(m Meta, body string) string {
+	return m.Format() + body
+}
+
+func parse(lines []string) Meta {
+	var m Meta
+	fields := map[string]*string{
+		"title": &m.Title, "artist": &m.Artist, "tempo": &m.Tempo, "bpm": &m.Tempo,
+		"key": &m.Key, "status": &m.Status,
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || key == "" || key != strings.TrimSpace(key) || strings.HasPrefix(key, "#") {
+			m.Extra = append(m.Extra, line)
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		// the indented lines of a key belong to it: a block list or a nested map.
+		j := i + 1
+		for j < len(lines) && (strings.HasPrefix(lines[j], " ") || strings.HasPrefix(lines[j], "-")) {
+			j++
+		}
+		nested := lines[i+1 : j]
+
+		switch field, ok := fields[key]; {
+		case ok && len(nested) == 0:
+			*field = scalar(value)
+		case key == "tags":
+			m.Tags = tagList(value, nested)
+		default: // other keys and multi-line values, like "title: >"
+			m.Extra = append(m.Extra, lines[i:j]...)
+		}
+		i = j - 1
+	}
+	return m
+}
+
+// scalar unquotes a YAML value and drops a trailing comment.
+func scalar(value string) string {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		if end := strings.LastIndex(value, `"`); end > 0 {
+			if s, err := strconv.Unquote(value[:end+1]); err == nil {
+				return s
+			}
+		}
+	case strings.HasPrefix(value, "'"):
+		if end := strings.LastIndex(value, "'"); end > 0 {
+			return strings.ReplaceAll(value[1:end], "''", "'")
+		}
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	value = strings.TrimSpace(value)
+	if value == "~" || value == "null" {
+		return ""
+	}
+	return value
+}
+
+// tagList reads "[a, b]", "a, b" or a block list of "- a" lines.
+func tagList(value string, nested []string) []string {
+	var items []string
+	if strings.HasPrefix(value, "[") {
+		value = strings.TrimPrefix(value, "[")
+		if i := strings.LastIndex(value, "]"); i >= 0 {
+			value = value[:i]
+		}
+		items = strings.Split(value, ",")
+	} else if value != "" {
+		items = strings.Split(scalar(value), ",")
+	}
+	for _, line := range nested {
+		if item, ok := strings.CutPrefix(strings.TrimSpace(line), "-"); ok {
+			items = append(items, item)
+		}
+	}
+
+	var tags []string
+	for _, item := range items {
+		tags = AddTag(tags, scalar(strings.TrimSpace(item)))
+	}
+	return tags
+}
+
+// AddTag appends tag unless it is empty or already in tags.
+func AddTag(tags []string, tag string) []string {
+	tag = strings.TrimSpace(tag)
+	if tag == "" || (Meta{Tags: tags}).HasTag(tag) {
+		return tags
+	}
+	return append(tags, tag)
+}
+
+// ParseTags reads tags as typed in the form, separated by commas.
+func ParseTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		tags = AddTag(tags, t)
+	}
+	return tags
+}
+
+// Format writes m as a front matter block, empty for a zero Meta.
+func (m Meta) Format() string {
+	if m.IsZero() {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(delimiter + "\n")
+	for _, f := range []struct{ key, value string }{
+		{"title", m.Title},
+		{"artist", m.Artist},
+		{"tempo", m.Tempo},
+		{"key", m.Key},
+		{"status", m.Status},
+	} {
+		if f.value != "" {
+			sb.WriteString(f.key + ": " + quote(f.value) + "\n")
+		}
+	}
+	if len(m.Tags) > 0 {
+		quoted := make([]string, len(m.Tags))
+		for i, t := range m.Tags {
+			quoted[i] = quote(t)
+		}
+		sb.WriteString("tags: [" + strings.Join(quoted, ", ") + "]\n")
+	}
+	for _, line := range m.Extra {
+		sb.WriteString(line + "\n")
+	}
+	sb.WriteString(delimiter + "\n")
+	return sb.String()
+}
+
+// quote leaves plain values alone, like "C#" or "120", and quotes values YAML
+// would read differently.
+func quote(value string) string {
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, "\"'\n\t") ||
+		strings.Contains(value, ": ") || strings.Contains(value, " #") ||
+		strings.HasSuffix(value, ":") || strings.ContainsAny(value[:1], "-?:,[]{}#&*!|>%@`") {
+		return strconv.Quote(value)
+	}
+	switch strings.ToLower(value) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,106 @@
+package frontmatter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	notes := "---\n" +
+		"title: \"Night Drive: take 2\"\n" +
+		"artist: 'The O''Neills'\n" +
+		"bpm: 96 # after the edit\n" +
+		"key: F#m\n" +
+		"tags:\n  - vocals\n  - Mix\n  - mix\n" +
+		"engineer:\n  name: Sam\n" +
+		"---\n" +
+		"# Session\nreverb preset B\n"
+
+	m, block, body := Split(notes)
+	want := Meta{
+		Title:  "Night Drive: take 2",
+		Artist: "The O'Neills",
+		Tempo:  "96",
+		Key:    "F#m",
+		Tags:   []string{"vocals", "Mix"},
+		Extra:  []string{"engineer:", "  name: Sam"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("meta = %+v, want %+v", m, want)
+	}
+	if block+body != notes || body != "# Session\nreverb preset B\n" {
+		t.Fatalf("block %q, body %q", block, body)
+	}
+	if !m.HasTag("MIX") || m.HasTag("drums") {
+		t.Fatal("HasTag")
+	}
+}
+
+func TestNoFrontMatter(t *testing.T) {
+	for _, notes := range []string{
+		"",
+		"# Session\n---\ntitle: x\n---\n",
+		"---\ntitle: not closed\n# Session\n",
+		"---\n# Session\nreverb preset B\n---\n",
+		"---\n\nverse one: quiet, chorus loud\n---\n",
+		"---\nsee https://example.org\n---\n",
+		"---\n- vocals\n- drums\n---\n",
+		"---\n\n---\n",
+		"---\n---\n",
+	} {
+		if m, block, body := Split(notes); !m.IsZero() || block != "" || body != notes {
+			t.Errorf("%q: got %+v, %q, %q", notes, m, block, body)
+		}
+	}
+
+	m, block, body := Split("---\r\ntitle: x\r\n...")
+	if m.Title != "x" || block != "---\r\ntitle: x\r\n..." || body != "" {
+		t.Fatalf("got %+v, %q, %q", m, block, body)
+	}
+}
+
+func TestHorizontalRule(t *testing.T) {
+	notes := "---\n\nIntro: keep it quiet\nthe verse comes in at 0:42\n\n---\n# Verse\n"
+	if m, block, body := Split(notes); !m.IsZero() || block != "" || body != notes {
+		t.Fatalf("got %+v, %q, %q", m, block, body)
+	}
+
+	// front matter in front of a horizontal rule is still read.
+	m, block, body := Split("---\ntitle: x\n---\n---\n# Verse\n")
+	if m.Title != "x" || block != "---\ntitle: x\n---\n" || body != "---\n# Verse\n" {
+		t.Fatalf("got %+v, %q, %q", m, block, body)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	m := Meta{
+		Title:  "yes",
+		Artist: "- dash",
+		Tempo:  "120",
+		Key:    "C#",
+		Status: "mixing",
+		Tags:   ParseTags(" vocals, ,drums: live, vocals"),
+		Extra:  []string{"engineer: Sam"},
+	}
+	block := m.Format()
+	want := "---\n" +
+		"title: \"yes\"\n" +
+		"artist: \"- dash\"\n" +
+		"tempo: 120\n" +
+		"key: C#\n" +
+		"status: mixing\n" +
+		"tags: [vocals, \"drums: live\"]\n" +
+		"engineer: Sam\n" +
+		"---\n"
+	if block != want {
+		t.Fatalf("got\n%s\nwant\n%s", block, want)
+	}
+
+	got, _, body := Split(Join(m, "notes\n"))
+	if !reflect.DeepEqual(got, m) || body != "notes\n" {
+		t.Fatalf("round trip: %+v, %q", got, body)
+	}
+	if (Meta{}).Format() != "" {
+		t.Fatal("empty meta written")
+	}
+}
@@ -1,13 +1,15 @@
 module nsm-notes
 
-go 1.20
+go 1.21
 
 require (
 	github.com/pwiecz/go-fltk v0.0.0-20230629192221-bb29d08ae9a2
 	github.com/scgolang/osc v0.11.1
+	golang.org/x/crypto v0.9.0
 )
 
 require (
 	github.com/imdario/go-ulid v0.0.0-20180116185620-aeb52bf96595 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/sys v0.8.0 // indirect
 )
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	"nsm-notes/headless"
+	nsm "nsm-notes/nsmclient"
+)
+
+// displayAvailable reports whether fltk can open a window.
+func displayAvailable() bool {
+	if runtime.GOOS != "linux" && runtime.GOOS != "freebsd" && runtime.GOOS != "openbsd" && runtime.GOOS != "netbsd" {
+		return true
+	}
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+func runHeadless(nsmUrl string) int {
+	h := headless.New(nsm.NsmNewClient())
+
+	h.NsmSetOpenCallback(func(path, displayName, clientId string) (outMsg string, err error) {
+		if err = h.Open(path); err != nil {
+			outMsg = "failed to open file"
+		}
+		return outMsg, err
+	})
+	h.NsmSetSaveCallback(func() (outMsg string, err error) {
+		if err = h.Save(); err != nil {
+			outMsg = "failed to save"
+		}
+		return outMsg, err
+	})
+	h.NsmSetSessionIsLoadedCallback(func() error {
+		return nil
+	})
+	h.NsmSetShutdownCallback(func() error {
+		return h.Shutdown()
+	})
+
+	if err := h.AddOscMethods(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if err := h.NsmInit(nsmUrl); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		return 1
+	}
+	defer func() {
+		if err := h.NsmStop(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}()
+
+	h.NsmHandleSigterm()
+
+	if err := h.NsmSetClientCapabilities(nsm.NSM_DIRTY); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	h.NsmSetPrettyName(APP_TITLE)
+	h.NsmSetAnnounceTimeout(5000)
+
+	if err := h.NsmAnnounce(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		return 1
+	}
+
+	fmt.Printf("[%v] headless, notes on %s\n", os.Args[0], h.NsmClientUrl())
+
+	for {
+		if err := h.NsmCheckWait(headlessWait); err != nil {
+			if errors.Is(err, nsm.NsmGotSigtermErr) {
+				fmt.Printf("[%v] got SIGTERM, bye\n", os.Args[0])
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Package headless is nsm-notes without fltk. The notes are kept in memory,
+// saved through NSM and can be read and written over OSC on the client's own
+// port.
+package headless
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/scgolang/osc"
+
+	"nsm-notes/crypt"
+	"nsm-notes/frontmatter"
+	nsm "nsm-notes/nsmclient"
+	"nsm-notes/recovery"
+)
+
+const (
+	oscAddrAppend = "/nsm-notes/append"
+	oscAddrGet    = "/nsm-notes/get"
+	oscAddrSet    = "/nsm-notes/set"
+	oscAddrClear  = "/nsm-notes/clear"
+	oscAddrReply  = "/reply"
+	oscAddrError  = "/error"
+)
+
+// App holds the notes of a headless client.
+type App struct {
+	mu       sync.Mutex // the OSC methods run in the OSC server goroutine
+	text     string
+	fileName string
+	isDirty  bool
+	label    string // the title last sent as NSM label
+
+	*nsm.NsmClient
+}
+
+func New(c *nsm.NsmClient) *App {
+	return &App{NsmClient: c}
+}
+
+// Open reads the notes of the session, a missing file is created.
+func (h *App) Open(path string) error {
+	textByte, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%v", err)
+	}
+	// there is no one to ask for the passphrase.
+	if crypt.IsEncrypted(textByte) {
+		return fmt.Errorf("%s is encrypted, open the session with a display to unlock it", path)
+	}
+
+	if p, err := recovery.Find(path); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	} else if p != "" {
+		// kept for the next run with a display to offer them.
+		fmt.Fprintf(os.Stderr, "unsaved notes of the last run are in %s, open the session with a display to restore them\n", p)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fileName = path
+	h.text = string(textByte)
+	h.isDirty = false
+	h.sendTitleLabel()
+
+	if errors.Is(err, os.ErrNotExist) {
+		return os.WriteFile(path, nil, 0644)
+	}
+	return nil
+}
+
+// Save writes the notes when they changed. The recovery file is left, its notes
+// were never loaded here.
+func (h *App) Save() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.isDirty {
+		return nil
+	}
+	var mode os.FileMode = 0644
+	if info, err := os.Stat(h.fileName); err == nil {
+		mode = info.Mode()
+	}
+	if err := os.WriteFile(h.fileName, []byte(h.text), mode); err != nil {
+		return err
+	}
+	h.isDirty = false
+	return nil
+}
+
+// Shutdown keeps unsaved notes in the recovery file.
+func (h *App) Shutdown() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.isDirty || h.fileName == "" {
+		return nil
+	}
+	return recovery.Write(h.fileName, []byte(h.text))
+}
+
+// edit changes the notes and tells NSM they are dirty.
+func (h *App) edit(change func(text string) string) {
+	h.mu.Lock()
+	h.text = change(h.text)
+	wasDirty := h.isDirty
+	h.isDirty = true
+	h.sendTitleLabel()
+	h.mu.Unlock()
+
+	if !wasDirty {
+		h.NsmSendIsDirty()
+	}
+}
+
+// sendTitleLabel shows the title of the front matter in the session manager,
+// h.mu is held.
+func (h *App) sendTitleLabel() {
+	meta, _, _ := frontmatter.Split(h.text)
+	if meta.Title == h.label {
+		return
+	}
+	h.label = meta.Title
+	// servers without labels are fine.
+	if err := h.NsmSendLabel(h.label); err != nil && !errors.Is(err, nsm.NsmUnsupportedErr) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+}
+
+// AddOscMethods serves the /nsm-notes/ addresses, before NsmInit.
+func (h *App) AddOscMethods() error {
+	methods := map[string]func(msg osc.Message) error{
+		oscAddrAppend: func(msg osc.Message) error {
+			s, err := oscStringArg(msg)
+			if err != nil {
+				return err
+			}
+			h.edit(func(text string) string {
+				if text != "" && text[len(text)-1] != '\n' {
+					text += "\n"
+				}
+				return text + s
+			})
+			return nil
+		},
+		oscAddrSet: func(msg osc.Message) error {
+			s, err := oscStringArg(msg)
+			if err != nil {
+				return err
+			}
+			h.edit(func(string) string { return s })
+			return nil
+		},
+		oscAddrClear: func(msg osc.Message) error {
+			h.edit(func(string) string { return "" })
+			return nil
+		},
+		oscAddrGet: func(msg osc.Message) error {
+			h.mu.Lock()
+			text := h.text
+			h.mu.Unlock()
+			return h.NsmSendTo(msg.Sender, osc.Message{Address: oscAddrReply,
+				Arguments: osc.Arguments{nsm.NsmOscString(oscAddrGet), nsm.NsmOscString(text)}})
+		},
+	}
+
+	for addr, method := range methods {
+		addr, method := addr, method
+		err := h.NsmAddOscMethod(addr, func(msg osc.Message) error {
+			// errors go back to the sender, returning them would stop the OSC server.
+			if err := method(msg); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", addr, err)
+				h.NsmSendTo(msg.Sender, osc.Message{Address: oscAddrError,
+					Arguments: osc.Arguments{nsm.NsmOscString(addr), nsm.NsmOscString(err.Error())}})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func oscStringArg(msg osc.Message) (string, error) {
+	if expected, got := 1, len(msg.Arguments); expected != got {
+		return "", fmt.Errorf("expected %d arguments, got %d", expected, got)
+	}
+	s, err := msg.Arguments[0].ReadString()
+	if err != nil {
+		return "", fmt.Errorf("%v", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,185 @@
+package headless
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/scgolang/osc"
+
+	nsm "nsm-notes/nsmclient"
+	"nsm-notes/recovery"
+)
+
+// newFakeConnApp announces a headless client to a fake server.
+func newFakeConnApp(t *testing.T) (*App, *nsm.FakeConn) {
+	t.Helper()
+	conn := nsm.NewFakeConn()
+	h := New(nsm.NsmNewClient())
+	if err := h.AddOscMethods(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.NsmInitWithConn(conn, conn.RemoteAddr()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.NsmStop() })
+	if err := h.NsmSetClientCapabilities(nsm.NSM_DIRTY); err != nil {
+		t.Fatal(err)
+	}
+	conn.AcceptAnnounce("fake", "")
+	h.NsmSetAnnounceTimeout(2000)
+	if err := h.NsmAnnounce(); err != nil {
+		t.Fatal(err)
+	}
+	return h, conn
+}
+
+// tool is the program that talks to the notes, not the session manager.
+var tool = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+func send(t *testing.T, conn *nsm.FakeConn, addr string, args ...string) {
+	t.Helper()
+	msg := osc.Message{Address: addr, Sender: tool}
+	for _, a := range args {
+		msg.Arguments = append(msg.Arguments, osc.String(a))
+	}
+	if err := conn.Deliver(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectMsg waits for a message to addr.
+func expectMsg(t *testing.T, conn *nsm.FakeConn, addr string) osc.Message {
+	t.Helper()
+	for {
+		select {
+		case msg := <-conn.Sent():
+			if msg.Address == addr {
+				return msg
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %s", addr)
+		}
+	}
+}
+
+// expect waits for a message to addr and returns its string arguments.
+func expect(t *testing.T, conn *nsm.FakeConn, addr string) []string {
+	t.Helper()
+	var args []string
+	for _, a := range expectMsg(t, conn, addr).Arguments {
+		s, err := a.ReadString()
+		if err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+		args = append(args, s)
+	}
+	return args
+}
+
+func TestOscMethods(t *testing.T) {
+	h, conn := newFakeConnApp(t)
+	path := filepath.Join(t.TempDir(), "notes.md")
+	if err := os.WriteFile(path, []byte("intro"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Open(path); err != nil {
+		t.Fatal(err)
+	}
+
+	send(t, conn, oscAddrGet)
+	if got := expect(t, conn, oscAddrReply); !reflect.DeepEqual(got, []string{oscAddrGet, "intro"}) {
+		t.Fatalf("get: %q", got)
+	}
+
+	send(t, conn, oscAddrAppend, "verse\n")
+	expect(t, conn, nsm.NsmAddrClientIsDirty)
+	send(t, conn, oscAddrGet)
+	if got := expect(t, conn, oscAddrReply); got[1] != "intro\nverse\n" {
+		t.Fatalf("append: %q", got[1])
+	}
+
+	// the front matter title is the label in the session manager.
+	send(t, conn, oscAddrSet, "---\ntitle: Night Drive\n---\nchorus\n")
+	if got := expect(t, conn, nsm.NsmAddrClientLabel); !reflect.DeepEqual(got, []string{"Night Drive"}) {
+		t.Fatalf("label: %q", got)
+	}
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "---\ntitle: Night Drive\n---\nchorus\n" {
+		t.Fatalf("saved %q", data)
+	}
+	// a restrictive mode is kept.
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	send(t, conn, oscAddrAppend, "bridge\n")
+	expect(t, conn, nsm.NsmAddrClientIsDirty)
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("mode after save: %v, %v", info.Mode(), err)
+	}
+
+	send(t, conn, oscAddrClear)
+	send(t, conn, oscAddrGet)
+	// the empty notes are still a null padded string on the wire.
+	reply := expectMsg(t, conn, oscAddrReply)
+	if got, want := reply.Bytes(), []byte("/reply\x00\x00,ss\x00/nsm-notes/get\x00\x00\x00\x00\x00\x00"); !bytes.Equal(got, want) {
+		t.Fatalf("clear: %q, want %q", got, want)
+	}
+
+	// errors are answered, the server keeps serving.
+	send(t, conn, oscAddrSet)
+	if got := expect(t, conn, oscAddrError); !reflect.DeepEqual(got, []string{oscAddrSet, "expected 1 arguments, got 0"}) {
+		t.Fatalf("error: %q", got)
+	}
+	send(t, conn, oscAddrAppend, "a", "b")
+	if got := expect(t, conn, oscAddrError); got[0] != oscAddrAppend {
+		t.Fatalf("error: %q", got)
+	}
+	send(t, conn, oscAddrGet)
+	if got := expect(t, conn, oscAddrReply); got[1] != "" {
+		t.Fatalf("after errors: %q", got[1])
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	h, conn := newFakeConnApp(t)
+	path := filepath.Join(t.TempDir(), "notes.md")
+	if err := h.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || len(data) != 0 {
+		t.Fatalf("new notes file: %q, %v", data, err)
+	}
+
+	send(t, conn, oscAddrSet, "unsaved")
+	expect(t, conn, nsm.NsmAddrClientIsDirty)
+	if err := h.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := recovery.Read(path); err != nil || string(data) != "unsaved" {
+		t.Fatalf("recovery file: %q, %v", data, err)
+	}
+
+	// the next run doesn't load them, a save keeps them for the GUI to offer.
+	h, conn = newFakeConnApp(t)
+	if err := h.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	send(t, conn, oscAddrSet, "saved")
+	expect(t, conn, nsm.NsmAddrClientIsDirty)
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := recovery.Read(path); err != nil || string(data) != "unsaved" {
+		t.Fatalf("recovery file after save: %q, %v", data, err)
+	}
+}
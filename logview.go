@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+// logView keeps the messages of this session, they are also written to stderr.
+type logView struct {
+	text    strings.Builder
+	win     *fltk.Window
+	buffer  *fltk.TextBuffer
+	display *fltk.TextDisplay
+}
+
+func (a *app) logf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintln(os.Stderr, msg)
+
+	line := time.Now().Format("15:04:05 ") + msg + "\n"
+	a.log.text.WriteString(line)
+	if a.log.buffer != nil {
+		a.log.buffer.Append(line)
+	}
+}
+
+func (a *app) buildLogView() {
+	l := &a.log
+	l.win = fltk.NewWindow(logWidth, logHeight)
+	l.win.SetLabel(APP_TITLE + " log")
+	l.win.SetColor(windowColor)
+
+	l.buffer = fltk.NewTextBuffer()
+	l.buffer.SetText(l.text.String())
+	l.display = fltk.NewTextDisplay(0, 0, logWidth, logHeight)
+	l.display.SetBuffer(l.buffer)
+	l.display.SetWrapMode(fltk.WRAP_AT_BOUNDS)
+
+	l.win.End()
+	l.win.Resizable(l.display)
+}
+
+func (a *app) showLog() {
+	a.log.win.Show()
+}
@@ -7,17 +7,50 @@ import (
 
 	"github.com/pwiecz/go-fltk"
 
+	"nsm-notes/crypt"
+	"nsm-notes/frontmatter"
 	nsm "nsm-notes/nsmclient"
+	"nsm-notes/ray"
+	"nsm-notes/recovery"
+	"nsm-notes/transport"
+	"nsm-notes/viewstate"
 )
 
 type app struct {
-	Win        *fltk.Window
-	TextBuffer *fltk.TextBuffer
-	TextEditor *fltk.TextEditor
-	saveButton *fltk.LightButton
-	box        *fltk.Box
-	fileName   string
-	appIsDirty bool
+	Win          *fltk.Window
+	TextBuffer   *fltk.TextBuffer
+	TextEditor   *fltk.TextEditor
+	menuBar      *fltk.MenuBar
+	saveButton   *fltk.LightButton
+	status       statusBar
+	attachments  *attachmentsPanel
+	palette      *commandPalette
+	commands     commandRegistry
+	log          logView
+	dropPending  bool // files were dropped on the editor, the paths follow as paste event
+	fileName     string
+	diskText     string // text as last read from or written to fileName
+	watcher      *fileWatcher
+	savedMerge   *savedMerge // made by a NSM save, the user is asked about it
+	recoveryFile string      // unsaved notes of the last run, the user is asked about them
+	appIsDirty   bool
+	view         viewstate.State
+	transport    *transport.Listener
+	ray          *ray.Ray
+	clientNotes  *clientNotesSidebar
+	spell        *spellChecker
+	editorRow    *fltk.Flex // the editor, the unlock button and the client notes
+	lockButton   *fltk.Button
+	passphrase   *passphraseDialog
+	undo         *undoState
+	metaForm     *metaForm
+	key          *crypt.Key // the next save encrypts with it, nil for plain text
+	diskKey      *crypt.Key // the notes file was encrypted with it
+	locked       bool       // the notes are encrypted and the passphrase wasn't entered yet
+
+	meta       frontmatter.Meta // the front matter, hidden from the editor
+	frontBlock string           // the front matter as written to fileName
+	label      string           // the title last sent as NSM label
 
 	*nsm.NsmClient
 }
@@ -35,6 +68,7 @@ func (a *app) buildGUI() {
 
 	if resizableWin {
 		a.Win.Resizable(a.Win)
+		a.Win.SetSizeRange(minWidgetWidth, minWidgetHeight, 0, 0, 0, 0, false)
 	}
 
 	col := fltk.NewFlex(widgetPaddingWidth/2, widgetPaddingWidth/2, widgetWidth-widgetPaddingWidth, widgetHeight-widgetPaddingWidth)
@@ -42,6 +76,12 @@ func (a *app) buildGUI() {
 	col.SetType(fltk.COLUMN)
 	col.SetSpacing(widgetPaddingWidth)
 
+	a.registerCommands()
+	a.menuBar = fltk.NewMenuBar(0, 0, widgetWidth, menuBarHeight)
+	a.commands.addToMenu(a.menuBar)
+
+	col.Fixed(a.menuBar, menuBarHeight)
+
 	a.saveButton = fltk.NewLightButton(buttonXoffset, buttonYoffset, widgetWidth, buttonHeight, buttonName)
 	a.saveButton.Visible()
 	a.saveButton.SetValue(false)
@@ -49,12 +89,16 @@ func (a *app) buildGUI() {
 	a.saveButton.SetCallback(func() {
 		a.callbackMenuFileSave()
 	})
-	a.saveButton.SetShortcut(fltk.CTRL + 's')
 	a.saveButton.SetColor(buttonColor)
 
 	col.Fixed(a.saveButton, buttonHeight)
 
+	a.editorRow = fltk.NewFlex(0, 0, widgetWidth, widgetHeight)
+	a.editorRow.SetType(fltk.ROW)
+	a.editorRow.SetSpacing(widgetPaddingWidth)
+
 	a.TextBuffer = fltk.NewTextBuffer()
+	a.buildUndo()
 	a.TextEditor = fltk.NewTextEditor(editorXoffset, editorYoffset, a.Win.W(), a.Win.H()-buttonHeight)
 
 	a.TextEditor.SetBuffer(a.TextBuffer)
@@ -65,18 +109,41 @@ func (a *app) buildGUI() {
 	a.TextEditor.SetCallback(func() {
 		a.setAppDirty()
 	})
+	a.TextEditor.SetEventHandler(func(e fltk.Event) bool {
+		if e == fltk.KEYDOWN && a.handleUndoKey() {
+			return true
+		}
+		if e == fltk.PUSH && fltk.EventButton() == fltk.RightMouse {
+			return a.handleSpellClick()
+		}
+		return a.handleEditorDrop(e)
+	})
 	if resizableWin {
 		a.TextEditor.Parent().Resizable(a.TextEditor)
 	}
-	a.box = fltk.NewBox(fltk.NO_BOX, widgetPaddingWidth, widgetHeight-5, widgetWidth-widgetPaddingWidth, 8)
-	a.box.SetLabelSize(10)
-	a.box.SetLabel("Esc to hide")
-	//a.box.SetAlign(fltk.ALIGN_RIGHT)
-	col.Fixed(a.box, 8)
+	a.lockButton = fltk.NewButton(0, 0, widgetWidth, widgetHeight, "The notes are encrypted.\nClick to unlock")
+	a.lockButton.SetCallback(a.askUnlock)
+	a.lockButton.Hide()
+	a.buildClientNotesSidebar()
+	a.editorRow.End()
+
+	a.view = viewstate.State{Font: defaultFont, FontSize: viewstate.DefaultFontSize}
+	a.applyViewState()
+	a.buildStatusBar()
+	col.Fixed(a.status.box, statusBarHeight)
 
 	col.End()
 
+	a.buildSpellCheck()
+
 	a.Win.End()
+
+	a.buildAttachmentsPanel()
+	a.buildCommandPalette()
+	a.buildLogView()
+	a.buildPassphraseDialog()
+	a.buildMetaForm()
+
 	a.setAppClean()
 
 }
@@ -86,6 +153,7 @@ func (a *app) setAppClean() {
 		a.NsmSendIsClean()
 	}
 	a.appIsDirty = false
+	a.updateStatus()
 }
 
 func (a *app) setAppDirty() {
@@ -93,6 +161,7 @@ func (a *app) setAppDirty() {
 	if a.appIsDirty == false {
 		a.appIsDirty = true
 		a.NsmSendIsDirty()
+		a.updateStatus()
 	}
 }
 
@@ -113,15 +182,34 @@ func (a *app) setNsmCallbacksRequired() error {
 
 		if err = a.openFile(); err != nil {
 			outMsg = "failed to open file"
+		} else {
+			a.clearStatusError()
+		}
+		if err := a.loadViewState(); err != nil {
+			a.logf("%v", err)
 		}
+		a.findRecovery()
 		a.Win.SetLabel(displayName)
+		a.updateStatus()
 		return outMsg, err
 	})
 
 	// set save callback
 	a.NsmSetSaveCallback(func() (outMsg string, err error) {
-		if err = a.fileSave(); err != nil {
+		if err = a.fileSave(false); err != nil {
 			outMsg = "failed to save"
+		} else {
+			// nsmclient tells the server we are clean after a successful save.
+			a.appIsDirty = false
+			a.clearStatusError()
+		}
+		if err := a.saveViewState(); err != nil {
+			a.logf("%v", err)
+		}
+		if err == nil {
+			if err := a.saveUndoHistory(); err != nil {
+				a.logf("%v", err)
+			}
 		}
 		return outMsg, err
 	})
@@ -157,7 +245,7 @@ func (a *app) setNsmGuiCallbacks() error {
 }
 
 func (a *app) setNsmPreAnnounceSettings() error {
-	if err := a.NsmSetClientCapabilities(nsm.NSM_OPTIONAL_GUI, nsm.NSM_DIRTY); err != nil {
+	if err := a.NsmSetClientCapabilities(nsm.NSM_OPTIONAL_GUI, nsm.NSM_DIRTY, nsm.NSM_MONITOR); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 	}
 	a.NsmSetPrettyName(APP_TITLE) // optional
@@ -167,18 +255,42 @@ func (a *app) setNsmPreAnnounceSettings() error {
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExportCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		os.Exit(runSearchCommand(os.Args[2:]))
+	}
+
 	nsmUrl, found := nsm.NsmUrlIsSet()
 	if !found {
 		fmt.Fprintf(os.Stderr, "Fatal: We can't connect to NSM, %s not set.\n", nsm.NsmEnvUrl)
 		os.Exit(1)
 	}
 
+	if !displayAvailable() || hasArg("--headless") {
+		os.Exit(runHeadless(nsmUrl))
+	}
+
 	a := app{}
 
 	a.NsmClient = nsm.NsmNewClient()
 
 	a.setNsmCallbacksRequired()
 	a.setNsmCallbacksOptional()
+	a.NsmSetErrorCallback(func(err error) {
+		a.setStatusError(err)
+	})
+	a.NsmSetShutdownCallback(func() error {
+		return a.shutdown()
+	})
+
+	// the monitor methods are added before NsmInit starts the OSC server.
+	var err error
+	if a.ray, err = ray.New(a.NsmClient); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 
 	if err := a.NsmInit(nsmUrl); err != nil {
 		fmt.Fprintf(os.Stderr, "%v", err)
@@ -200,6 +312,8 @@ func main() {
 	}
 
 	a.buildGUI()
+	a.startTransportListener()
+	a.startSpellCheck()
 
 	if hideWinAtLaunch {
 		a.setGuiHidden()
@@ -208,15 +322,24 @@ func main() {
 	}
 	for {
 		if err := a.NsmCheckWait(1); err != nil {
+			// os.Exit skips the deferred NsmStop.
 			if errors.Is(err, nsm.NsmGotSigtermErr) {
 				fmt.Printf("[%v] got SIGTERM, bye\n", os.Args[0])
+				if err := a.NsmStop(); err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+				}
 				os.Exit(0)
 			} else {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
+				a.NsmStop()
 				os.Exit(1)
 			}
 		}
 
+		a.checkFileChanged()
+		a.checkClientNotes()
+		a.checkSpelling()
+
 		fltk.Wait(0.17)
 	}
 }
@@ -238,32 +361,95 @@ func (a *app) openFile() error {
 	if err != nil {
 		return fmt.Errorf("%v", err)
 	}
-	a.TextBuffer.SetText(string(textByte))
+	a.watchFile()
+
+	if crypt.IsEncrypted(textByte) {
+		a.lockEncryptedNotes()
+		return nil
+	}
+	a.key, a.diskKey = nil, nil
+	a.setLocked(false)
+	a.setNotesText(string(textByte))
+	a.diskText = string(textByte)
 
 	return nil
 }
+
+// layoutEditorRow lays out the editor row again after one of its widgets was
+// shown or hidden, the flex only does that on resize.
+func (a *app) layoutEditorRow() {
+	r := a.editorRow
+	r.Resize(r.X(), r.Y(), r.W(), r.H())
+	a.Win.Redraw()
+}
+
 func (a *app) callbackMenuFileSave() { //error
 	// send to chan? same as nsm? or Mutex?
 
 	a.saveButton.SetValue(false)
-	if err := a.fileSave(); err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
+	err := a.fileSave(true)
+	if err := a.saveViewState(); err != nil {
+		a.logf("%v", err)
+	}
+	if err != nil {
+		// the notes stay dirty, NSM isn't told they were saved.
+		a.saveButton.SetValue(a.appIsDirty)
+		a.setStatusError(err)
+		return
+	}
+	if err := a.saveUndoHistory(); err != nil {
+		a.logf("%v", err)
 	}
 
+	a.clearStatusError()
 	a.setAppClean()
 	//a.saveButton.SetValue(false)
 }
 
-func (a *app) fileSave() error {
-	if a.appIsDirty {
-		info, _ := os.Stat(a.fileName)
-		if err := os.WriteFile(a.fileName, []byte(a.TextBuffer.Text()), info.Mode()); err != nil {
+// fileSave writes the notes, ask is false for NSM saves, which can't wait for
+// the user.
+func (a *app) fileSave(ask bool) error {
+	// the empty buffer of locked notes never replaces them.
+	if a.appIsDirty && !a.locked {
+		// don't overwrite changes made by other programs.
+		if err := a.reconcileFile(ask); err != nil {
 			return err
 		}
 
+		var mode os.FileMode = 0644
+		if info, err := os.Stat(a.fileName); err == nil {
+			mode = info.Mode()
+		}
+		text := a.notesText()
+		data, err := a.encodeNotes(text)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(a.fileName, data, mode); err != nil {
+			return err
+		}
+		a.diskText = text
+		a.diskKey = a.key
+		a.setStatusSaved()
+		// the saved notes replace the unsaved ones, unless they weren't offered yet.
+		if a.recoveryFile == "" {
+			if err := recovery.Clear(a.fileName); err != nil {
+				a.logf("%v", err)
+			}
+		}
+
 		a.saveButton.SetValue(false)
 
 	}
 
 	return nil
 }
+
+func hasArg(arg string) bool {
+	for _, a := range os.Args[1:] {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
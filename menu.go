@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/export"
+	"nsm-notes/viewstate"
+)
+
+// registerCommands registers every action of the notes window. The menu bar and
+// the command palette are both built from the registry.
+func (a *app) registerCommands() {
+	r := &a.commands
+
+	r.register("file.save", "&File/&Save", fltk.CTRL+'s', a.callbackMenuFileSave)
+	r.register("file.export_html", "&File/Export as &HTML...", 0, func() {
+		a.callbackMenuFileExport(export.FormatHTML)
+	})
+	r.register("file.export_bundle", "&File/Export as &Markdown bundle...", 0, func() {
+		a.callbackMenuFileExport(export.FormatBundle)
+	})
+	r.register("file.properties", "&File/&Properties...", 0, a.showMetaForm)
+	r.register("file.attach", "&File/&Attach file...", 0, a.callbackMenuFileAttach)
+	r.register("file.encrypt", "&File/&Encrypt with passphrase...", 0, a.encryptNotes)
+	r.register("file.decrypt", "&File/&Remove encryption", 0, a.decryptNotes)
+	r.register("file.unlock", "&File/&Unlock...", 0, a.askUnlock)
+
+	r.register("edit.undo", "&Edit/&Undo", fltk.CTRL+'z', a.undoEdit)
+	r.register("edit.redo", "&Edit/&Redo", fltk.CTRL+fltk.SHIFT+'z', a.redoEdit)
+	r.register("edit.cut", "&Edit/Cu&t", fltk.CTRL+'x', func() { a.TextEditor.Cut() })
+	r.register("edit.copy", "&Edit/&Copy", fltk.CTRL+'c', func() { a.TextEditor.Copy() })
+	r.register("edit.paste", "&Edit/&Paste", fltk.CTRL+'v', func() { a.TextEditor.Paste() })
+	r.register("edit.select_all", "&Edit/Select &all", fltk.CTRL+'a', func() { a.TextEditor.SelectAll() })
+
+	r.register("edit.insert_timestamp", "&Edit/Insert &timestamp", fltk.CTRL+'t', a.insertTimestamp)
+	r.register("edit.spell_check", "&Edit/Check &spelling", 0, a.toggleSpellCheck)
+
+	// '=' is the key of '+' on most layouts, Ctrl+'+' would need Shift.
+	r.register("view.zoom_in", "&View/Zoom &in", fltk.CTRL+'=', func() { a.zoomEditor(1) })
+	r.register("view.zoom_out", "&View/Zoom &out", fltk.CTRL+'-', func() { a.zoomEditor(-1) })
+	r.register("view.zoom_reset", "&View/&Reset zoom", fltk.CTRL+'0', func() { a.setEditorFontSize(viewstate.DefaultFontSize) })
+	for _, f := range []struct{ name, label string }{
+		{"helvetica", "Helvetica"}, {"courier", "Courier"}, {"times", "Times"}, {"screen", "Screen"},
+	} {
+		name := f.name
+		r.register("view.font_"+name, "&View/&Font/"+f.label, 0, func() { a.setEditorFont(name) })
+	}
+	for _, size := range []int{10, 12, 14, 16, 18, 20, 24} {
+		size := size
+		r.register(fmt.Sprintf("view.font_size_%d", size), fmt.Sprintf("&View/Font &size/%d", size), 0, func() { a.setEditorFontSize(size) })
+	}
+	r.register("view.wrap", "&View/&Wrap at window width", 0, a.toggleWrapAtWindow)
+	r.register("view.attachments", "&View/&Attachments", 0, a.showAttachmentsPanel)
+	r.register("view.palette", "&View/Command &palette...", fltk.CTRL+fltk.SHIFT+'p', a.showCommandPalette)
+
+	a.registerNsmCommands()
+
+	r.register("help.about", "&Help/&About", 0, func() {
+		fltk.MessageBox(APP_TITLE, APP_TITLE+": session notes for Non Session Manager")
+	})
+
+	if err := r.loadShortcuts(configFile(shortcutsFileName)); err != nil {
+		a.logf("%v", err)
+	}
+}
+
+func (a *app) registerNsmCommands() {
+	r := &a.commands
+
+	r.register("session.save", "&Session/&Save session", 0, func() {
+		if err := a.NsmSendServerSave(); err != nil {
+			a.logf("%v", err)
+		}
+	})
+	r.register("session.hide", "&Session/&Hide GUI", fltk.ESCAPE, a.setGuiHidden)
+	r.register("session.log", "&Session/Show &log", 0, a.showLog)
+	r.register("session.clients", "&Session/Client &notes", 0, a.toggleClientNotes)
+}
@@ -0,0 +1,146 @@
+// Package notes holds the line based operations on the notes text: merging
+// versions changed by two programs and finding the sections about clients.
+package notes
+
+import (
+	"strings"
+)
+
+const (
+	mergeMarkerMine   = "<<<<<<< mine\n"
+	mergeMarkerSep    = "=======\n"
+	mergeMarkerTheirs = ">>>>>>> theirs\n"
+)
+
+// Merge does a line based three-way merge of mine and theirs, both derived from base.
+// It returns the merged text and the number of conflicts, which are marked in the text.
+func Merge(base, mine, theirs string) (string, int) {
+	o := splitLines(base)
+	a := splitLines(mine)
+	b := splitLines(theirs)
+
+	matchA := lcsMatch(o, a)
+	matchB := lcsMatch(o, b)
+
+	var (
+		sb        strings.Builder
+		conflicts int
+		io        int
+		ia        int
+		ib        int
+	)
+
+	for io < len(o) || ia < len(a) || ib < len(b) {
+		// find the next base line that is kept in both mine and theirs.
+		next := io
+		for next < len(o) && (matchA[next] < 0 || matchB[next] < 0) {
+			next++
+		}
+
+		if next == io && io < len(o) && matchA[io] == ia && matchB[io] == ib {
+			writeLines(&sb, o[io:io+1])
+			io, ia, ib = io+1, ia+1, ib+1
+			continue
+		}
+
+		na, nb := len(a), len(b)
+		if next < len(o) {
+			na, nb = matchA[next], matchB[next]
+		}
+
+		chunkO := o[io:next]
+		chunkA := a[ia:na]
+		chunkB := b[ib:nb]
+
+		switch {
+		case equalLines(chunkA, chunkO):
+			writeLines(&sb, chunkB)
+		case equalLines(chunkB, chunkO), equalLines(chunkA, chunkB):
+			writeLines(&sb, chunkA)
+		default:
+			conflicts++
+			sb.WriteString(mergeMarkerMine)
+			writeLines(&sb, chunkA)
+			sb.WriteString(mergeMarkerSep)
+			writeLines(&sb, chunkB)
+			sb.WriteString(mergeMarkerTheirs)
+		}
+
+		io, ia, ib = next, na, nb
+	}
+
+	merged := sb.String()
+	// the final newline is merged like a line.
+	newline := strings.HasSuffix(mine, "\n")
+	if newline == strings.HasSuffix(base, "\n") {
+		newline = strings.HasSuffix(theirs, "\n")
+	}
+	if !newline {
+		merged = strings.TrimSuffix(merged, "\n")
+	}
+	return merged, conflicts
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lcsMatch returns for every line in o the index of the matching line in a, or -1.
+func lcsMatch(o, a []string) []int {
+	// table[i][j] is the length of the lcs of o[i:] and a[j:].
+	table := make([][]int, len(o)+1)
+	for i := range table {
+		table[i] = make([]int, len(a)+1)
+	}
+	for i := len(o) - 1; i >= 0; i-- {
+		for j := len(a) - 1; j >= 0; j-- {
+			if o[i] == a[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+
+	match := make([]int, len(o))
+	for i := range match {
+		match[i] = -1
+	}
+	for i, j := 0, 0; i < len(o) && j < len(a); {
+		switch {
+		case o[i] == a[j]:
+			match[i] = j
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return match
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(sb *strings.Builder, lines []string) {
+	for _, l := range lines {
+		sb.WriteString(l)
+		sb.WriteString("\n")
+	}
+}
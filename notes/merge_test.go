@@ -0,0 +1,84 @@
+package notes
+
+import "testing"
+
+func TestMerge(t *testing.T) {
+	for _, test := range []struct {
+		name               string
+		base, mine, theirs string
+		want               string
+		conflicts          int
+	}{
+		{
+			name:   "clean",
+			base:   "intro\nverse\nchorus\noutro\n",
+			mine:   "intro\nverse: double the vocals\nchorus\noutro\n",
+			theirs: "intro\nverse\nchorus\noutro: fade\n",
+			want:   "intro\nverse: double the vocals\nchorus\noutro: fade\n",
+		},
+		{
+			name:   "same change",
+			base:   "a\nb\n",
+			mine:   "a\nB\n",
+			theirs: "a\nB\n",
+			want:   "a\nB\n",
+		},
+		{
+			name:      "overlapping",
+			base:      "a\nb\nc\n",
+			mine:      "a\nmine\nc\n",
+			theirs:    "a\ntheirs 1\ntheirs 2\nc\n",
+			want:      "a\n<<<<<<< mine\nmine\n=======\ntheirs 1\ntheirs 2\n>>>>>>> theirs\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:      "deleted and changed",
+			base:      "a\nb\nc\nd\ne\n",
+			mine:      "a\nc\nd\nE\n",
+			theirs:    "a\nB\nc\nd\ne\n",
+			want:      "a\n<<<<<<< mine\n=======\nB\n>>>>>>> theirs\nc\nd\nE\n",
+			conflicts: 1,
+		},
+		{
+			name:   "insert at start and end",
+			base:   "a\nb\n",
+			mine:   "first\na\nb\n",
+			theirs: "a\nb\nlast\n",
+			want:   "first\na\nb\nlast\n",
+		},
+		{
+			name:   "empty base, one side",
+			base:   "",
+			mine:   "",
+			theirs: "new notes\n",
+			want:   "new notes\n",
+		},
+		{
+			name:      "empty base, both sides",
+			base:      "",
+			mine:      "mine\n",
+			theirs:    "theirs\n",
+			want:      "<<<<<<< mine\nmine\n=======\ntheirs\n>>>>>>> theirs\n",
+			conflicts: 1,
+		},
+		{
+			name:   "no trailing newline",
+			base:   "a\nb",
+			mine:   "A\nb",
+			theirs: "a\nb\nc",
+			want:   "A\nb\nc",
+		},
+		{
+			name:   "trailing newline added",
+			base:   "a\nb",
+			mine:   "a\nb\n",
+			theirs: "A\nb",
+			want:   "A\nb\n",
+		},
+	} {
+		got, conflicts := Merge(test.base, test.mine, test.theirs)
+		if got != test.want || conflicts != test.conflicts {
+			t.Errorf("%s: got %q with %d conflicts, want %q with %d", test.name, got, conflicts, test.want, test.conflicts)
+		}
+	}
+}
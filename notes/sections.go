@@ -0,0 +1,94 @@
+package notes
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// clientHeading is the heading of a client section, "## name {#client-id}".
+	// The id keys the section, the name is updated when the client is renamed.
+	clientHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*\{#client-([^}\s]+)\}\s*$`)
+	anyHeading    = regexp.MustCompile(`^(#{1,6})\s`)
+	codeFence     = regexp.MustCompile("^\\s*(```|~~~)")
+)
+
+// Section is the notes about one session client, positions are byte offsets
+// in the notes, as the TextBuffer counts them.
+type Section struct {
+	ID, Name string
+	Level    int // of the heading, 1 for "#"
+	Start    int // of the heading
+	Body     int // after the heading line
+	End      int // the next heading of the same or a higher level, or the end of the notes
+}
+
+// ClientSections finds the client sections of notes, headings in fenced code don't count.
+func ClientSections(notes string) []Section {
+	var (
+		sections []Section
+		open     = -1 // index of the section that isn't ended yet
+		level    int
+		fence    string // of the open code block, it ends with the same one
+		pos      int
+	)
+	for _, line := range strings.SplitAfter(notes, "\n") {
+		text := strings.TrimRight(line, "\r\n")
+		m := codeFence.FindStringSubmatch(text)
+		switch {
+		case m != nil && (fence == "" || fence == m[1]):
+			if fence == "" {
+				fence = m[1]
+			} else {
+				fence = ""
+			}
+		case fence != "":
+		case anyHeading.MatchString(text):
+			n := len(anyHeading.FindStringSubmatch(text)[1])
+			if open >= 0 && n <= level {
+				sections[open].End = pos
+				open = -1
+			}
+			if m := clientHeading.FindStringSubmatch(text); m != nil && open < 0 {
+				sections = append(sections, Section{ID: m[3], Name: m[2], Level: n, Start: pos, Body: pos + len(line)})
+				open, level = len(sections)-1, n
+			}
+		}
+		pos += len(line)
+	}
+	if open >= 0 {
+		sections[open].End = pos
+	}
+	return sections
+}
+
+// HeadingLine starts a new section of client id.
+func HeadingLine(name, id string) string {
+	return headingLine(2, name, id)
+}
+
+func headingLine(level int, name, id string) string {
+	return fmt.Sprintf("%s %s {#client-%s}\n", strings.Repeat("#", level), name, id)
+}
+
+// Replacement replaces the bytes from Start to End with Text.
+type Replacement struct {
+	Start, End int
+	Text       string
+}
+
+// Renames writes the current client names, by client id, into the section
+// headings, at their level. The replacements are ordered from the end of the notes, so the
+// positions of the later ones stay valid while they are applied in order.
+func Renames(notes string, names map[string]string) []Replacement {
+	var r []Replacement
+	sections := ClientSections(notes)
+	for i := len(sections) - 1; i >= 0; i-- {
+		sec := sections[i]
+		if name, ok := names[sec.ID]; ok && name != "" && name != sec.Name {
+			r = append(r, Replacement{Start: sec.Start, End: sec.Body, Text: headingLine(sec.Level, name, sec.ID)})
+		}
+	}
+	return r
+}
@@ -0,0 +1,132 @@
+package notes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClientSections(t *testing.T) {
+	for _, test := range []struct {
+		name, notes string
+		want        []Section
+	}{
+		{
+			name:  "one",
+			notes: "# Session\n## Carla {#client-nABC}\nreverb B\n",
+			want:  []Section{{ID: "nABC", Name: "Carla", Level: 2, Start: 10, Body: 34, End: 43}},
+		},
+		{
+			name:  "ended by the next heading",
+			notes: "## Carla {#client-nA}\nreverb\n## Mixer\nfaders\n",
+			want:  []Section{{ID: "nA", Name: "Carla", Level: 2, Start: 0, Body: 22, End: 29}},
+		},
+		{
+			name:  "nested levels",
+			notes: "## Carla {#client-nA}\n### Presets\nB\n#### Old\nA\n# Song\n",
+			want:  []Section{{ID: "nA", Name: "Carla", Level: 2, Start: 0, Body: 22, End: 47}},
+		},
+		{
+			name:  "higher level ends it",
+			notes: "### Carla {#client-nA}\nB\n## Takes\n",
+			want:  []Section{{ID: "nA", Name: "Carla", Level: 3, Start: 0, Body: 23, End: 25}},
+		},
+		{
+			name:  "client heading inside a section",
+			notes: "## Carla {#client-nA}\n### Mixer {#client-nB}\n## Mixer {#client-nB}\nx",
+			want: []Section{
+				{ID: "nA", Name: "Carla", Level: 2, Start: 0, Body: 22, End: 45},
+				{ID: "nB", Name: "Mixer", Level: 2, Start: 45, Body: 67, End: 68},
+			},
+		},
+		{
+			name:  "fenced code",
+			notes: "## Carla {#client-nA}\n```\n## Mixer {#client-nB}\n```\nB\n",
+			want:  []Section{{ID: "nA", Name: "Carla", Level: 2, Start: 0, Body: 22, End: 54}},
+		},
+		{
+			name:  "other fence inside code",
+			notes: "```\n~~~\n## Mixer {#client-nB}\n```\n## Carla {#client-nA}\n",
+			want:  []Section{{ID: "nA", Name: "Carla", Level: 2, Start: 34, Body: 56, End: 56}},
+		},
+		{
+			name:  "unclosed fence",
+			notes: "~~~\n## Carla {#client-nA}\n",
+		},
+		{
+			name:  "crlf",
+			notes: "## Carla {#client-nA}\r\nB\r\n",
+			want:  []Section{{ID: "nA", Name: "Carla", Level: 2, Start: 0, Body: 23, End: 26}},
+		},
+		{
+			name:  "no id",
+			notes: "## Carla\n#No heading {#client-nA}\n",
+		},
+	} {
+		if got := ClientSections(test.notes); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRenames(t *testing.T) {
+	for _, test := range []struct {
+		name, notes string
+		names       map[string]string
+		want        string
+	}{
+		{
+			name:  "from the end",
+			notes: "## Carla {#client-nA}\nreverb\n## Mixer {#client-nB}\nfaders\n## Synth {#client-nC}\n",
+			names: map[string]string{"nA": "Carla Rack", "nC": "Pad"},
+			want:  "## Carla Rack {#client-nA}\nreverb\n## Mixer {#client-nB}\nfaders\n## Pad {#client-nC}\n",
+		},
+		{
+			name:  "shorter and longer",
+			notes: "# Song\n### Carla Rack {#client-nA}\nB\n## Mixer {#client-nB}\n",
+			names: map[string]string{"nA": "C", "nB": "Mixer and Master Bus"},
+			want:  "# Song\n### C {#client-nA}\nB\n## Mixer and Master Bus {#client-nB}\n",
+		},
+		{
+			name:  "same name and unknown or empty",
+			notes: "## Carla {#client-nA}\n## Mixer {#client-nB}\n",
+			names: map[string]string{"nA": "Carla", "nB": "", "nZ": "Gone"},
+			want:  "## Carla {#client-nA}\n## Mixer {#client-nB}\n",
+		},
+		{
+			name:  "not in code",
+			notes: "```\n## Carla {#client-nA}\n```\n## Carla {#client-nA}\n",
+			names: map[string]string{"nA": "Rack"},
+			want:  "```\n## Carla {#client-nA}\n```\n## Rack {#client-nA}\n",
+		},
+	} {
+		got := test.notes
+		last := len(got) + 1
+		for _, r := range Renames(test.notes, test.names) {
+			if r.End > last {
+				t.Fatalf("%s: replacements not from the end", test.name)
+			}
+			last = r.Start
+			got = got[:r.Start] + r.Text + got[r.End:]
+		}
+		if got != test.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", test.name, got, test.want)
+		}
+	}
+}
+
+// Renames is called on every change of the session, the names that are
+// already in the headings must not touch the notes.
+func TestRenamesUnchanged(t *testing.T) {
+	for _, test := range []struct {
+		name, notes string
+		names       map[string]string
+	}{
+		{"no sections", "# Song\nverse\n", map[string]string{"nA": "Carla"}},
+		{"no clients", "## Carla {#client-nA}\n", nil},
+		{"same names", "## Carla {#client-nA}\n### Mixer {#client-nB}\n", map[string]string{"nA": "Carla", "nB": "Mixer"}},
+	} {
+		if r := Renames(test.notes, test.names); len(r) != 0 {
+			t.Errorf("%s: %v", test.name, r)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/crypt"
+)
+
+const secretInputType = 5 // FL_SECRET_INPUT, go-fltk has no constant for it
+
+var errEncryptedElsewhere = errors.New("the notes were encrypted by another program")
+
+// passphraseDialog asks for a passphrase without blocking the main loop, NSM
+// requests are still answered while it is shown.
+type passphraseDialog struct {
+	win     *fltk.Window
+	message *fltk.Box
+	pass    *fltk.Input
+	confirm *fltk.Input
+	ok      func(pass string) error
+}
+
+func (a *app) buildPassphraseDialog() {
+	d := &passphraseDialog{}
+	w := passphraseWidth - 2*widgetPaddingWidth
+	d.win = fltk.NewWindow(passphraseWidth, passphraseHeight)
+	d.win.SetColor(windowColor)
+	d.win.SetModal()
+
+	y := widgetPaddingWidth
+	d.message = fltk.NewBox(fltk.NO_BOX, widgetPaddingWidth, y, w, 2*passphraseInputHeight)
+	d.message.SetAlign(fltk.ALIGN_INSIDE | fltk.ALIGN_LEFT | fltk.ALIGN_WRAP)
+	y += 2*passphraseInputHeight + widgetPaddingWidth
+	d.pass = fltk.NewInput(widgetPaddingWidth, y, w, passphraseInputHeight)
+	d.pass.SetType(secretInputType)
+	y += passphraseInputHeight + widgetPaddingWidth
+	d.confirm = fltk.NewInput(widgetPaddingWidth, y, w, passphraseInputHeight)
+	d.confirm.SetType(secretInputType)
+	d.confirm.SetTooltip("the passphrase again")
+	y += passphraseInputHeight + widgetPaddingWidth
+
+	cancel := fltk.NewButton(widgetPaddingWidth, y, w/2-widgetPaddingWidth/2, passphraseInputHeight, "Cancel")
+	cancel.SetCallback(func() { a.closePassphraseDialog() })
+	ok := fltk.NewReturnButton(widgetPaddingWidth+w/2+widgetPaddingWidth/2, y, w/2-widgetPaddingWidth/2, passphraseInputHeight, "OK")
+	ok.SetCallback(func() { a.acceptPassphrase() })
+
+	d.win.SetCallback(func() { a.closePassphraseDialog() })
+	d.win.End()
+
+	a.passphrase = d
+}
+
+// askPassphrase shows the dialog, ok is called with the passphrase until it
+// returns nil. With confirm the passphrase is entered twice.
+func (a *app) askPassphrase(title, message string, confirm bool, ok func(pass string) error) {
+	d := a.passphrase
+	d.win.SetLabel(title)
+	d.message.SetLabel(message)
+	d.pass.SetValue("")
+	d.confirm.SetValue("")
+	if confirm {
+		d.confirm.Show()
+	} else {
+		d.confirm.Hide()
+	}
+	d.ok = ok
+	d.win.Show()
+	d.pass.TakeFocus()
+}
+
+func (a *app) acceptPassphrase() {
+	d := a.passphrase
+	pass := d.pass.Value()
+	switch {
+	case pass == "":
+		d.message.SetLabel("Enter a passphrase.")
+		return
+	case d.confirm.Visible() && d.confirm.Value() != pass:
+		d.message.SetLabel("The passphrases don't match.")
+		d.confirm.SetValue("")
+		d.confirm.TakeFocus()
+		return
+	}
+	if err := d.ok(pass); err != nil {
+		d.message.SetLabel(fmt.Sprintf("%v", err))
+		d.pass.SetValue("")
+		d.pass.TakeFocus()
+		return
+	}
+	a.closePassphraseDialog()
+}
+
+func (a *app) closePassphraseDialog() {
+	d := a.passphrase
+	d.pass.SetValue("")
+	d.confirm.SetValue("")
+	d.ok = nil
+	d.win.Hide()
+}
+
+// readNotes reads the notes file, encrypted notes are decrypted with the key
+// they were written with.
+func (a *app) readNotes() (string, error) {
+	data, err := os.ReadFile(a.fileName)
+	if err != nil {
+		return "", err
+	}
+	text, err := a.decodeNotes(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", a.fileName, err)
+	}
+	return text, nil
+}
+
+// decodeNotes decrypts data written by encodeNotes.
+func (a *app) decodeNotes(data []byte) (string, error) {
+	if !crypt.IsEncrypted(data) {
+		return string(data), nil
+	}
+	if a.diskKey == nil {
+		return "", errEncryptedElsewhere
+	}
+	plain, err := a.diskKey.Decrypt(data)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// encodeNotes is text as it is written to the notes or recovery file.
+func (a *app) encodeNotes(text string) ([]byte, error) {
+	if a.key == nil {
+		return []byte(text), nil
+	}
+	return a.key.Encrypt([]byte(text))
+}
+
+// setLocked shows the unlock button instead of the editor while the notes are
+// encrypted and the passphrase wasn't entered.
+func (a *app) setLocked(locked bool) {
+	a.locked = locked
+	if locked {
+		a.metaForm.win.Hide()
+		a.TextEditor.Hide()
+		a.lockButton.Show()
+	} else {
+		a.lockButton.Hide()
+		a.TextEditor.Show()
+	}
+	a.layoutEditorRow()
+	a.updateStatus()
+}
+
+// lockEncryptedNotes is called when NSM opens encrypted notes. The open is
+// answered right away, the notes are read when the user enters the passphrase.
+func (a *app) lockEncryptedNotes() {
+	a.key, a.diskKey = nil, nil
+	a.setNotesText("")
+	a.diskText = ""
+	a.setLocked(true)
+	a.askUnlock()
+}
+
+func (a *app) askUnlock() {
+	if !a.locked {
+		return
+	}
+	a.askPassphrase(APP_TITLE+" unlock", "The notes of this session are encrypted.\nEnter the passphrase:", false, a.unlock)
+}
+
+func (a *app) unlock(pass string) error {
+	data, err := os.ReadFile(a.fileName)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if !crypt.IsEncrypted(data) {
+		// decrypted by another program meanwhile.
+		a.setNotesText(string(data))
+		a.diskText = string(data)
+		a.setLocked(false)
+		a.setAppClean()
+		return nil
+	}
+	key, plain, err := crypt.Unlock(data, pass)
+	if err != nil {
+		return err
+	}
+	a.key, a.diskKey = key, key
+	a.setNotesText(string(plain))
+	a.diskText = string(plain)
+	a.setLocked(false)
+	a.setAppClean()
+	return nil
+}
+
+// encryptNotes sets a new passphrase, it also upgrades plain notes. The file is
+// written right away, the plain text doesn't stay on disk until the next save.
+func (a *app) encryptNotes() {
+	if a.locked {
+		a.askUnlock()
+		return
+	}
+	message := "Encrypt the notes with a passphrase.\nIt can't be recovered when it's lost."
+	if a.key != nil {
+		message = "Enter the new passphrase of the notes."
+	}
+	a.askPassphrase(APP_TITLE+" encrypt", message, true, func(pass string) error {
+		key, err := crypt.NewKey(pass)
+		if err != nil {
+			return err
+		}
+		a.key = key
+		a.setAppDirty()
+		a.callbackMenuFileSave()
+		return nil
+	})
+}
+
+func (a *app) decryptNotes() {
+	if a.locked || a.key == nil {
+		return
+	}
+	if fltk.ChoiceDialog("Store the notes in plain text again?", "Cancel", "Remove encryption") != 1 {
+		return
+	}
+	a.key = nil
+	a.setAppDirty()
+	a.callbackMenuFileSave()
+}
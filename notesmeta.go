@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/frontmatter"
+	nsm "nsm-notes/nsmclient"
+)
+
+var noteStatuses = []string{"draft", "recording", "mixing", "done"}
+
+// metaForm edits the front matter of the notes, it isn't shown in the editor.
+type metaForm struct {
+	win                       *fltk.Window
+	title, artist, tempo, key *fltk.Input
+	tags                      *fltk.Input
+	status                    *fltk.InputChoice
+	filling                   bool // the inputs are set from the notes, not typed
+}
+
+func (a *app) buildMetaForm() {
+	f := &metaForm{}
+	x := widgetPaddingWidth + metaLabelWidth
+	w := metaFormWidth - x - widgetPaddingWidth
+	f.win = fltk.NewWindow(metaFormWidth, 6*(metaInputHeight+widgetPaddingWidth)+widgetPaddingWidth)
+	f.win.SetLabel(APP_TITLE + " properties")
+	f.win.SetColor(windowColor)
+
+	y := widgetPaddingWidth
+	input := func(label, tooltip string) *fltk.Input {
+		in := fltk.NewInput(x, y, w, metaInputHeight, label)
+		in.SetTooltip(tooltip)
+		in.SetCallbackCondition(fltk.WhenChanged)
+		in.SetCallback(func() { a.metaFormChanged() })
+		y += metaInputHeight + widgetPaddingWidth
+		return in
+	}
+	f.title = input("Title", "also shown as the label of the client in the session manager")
+	f.artist = input("Artist", "")
+	f.tempo = input("Tempo", "bpm")
+	f.key = input("Key", "")
+
+	f.status = fltk.NewInputChoice(x, y, w, metaInputHeight, "Status")
+	f.status.SetCallbackCondition(fltk.WhenChanged)
+	f.status.SetCallback(func() { a.metaFormChanged() })
+	for _, s := range noteStatuses {
+		s := s
+		f.status.MenuButton().Add(s, func() {
+			f.status.SetValue(s)
+			a.metaFormChanged()
+		})
+	}
+	y += metaInputHeight + widgetPaddingWidth
+
+	f.tags = input("Tags", "separated by commas, the search command finds notes by tag")
+
+	f.win.End()
+	a.metaForm = f
+}
+
+func (a *app) showMetaForm() {
+	if a.locked {
+		a.askUnlock()
+		return
+	}
+	a.fillMetaForm()
+	a.metaForm.win.Show()
+	a.metaForm.title.TakeFocus()
+}
+
+func (a *app) fillMetaForm() {
+	f := a.metaForm
+	if f == nil {
+		return
+	}
+	f.filling = true
+	defer func() { f.filling = false }()
+
+	f.title.SetValue(a.meta.Title)
+	f.artist.SetValue(a.meta.Artist)
+	f.tempo.SetValue(a.meta.Tempo)
+	f.key.SetValue(a.meta.Key)
+	f.status.SetValue(a.meta.Status)
+	f.tags.SetValue(strings.Join(a.meta.Tags, ", "))
+}
+
+func (a *app) metaFormChanged() {
+	f := a.metaForm
+	if f.filling || a.locked {
+		return
+	}
+	a.meta.Title = strings.TrimSpace(f.title.Value())
+	a.meta.Artist = strings.TrimSpace(f.artist.Value())
+	a.meta.Tempo = strings.TrimSpace(f.tempo.Value())
+	a.meta.Key = strings.TrimSpace(f.key.Value())
+	a.meta.Status = strings.TrimSpace(f.status.Value())
+	a.meta.Tags = frontmatter.ParseTags(f.tags.Value())
+	a.frontBlock = a.meta.Format()
+	a.setAppDirty()
+	a.sendTitleLabel()
+}
+
+// splitNotes takes the front matter off notes read from the file and returns
+// the body for the editor.
+func (a *app) splitNotes(text string) string {
+	meta, block, body := frontmatter.Split(text)
+	a.meta, a.frontBlock = meta, block
+	a.fillMetaForm()
+	a.sendTitleLabel()
+	return body
+}
+
+// replaceNotes sets notes changed by another program, it can be undone.
+func (a *app) replaceNotes(text string) {
+	a.TextBuffer.SetText(a.splitNotes(text))
+}
+
+// notesText is the notes as they are written to the file. The front matter
+// block is kept as it was read until the form changes it.
+func (a *app) notesText() string {
+	return a.frontBlock + a.TextBuffer.Text()
+}
+
+func (a *app) sendTitleLabel() {
+	if a.meta.Title == a.label {
+		return
+	}
+	a.label = a.meta.Title
+	if err := sendNotesLabel(a.NsmClient, a.label); err != nil {
+		a.logf("%v", err)
+	}
+}
+
+// sendNotesLabel shows the title of the notes next to the client in the
+// session manager, servers without labels are fine.
+func sendNotesLabel(c *nsm.NsmClient, title string) error {
+	if err := c.NsmSendLabel(title); err != nil && !errors.Is(err, nsm.NsmUnsupportedErr) {
+		return err
+	}
+	return nil
+}
@@ -1,13 +1,13 @@
 package nsmclient
 
-type nsmCapability string
+type NsmCapability string
 
-func (c nsmCapability) String() string {
+func (c NsmCapability) String() string {
 	return string(c)
 }
 
-type nsmServerCapability string
+type NsmServerCapability string
 
-func (s nsmServerCapability) String() string {
+func (s NsmServerCapability) String() string {
 	return string(s)
 }
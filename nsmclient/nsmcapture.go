@@ -0,0 +1,201 @@
+package nsmclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scgolang/osc"
+)
+
+// Capture directions, seen from the client.
+const (
+	NsmCaptureIn  = "in"
+	NsmCaptureOut = "out"
+)
+
+// NsmCaptureRecord is one line of a capture file, a JSON object per message.
+type NsmCaptureRecord struct {
+	Time    time.Time       `json:"time"`
+	Dir     string          `json:"dir"`
+	Peer    string          `json:"peer,omitempty"`
+	Address string          `json:"address"`
+	Args    []NsmCaptureArg `json:"args,omitempty"`
+}
+
+// NsmCaptureArg is an OSC argument, Type is its type tag: i, f, s, b (base64), T or F.
+type NsmCaptureArg struct {
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+// nsmCapture writes the records, messages come from the OSC server and the sender goroutine.
+type nsmCapture struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer // the file opened for NSM_CLIENT_CAPTURE
+}
+
+// NsmSetCapture writes every message the client sends and receives to w.
+// It has to be called before NsmInit. Without it, NSM_CLIENT_CAPTURE names a capture file.
+func (c *NsmClient) NsmSetCapture(w io.Writer) {
+	c.nsmCapture = &nsmCapture{enc: json.NewEncoder(w)}
+}
+
+func (c *NsmClient) nsmOpenCapture() error {
+	path := os.Getenv(NsmEnvCapture)
+	if c.nsmCapture != nil || path == "" {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("capture: %v", err)
+	}
+	c.nsmCapture = &nsmCapture{enc: json.NewEncoder(f), closer: f}
+	return nil
+}
+
+func (c *NsmClient) nsmCloseCapture() error {
+	if c.nsmCapture == nil || c.nsmCapture.closer == nil {
+		return nil
+	}
+	c.nsmCapture.mu.Lock()
+	defer c.nsmCapture.mu.Unlock()
+	return c.nsmCapture.closer.Close()
+}
+
+// nsmObserve traces and captures a message, dir is NsmCaptureIn or NsmCaptureOut.
+func (c *NsmClient) nsmObserve(dir string, msg osc.Message, peer net.Addr) {
+	c.nsmTrace(dir, msg, peer)
+	if c.nsmCapture == nil {
+		return
+	}
+	record := NsmCaptureRecordOf(dir, msg, peer)
+	c.nsmCapture.mu.Lock()
+	defer c.nsmCapture.mu.Unlock()
+	if err := c.nsmCapture.enc.Encode(record); err != nil {
+		c.nsmLogger.Error("capture", "err", err)
+	}
+}
+
+// NsmCaptureRecordOf returns the record of msg, stamped with the current time.
+func NsmCaptureRecordOf(dir string, msg osc.Message, peer net.Addr) NsmCaptureRecord {
+	r := NsmCaptureRecord{Time: time.Now(), Dir: dir, Address: msg.Address}
+	if peer != nil {
+		r.Peer = peer.String()
+	}
+	for _, arg := range msg.Arguments {
+		a := NsmCaptureArg{Type: string(arg.Typetag())}
+		switch v := arg.(type) {
+		case osc.Int:
+			a.Value = int32(v)
+		case osc.Float:
+			a.Value = float32(v)
+		case osc.String:
+			a.Value = string(v)
+		case osc.Blob:
+			a.Value = base64.StdEncoding.EncodeToString(v)
+		case osc.Bool:
+			a.Value = bool(v)
+		default:
+			a.Value = arg.String()
+		}
+		r.Args = append(r.Args, a)
+	}
+	return r
+}
+
+// Message turns the record back into the OSC message.
+func (r NsmCaptureRecord) Message() (osc.Message, error) {
+	msg := osc.Message{Address: r.Address}
+	for i, a := range r.Args {
+		arg, err := a.argument()
+		if err != nil {
+			return osc.Message{}, fmt.Errorf("%s argument %d: %v", r.Address, i, err)
+		}
+		msg.Arguments = append(msg.Arguments, arg)
+	}
+	return msg, nil
+}
+
+func (a NsmCaptureArg) argument() (osc.Argument, error) {
+	switch a.Type {
+	case "i", "f":
+		// encoding/json decodes every number as float64.
+		var x float64
+		switch v := a.Value.(type) {
+		case float64:
+			x = v
+		case int32:
+			x = float64(v)
+		case float32:
+			x = float64(v)
+		default:
+			return nil, fmt.Errorf("type %s: not a number: %v", a.Type, a.Value)
+		}
+		if a.Type == "i" {
+			return osc.Int(int32(x)), nil
+		}
+		return osc.Float(float32(x)), nil
+	case "s":
+		s, ok := a.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("type s: not a string: %v", a.Value)
+		}
+		return osc.String(s), nil
+	case "b":
+		s, ok := a.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("type b: not a base64 string: %v", a.Value)
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("type b: %v", err)
+		}
+		return osc.Blob(b), nil
+	case "T":
+		return osc.Bool(true), nil
+	case "F":
+		return osc.Bool(false), nil
+	}
+	return nil, fmt.Errorf("unsupported type %q", a.Type)
+}
+
+func (r NsmCaptureRecord) String() string {
+	args := make([]string, len(r.Args))
+	for i, a := range r.Args {
+		if s, ok := a.Value.(string); ok && a.Type == "s" {
+			args[i] = fmt.Sprintf("%q", s)
+			continue
+		}
+		args[i] = fmt.Sprint(a.Value)
+	}
+	return strings.TrimSpace(fmt.Sprintf("%-3s %s %s", r.Dir, r.Address, strings.Join(args, " ")))
+}
+
+// NsmReadCapture reads a capture written with NsmSetCapture or NSM_CLIENT_CAPTURE.
+func NsmReadCapture(r io.Reader) ([]NsmCaptureRecord, error) {
+	var records []NsmCaptureRecord
+	dec := json.NewDecoder(r)
+	for {
+		var record NsmCaptureRecord
+		err := dec.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("capture record %d: %v", len(records)+1, err)
+		}
+		if record.Dir != NsmCaptureIn && record.Dir != NsmCaptureOut {
+			return records, fmt.Errorf("capture record %d: unknown direction %q", len(records)+1, record.Dir)
+		}
+		records = append(records, record)
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/scgolang/osc"
@@ -19,6 +20,9 @@ var (
 	nsmReceiverTimeoutErr = errors.New("timeout")
 	NsmGotSigtermErr      = errors.New("SIGTERM")
 	NsmServerInactiveErr  = errors.New("Nsm server inactive")
+	NsmClientStoppedErr   = errors.New("Nsm client stopped")
+	NsmStopTimeoutErr     = errors.New("Nsm client goroutines didn't stop in time")
+	NsmUnsupportedErr     = errors.New("not supported by the Nsm server")
 )
 
 type NsmOpenCallback func(path, displayName, nsmClientId string) (outMsg string, err error)
@@ -28,73 +32,85 @@ type NsmHideGuiCallback func() error
 type NsmActiveCallback func(b bool) error
 type NsmSessionIsLoadedCallback func() error
 type NsmBroadcastCallback func(s string, m osc.Message) error
+type NsmLabelCallback func(label string) error
+type NsmErrorCallback func(err error)
+type NsmShutdownCallback func() error
 
 type nsmChannels struct {
 	nsmOpenInChan            chan []string
 	nsmSaveInChan            chan bool
 	nsmSessionIsLoadedInChan chan bool
-	nsmActiveInChan          chan bool
-	nsmGuiShowInChan         chan bool
-	nsmGuiHideInChan         chan bool
+	nsmActiveInChan          chan nsmAnnounceResult
+	nsmGuiInChan             chan bool // true shows the GUI, false hides it
 	nsmBroadcastChan         chan bool
 	nsmSigtermSignal         chan os.Signal
-	nsmReplyOutChan          chan NsmReply
-	nsmAnnounceOutChan       chan bool
-	nsmGuiShownOutChan       chan bool
-	nsmGuiHiddenOutChan      chan bool
-	nsmIsDirtyOutChan        chan bool
-	nsmIsCleanOutChan        chan bool
-	nsmProgressOutChan       chan bool
-	nsmMessageOutChan        chan string
-	nsmLabelOutChan          chan string
-	nsmBroadcastOutChan      chan osc.Message
 	nsmSenderErrChan         chan error
 	nsmCloseSenderChan       chan bool
 	nsmOscErrLogChan         chan error
 }
 
 func (c *nsmChannels) nsmInitChannels() {
-	c.nsmOpenInChan = make(chan []string)
-	c.nsmSaveInChan = make(chan bool)
-	c.nsmSessionIsLoadedInChan = make(chan bool)
-	c.nsmActiveInChan = make(chan bool)
-	c.nsmGuiShowInChan = make(chan bool)
-	c.nsmGuiHideInChan = make(chan bool)
+	// the OSC server goroutine never waits for nsmReceiver, see nsmOffer and nsmReplace.
+	c.nsmOpenInChan = make(chan []string, 1)
+	c.nsmSaveInChan = make(chan bool, 1)
+	c.nsmSessionIsLoadedInChan = make(chan bool, 1)
+	c.nsmActiveInChan = make(chan nsmAnnounceResult, 1)
+	c.nsmGuiInChan = make(chan bool, 1)
 	c.nsmBroadcastChan = make(chan bool)
 	c.nsmSigtermSignal = make(chan os.Signal, 1)
-	c.nsmReplyOutChan = make(chan NsmReply)
-	c.nsmAnnounceOutChan = make(chan bool)
-	c.nsmGuiShownOutChan = make(chan bool)
-	c.nsmGuiHiddenOutChan = make(chan bool)
-	c.nsmIsDirtyOutChan = make(chan bool)
-	c.nsmIsCleanOutChan = make(chan bool)
-	c.nsmProgressOutChan = make(chan bool)
-	c.nsmMessageOutChan = make(chan string)
-	c.nsmLabelOutChan = make(chan string)
-	c.nsmBroadcastOutChan = make(chan osc.Message)
-	c.nsmSenderErrChan = make(chan error)
+	c.nsmSenderErrChan = make(chan error, nsmSenderErrChanSize)
 	c.nsmCloseSenderChan = make(chan bool)
-	c.nsmOscErrLogChan = make(chan error)
+	c.nsmOscErrLogChan = make(chan error, nsmSenderErrChanSize)
+}
+
+// nsmAnnounceResult is the server's answer to the announce, applied by nsmReceiver.
+type nsmAnnounceResult struct {
+	active       bool
+	welcome      string
+	serverName   string
+	capabilities string
 }
 
 type NsmClient struct {
 	nsmChannels
+	nsmOutQueue *nsmOutQueue
 	osc.Conn
 	nsmClientAddr         *net.UDPAddr // TODO adjust to servers protocol (udp/tcp/unix)
-	nsmServerAddr         *net.UDPAddr
+	nsmServerAddr         net.Addr
 	nsmServerName         string
 	nsmServerCapabilities string
-	nsmServerIsActive     bool
+	nsmServerWelcome      string
+	nsmServerApiMajor     int
+	nsmServerApiMinor     int
+	nsmServerIsActive     atomic.Bool // read by the sender goroutine
+	nsmAnnouncePending    atomic.Bool // an announce was sent and not answered yet
+	nsmAnnounced          atomic.Bool // the server welcomed the client, set by the OSC server goroutine
+	nsmWelcomeAddr        net.Addr    // the sender of the welcome, only used by the OSC server goroutine
+	nsmLoopbackOnly       bool
 	nsmClientId           string
+	nsmDisplayName        string
 	nsmUrl                string
 	nsmPrettyClientName   string
 	nsmClientCapabilities string
 	nsmApiVersionMajor    int
 	nsmApiVersionMinor    int
 	nsmClientPid          int
+	nsmExecutableName     string
 	nsmAnnounceTimeout    time.Duration
 	nsmOscCtx             context.Context
 	nsmOscCancel          context.CancelFunc
+	nsmOscMethods         map[string]osc.Method
+	nsmExtensionMethods   map[string]osc.Method
+	nsmLogger             *slog.Logger
+	nsmCapture            *nsmCapture
+	nsmStopTimeout        time.Duration
+	nsmStopOnce           sync.Once
+	nsmWaitGroup          sync.WaitGroup // the OSC server and sender goroutines
+	nsmSignalMu           sync.Mutex
+	nsmSignalHandlers     []*NsmSignalHandler
+	nsmStateMu            sync.Mutex // the state sent again after the welcome
+	nsmLabel              *string
+	nsmGuiShown           *bool
 
 	open NsmOpenCallback // NOTE does this need to be a pointer?
 
@@ -110,26 +126,75 @@ type NsmClient struct {
 
 	label NsmLabelCallback
 
-	broadcast NsmBroadCallback
+	broadcast NsmBroadcastCallback
+
+	errLog NsmErrorCallback
+
+	shutdown NsmShutdownCallback
 }
 
 func (c *NsmClient) NsmIsActive() bool {
-	return c.nsmServerIsActive
+	return c.nsmServerIsActive.Load()
 }
 
 func (c *NsmClient) NsmGetSessionManagerName() string {
 	return c.nsmServerName
 }
 
+func (c *NsmClient) NsmGetClientId() string {
+	return c.nsmClientId
+}
+
+func (c *NsmClient) NsmGetDisplayName() string {
+	return c.nsmDisplayName
+}
+
 func (c *NsmClient) NsmGetSessionManagerFeatures() string {
 	return c.nsmServerCapabilities
 }
 
+// NsmGetServerWelcome is the message of the server's announce reply.
+func (c *NsmClient) NsmGetServerWelcome() string {
+	return c.nsmServerWelcome
+}
+
+// NsmGetServerApiVersion is the API version of the server, known by its name.
+// Unknown servers are taken to speak the client's version.
+func (c *NsmClient) NsmGetServerApiVersion() (major, minor int) {
+	return c.nsmServerApiMajor, c.nsmServerApiMinor
+}
+
+// NsmGetApiVersion is the API version client and server agreed on, the lower one
+// of both. It is 0.0 before the announce.
+func (c *NsmClient) NsmGetApiVersion() (major, minor int) {
+	if !c.NsmIsActive() {
+		return 0, 0
+	}
+	major, minor = c.nsmServerApiMajor, c.nsmServerApiMinor
+	if c.nsmApiVersionMajor < major || c.nsmApiVersionMajor == major && c.nsmApiVersionMinor < minor {
+		major, minor = c.nsmApiVersionMajor, c.nsmApiVersionMinor
+	}
+	return major, minor
+}
+
+// NsmApiVersionAtLeast reports whether the agreed API version is major.minor or later.
+func (c *NsmClient) NsmApiVersionAtLeast(major, minor int) bool {
+	m, n := c.NsmGetApiVersion()
+	return m > major || m == major && n >= minor
+}
+
+func (c *NsmClient) nsmServerApiVersion(serverName string) (major, minor int) {
+	if v, ok := nsmServerApiVersions[serverName]; ok {
+		return v[0], v[1]
+	}
+	return c.nsmApiVersionMajor, c.nsmApiVersionMinor
+}
+
 func (c *NsmClient) setNsmServerCapabilities(s string) { // TODO FIXME
 	c.nsmServerCapabilities = s
 }
 
-func (c *NsmClient) NsmServerHasCapability(capability nsmServerCapability) bool {
+func (c *NsmClient) NsmServerHasCapability(capability NsmServerCapability) bool {
 	return strings.Contains(c.nsmServerCapabilities, capability.String())
 }
 
@@ -145,14 +210,14 @@ func (c *NsmClient) NsmServerHasCapabilityServerControl() bool {
 	return strings.Contains(c.nsmServerCapabilities, NSM_S_SERVER_CONTROL.String())
 }
 
-func (c *NsmClient) NsmSetClientCapabilities(capabilities ...nsmCapability) error {
+func (c *NsmClient) NsmSetClientCapabilities(capabilities ...NsmCapability) error {
 	// This overwrites existing capacities
 	var sep = ":"
 	for _, p := range capabilities {
 		if p == "" {
 			return fmt.Errorf("capability is empty")
 		}
-		if p != NSM_BROADCAST && p != NSM_OPTIONAL_GUI && p != NSM_SWITCH && p != NSM_MESSAGE && p != NSM_DIRTY && p != NSM_PROGRESS {
+		if p != NSM_BROADCAST && p != NSM_OPTIONAL_GUI && p != NSM_SWITCH && p != NSM_MESSAGE && p != NSM_DIRTY && p != NSM_PROGRESS && p != NSM_MONITOR {
 			return fmt.Errorf("unknown capability: %s", p)
 		}
 	}
@@ -166,8 +231,23 @@ func (c *NsmClient) NsmSetClientCapabilities(capabilities ...nsmCapability) erro
 	return nil
 }
 
+// NsmSetClientCapabilitiesString sets the capabilities as written in an announce, e.g. ":dirty:message:".
+func (c *NsmClient) NsmSetClientCapabilitiesString(s string) error {
+	var capabilities []NsmCapability
+	for _, p := range strings.Split(s, ":") {
+		if p != "" {
+			capabilities = append(capabilities, NsmCapability(":"+p+":"))
+		}
+	}
+	return c.NsmSetClientCapabilities(capabilities...)
+}
+
+func (c *NsmClient) NsmClientHasCapability(capability NsmCapability) bool {
+	return strings.Contains(c.nsmClientCapabilities, capability.String())
+}
+
 func (c *NsmClient) NsmClientHasCapabilityOptionalGui() bool {
-	return strings.Contains(c.nsmClientCapabilities, NSM_OPTIONAL_GUI.String())
+	return c.NsmClientHasCapability(NSM_OPTIONAL_GUI)
 }
 
 func (c *NsmClient) NsmClientCapabilities() string {
@@ -176,9 +256,12 @@ func (c *NsmClient) NsmClientCapabilities() string {
 
 func NsmNewClient() *NsmClient {
 	return &NsmClient{
-		nsmApiVersionMajor: nsmApiVersionMajor,
-		nsmApiVersionMinor: nsmApiVersionMinor,
+		nsmApiVersionMajor: NsmApiVersionMajor,
+		nsmApiVersionMinor: NsmApiVersionMinor,
 		nsmClientPid:       os.Getpid(),
+		nsmExecutableName:  os.Args[0],
+		nsmLogger:          NsmNewLogger(os.Stderr),
+		nsmLoopbackOnly:    nsmEnvEnabled(NsmEnvLoopback),
 	}
 }
 
@@ -193,7 +276,7 @@ func (c *NsmClient) NsmSetApiVersionMinor(v int) error {
 }
 
 func (c *NsmClient) setNsmIsActive(b bool) {
-	c.nsmServerIsActive = b
+	c.nsmServerIsActive.Store(b)
 }
 
 func (c *NsmClient) setSessionManagerName(name string) {
@@ -239,186 +322,326 @@ func (c *NsmClient) NsmSetSessionIsLoadedCallback(sessionIsLoadedCallback NsmSes
 	c.sessionIsLoaded = sessionIsLoadedCallback
 }
 
-func (c *NsmClient) NsmSetBroadcastCallback(broadcastCallback NsmBroadCallback) {
+func (c *NsmClient) NsmSetBroadcastCallback(broadcastCallback NsmBroadcastCallback) {
 	c.broadcast = broadcastCallback
 }
 
-func (c *NsmClient) nsmOscOpen(msg osc.Message) error {
-	if expected, got := 3, len(msg.Arguments); expected != got {
-		return fmt.Errorf("nsmOscOpen, expected %d arguments, got %d", expected, got)
-	}
+// NsmSetShutdownCallback runs first in NsmStop, before the queued messages are flushed.
+// NSM sends SIGTERM also when the user aborts the session, so the callback shouldn't
+// overwrite the session files: the server already asked for a save if one was wanted.
+func (c *NsmClient) NsmSetShutdownCallback(shutdownCallback NsmShutdownCallback) {
+	c.shutdown = shutdownCallback
+}
 
-	if c.open == nil { // NOTE here or in goroutine?
-		return fmt.Errorf("open callback not set")
-	}
+// NsmSetErrorCallback receives connection errors, it runs in NsmCheckWait like the other callbacks.
+func (c *NsmClient) NsmSetErrorCallback(errorCallback NsmErrorCallback) {
+	c.errLog = errorCallback
+}
 
-	path, err := msg.Arguments[0].ReadString()
-	if err != nil {
-		return fmt.Errorf("%v", err)
+// The nsmOsc* handlers run in the OSC server goroutine, they check the arguments
+// and hand the request to nsmReceiver without waiting for it. An error only means
+// the message is ignored, nsmOscHandler logs it.
+
+func (c *NsmClient) nsmOscOpen(msg osc.Message) error {
+	if err := c.nsmCheckAnnounced(msg, "sss"); err != nil {
+		return err
 	}
-	displayName, err := msg.Arguments[1].ReadString()
-	if err != nil {
-		return fmt.Errorf("%v", err)
+	if c.open == nil {
+		c.nsmReplyError(NsmAddrClientOpen, NSM_ERR_GENERAL_ERROR, "open callback not set")
+		return nil
 	}
+	path, _ := msg.Arguments[0].ReadString()
+	displayName, _ := msg.Arguments[1].ReadString()
+	nsmClientId, _ := msg.Arguments[2].ReadString()
 
-	nsmClientId, err := msg.Arguments[2].ReadString()
-	if err != nil {
-		return fmt.Errorf("%v", err)
+	if !nsmOffer(c.nsmOpenInChan, []string{path, displayName, nsmClientId}) {
+		c.nsmReplyError(NsmAddrClientOpen, NSM_ERR_OPERATION_PENDING, "an open is still pending")
 	}
-
-	var args = []string{path, displayName, nsmClientId}
-
-	c.nsmOpenInChan <- args
-
 	return nil
 }
 
 func (c *NsmClient) nsmOscSave(msg osc.Message) error {
-	if expected, got := 0, len(msg.Arguments); expected != got {
-		return fmt.Errorf("nsmOscSave, expected %d arguments, got %d", expected, got)
+	if err := c.nsmCheckAnnounced(msg, ""); err != nil {
+		return err
 	}
 	if c.save == nil {
-		return fmt.Errorf("save callback not set")
+		c.nsmReplyError(NsmAddrClientSave, NSM_ERR_GENERAL_ERROR, "save callback not set")
+		return nil
+	}
+	if !nsmOffer(c.nsmSaveInChan, true) {
+		c.nsmReplyError(NsmAddrClientSave, NSM_ERR_OPERATION_PENDING, "a save is still pending")
 	}
-
-	c.nsmSaveInChan <- true
-
 	return nil
 }
 
-func (c *NsmClient) nsmOscAnnounceReply(msg osc.Message) error {
-	if got := len(msg.Arguments); got != 4 {
-		return fmt.Errorf("expected 4 arguments in announce reply, got %d", got)
+// nsmOscReply takes the welcome of the server, other replies are only traced.
+func (c *NsmClient) nsmOscReply(msg osc.Message) error {
+	if err := nsmCheckArgs(msg, "s", true); err != nil {
+		return err
 	}
-
-	p, err := msg.Arguments[0].ReadString()
-	if err != nil {
-		return fmt.Errorf("%v", err)
+	if p, _ := msg.Arguments[0].ReadString(); p != NsmAddrServerAnnouce {
+		return nil // e.g. the reply to NsmSendServerSave
 	}
-
-	_, err = msg.Arguments[1].ReadString()
-	if err != nil {
-		return fmt.Errorf("%v", err)
+	if err := nsmCheckArgs(msg, "ssss", true); err != nil {
+		return err
 	}
-
-	smName, err := msg.Arguments[2].ReadString()
-	if err != nil {
-		return fmt.Errorf("%v", err)
+	if !c.nsmFromServer(msg.Sender) {
+		return fmt.Errorf("announce reply not from the server at %v", c.nsmServerAddr)
+	}
+	if !c.nsmAnnouncePending.CompareAndSwap(true, false) {
+		return fmt.Errorf("announce reply without an announce")
 	}
+	welcome, _ := msg.Arguments[1].ReadString()
+	smName, _ := msg.Arguments[2].ReadString()
+	capabilities, _ := msg.Arguments[3].ReadString()
+
+	c.nsmWelcomeAddr = msg.Sender
+	c.nsmAnnounced.Store(true)
+	nsmReplace(c.nsmActiveInChan, nsmAnnounceResult{active: true, welcome: welcome, serverName: smName, capabilities: capabilities})
+	return nil
+}
 
-	capabilities, err := msg.Arguments[3].ReadString()
-	if err != nil {
-		return fmt.Errorf("%v", err)
+// nsmOscError takes the refusal of the announce, other errors go to the error callback.
+func (c *NsmClient) nsmOscError(msg osc.Message) error {
+	if err := nsmCheckArgs(msg, "sis", true); err != nil {
+		return err
 	}
+	p, _ := msg.Arguments[0].ReadString()
+	code, _ := msg.Arguments[1].ReadInt32()
+	text, _ := msg.Arguments[2].ReadString()
+	nsmErr := NsmErr(NsmErrCode(code), text)
 
 	if p != NsmAddrServerAnnouce {
-		return fmt.Errorf("announce reply: addr %v, doesn't match %v", p, NsmAddrServerAnnouce)
+		if err := c.nsmCheckSessionManager(msg); err != nil {
+			return err
+		}
+		c.nsmHandlerError(fmt.Errorf("%s failed: %w", p, &nsmErr))
+		return nil
 	}
+	if !c.nsmFromServer(msg.Sender) {
+		return fmt.Errorf("announce error not from the server at %v", c.nsmServerAddr)
+	}
+	if !c.nsmAnnouncePending.CompareAndSwap(true, false) {
+		return fmt.Errorf("announce error without an announce")
+	}
+	c.nsmLogger.Error("failed to register with NSM server", "code", code, "err", text)
+	nsmReplace(c.nsmActiveInChan, nsmAnnounceResult{active: false})
+	return nil
+}
 
-	c.setNsmIsActive(true)
-	c.setSessionManagerName(smName)
-	c.setNsmServerAddress(msg.Address) // TODO
-	c.setNsmServerCapabilities(capabilities)
+func (c *NsmClient) nsmOscSessionIsLoaded(msg osc.Message) error {
+	if err := c.nsmCheckAnnounced(msg, ""); err != nil {
+		return err
+	}
+	if c.sessionIsLoaded != nil {
+		nsmOffer(c.nsmSessionIsLoadedInChan, true) // one pending is enough
+	}
+	return nil
+}
 
-	//fmt.Printf("NSM: Successfully registered. NSM server says: %s \n", serverMsg)
+func (c *NsmClient) nsmOscShow(msg osc.Message) error {
+	return c.nsmOscGui(msg, true, c.show != nil)
+}
 
-	c.nsmActiveInChan <- c.nsmServerIsActive
+func (c *NsmClient) nsmOscHide(msg osc.Message) error {
+	return c.nsmOscGui(msg, false, c.hide != nil)
+}
 
+// nsmOscGui queues showing or hiding the GUI, only the latest request counts.
+func (c *NsmClient) nsmOscGui(msg osc.Message, show, handled bool) error {
+	if err := c.nsmCheckAnnounced(msg, ""); err != nil {
+		return err
+	}
+	if handled {
+		nsmReplace(c.nsmGuiInChan, show)
+	}
 	return nil
+}
 
+// nsmCheckAnnounced rejects the session requests before the server welcomed the
+// client, from others than the session manager and with other arguments than typetags.
+func (c *NsmClient) nsmCheckAnnounced(msg osc.Message, typetags string) error {
+	if err := c.nsmCheckSessionManager(msg); err != nil {
+		return err
+	}
+	return nsmCheckArgs(msg, typetags, false)
 }
 
-func (c *NsmClient) nsmOscError(msg osc.Message) error {
-	if expected, got := 3, len(msg.Arguments); expected != got {
-		return fmt.Errorf("nsmOscError, expected %d arguments, got %d", expected, got)
+// nsmCheckSessionManager rejects messages from others than the sender of the welcome.
+func (c *NsmClient) nsmCheckSessionManager(msg osc.Message) error {
+	if !c.nsmAnnounced.Load() {
+		return fmt.Errorf("not announced")
 	}
-	p, err := msg.Arguments[0].ReadString()
-	if err != nil {
-		return fmt.Errorf("%v", err)
+	if msg.Sender == nil || msg.Sender.String() != c.nsmWelcomeAddr.String() {
+		return fmt.Errorf("not from the session manager at %v", c.nsmWelcomeAddr)
 	}
-	if p != NsmAddrServerAnnouce {
-		return fmt.Errorf("err: addr %v, doesn't match %v", p, NsmAddrServerAnnouce)
+	return nil
+}
+
+// nsmFromServer reports whether the answer to the announce can come from addr.
+// A server listening on all interfaces may answer from another address of its
+// host than the one in NSM_URL, so for UDP only the port has to match.
+func (c *NsmClient) nsmFromServer(addr net.Addr) bool {
+	if addr == nil || c.nsmServerAddr == nil {
+		return false
 	}
+	from, ok := addr.(*net.UDPAddr)
+	server, isUdp := c.nsmServerAddr.(*net.UDPAddr)
+	if ok && isUdp {
+		return from.Port == server.Port
+	}
+	return addr.String() == c.nsmServerAddr.String()
+}
 
-	server, err := msg.Arguments[1].ReadString()
-	if err != nil {
-		return fmt.Errorf("%v", err)
+// nsmReplyError answers a request the OSC server goroutine can't hand to nsmReceiver.
+func (c *NsmClient) nsmReplyError(path string, code NsmErrCode, text string) {
+	msg := errorReplyOscMsg(NsmReply{path, NsmErr(code, text)})
+	// the client is announced, nsmReceiver may just not have taken the welcome yet.
+	if err := c.nsmQueue(nsmOutItem{msg: msg, always: true}); err != nil {
+		c.nsmHandlerError(fmt.Errorf("reply to %s: %w", path, err))
 	}
+}
 
-	fmt.Fprintf(os.Stderr, "NSM: Failed to register with NSM server: %v", server)
+// nsmHandlerError passes err on to nsmReceiver like nsmSenderError, without waiting for it.
+func (c *NsmClient) nsmHandlerError(err error) {
+	select {
+	case c.nsmOscErrLogChan <- err:
+	default:
+	}
+}
 
-	c.setNsmIsActive(false)
+func (c *NsmClient) nsmOscBroadcast(msg osc.Message) error { return nil }
 
-	c.nsmActiveInChan <- c.nsmServerIsActive
+// The NsmSend* calls don't block and can be called from any goroutine, the messages
+// are queued for the sender goroutine. is_dirty/is_clean and gui_is_shown/gui_is_hidden
+// collapse to the latest state, other messages fail with NsmOutQueueFullErr on overflow.
 
-	return nil
+func (c *NsmClient) NsmSendIsClean() error {
+	return c.nsmQueue(nsmOutItem{key: nsmOutKeyDirty, msg: isCleanOscMsg()})
 }
 
-func (c *NsmClient) nsmOscSessionIsLoaded(msg osc.Message) error {
-	if expected, got := 0, len(msg.Arguments); expected != got {
-		return fmt.Errorf("nsmOscSessionIsLoaded, expected %d arguments, got %d", expected, got)
-	}
-
-	if c.sessionIsLoaded == nil {
-		return fmt.Errorf("sessionIsLoaded callback not set")
-	}
+func (c *NsmClient) NsmSendIsDirty() error {
+	return c.nsmQueue(nsmOutItem{key: nsmOutKeyDirty, msg: isDirtyOscMsg()})
+}
 
-	c.nsmSessionIsLoadedInChan <- true
+// NsmSendGuiHidden and NsmSendGuiShown tell the server the state of an optional GUI.
+// Before the announce the state is kept, with API 1.1 it is sent after the welcome.
+func (c *NsmClient) NsmSendGuiHidden() error {
+	return c.nsmSendGui(false)
+}
 
-	return nil
+func (c *NsmClient) NsmSendGuiShown() error {
+	return c.nsmSendGui(true)
 }
 
-func (c *NsmClient) nsmOscShow(msg osc.Message) error {
-	if expected, got := 0, len(msg.Arguments); expected != got {
-		return fmt.Errorf("nsmOscShow, expected %d arguments, got %d", expected, got)
+func (c *NsmClient) nsmSendGui(shown bool) error {
+	c.nsmStateMu.Lock()
+	c.nsmGuiShown = &shown
+	c.nsmStateMu.Unlock()
+	if !c.NsmIsActive() {
+		return nil
 	}
+	return c.nsmQueue(nsmOutItem{key: nsmOutKeyGui, msg: guiOscMsg(shown)})
+}
 
-	if c.show == nil {
-		return fmt.Errorf("show callback not set")
+// NsmSendMessage shows text in the session manager, it needs the :message: capability.
+func (c *NsmClient) NsmSendMessage(priority NsmMsgLevel, text string) error {
+	if !c.NsmClientHasCapability(NSM_MESSAGE) {
+		return fmt.Errorf("NSM client has no %s capability", NSM_MESSAGE)
 	}
-
-	c.nsmGuiShowInChan <- true
-
-	return nil
+	return c.nsmQueue(nsmOutItem{msg: messageOscMsg(priority, text)})
 }
 
-func (c *NsmClient) nsmOscHide(msg osc.Message) error {
-	if expected, got := 0, len(msg.Arguments); expected != got {
-		return fmt.Errorf("nsmOscHide, expected %d arguments, got %d", expected, got)
+// NsmSendLabel sets the label the session manager shows next to the client's name.
+// Labels came with API 1.1, older servers fail with NsmUnsupportedErr. Before the
+// announce the label is kept and sent after the welcome.
+func (c *NsmClient) NsmSendLabel(label string) error {
+	c.nsmStateMu.Lock()
+	c.nsmLabel = &label
+	c.nsmStateMu.Unlock()
+	if !c.NsmIsActive() {
+		return nil
 	}
-	if c.hide == nil {
-		return fmt.Errorf("hide callback not set")
+	if !c.NsmApiVersionAtLeast(1, 1) {
+		return fmt.Errorf("label: %w", NsmUnsupportedErr)
 	}
-
-	c.nsmGuiHideInChan <- true
-	return nil
+	return c.nsmQueue(nsmOutItem{key: nsmOutKeyLabel, msg: labelOscMsg(label)})
 }
 
-func (c *NsmClient) nsmOscBroadcast(msg osc.Message) error { return nil }
+// nsmSendState sends the label and GUI state set before the welcome, API 1.0
+// servers don't know them. The server forgets them when the client restarts.
+func (c *NsmClient) nsmSendState() {
+	if !c.NsmApiVersionAtLeast(1, 1) {
+		return
+	}
+	c.nsmStateMu.Lock()
+	defer c.nsmStateMu.Unlock()
+	if c.nsmGuiShown != nil && c.NsmServerHasCapabilityOptionalGui() {
+		if err := c.nsmQueue(nsmOutItem{key: nsmOutKeyGui, msg: guiOscMsg(*c.nsmGuiShown)}); err != nil {
+			c.nsmReportError(err)
+		}
+	}
+	if c.nsmLabel != nil {
+		if err := c.nsmQueue(nsmOutItem{key: nsmOutKeyLabel, msg: labelOscMsg(*c.nsmLabel)}); err != nil {
+			c.nsmReportError(err)
+		}
+	}
+}
 
-func (c *NsmClient) NsmSendIsClean() {
-	c.nsmIsCleanOutChan <- true
+// NsmSendExtension queues a message of a protocol extension for the server. Like
+// messages, it isn't kept as state: queued before the welcome, it is dropped.
+func (c *NsmClient) NsmSendExtension(msg osc.Message) error {
+	return c.nsmQueue(nsmOutItem{msg: msg})
 }
 
-func (c *NsmClient) NsmSendIsDirty() {
-	c.nsmIsDirtyOutChan <- true
+// NsmSendServerSave asks the server to save the whole session, it needs :server_control:.
+func (c *NsmClient) NsmSendServerSave() error {
+	if !c.NsmServerHasCapabilityServerControl() {
+		return fmt.Errorf("NSM server has no %s capability", NSM_S_SERVER_CONTROL)
+	}
+	return c.nsmQueue(nsmOutItem{msg: serverSaveOscMsg()})
 }
 
-func (c *NsmClient) NsmSendGuiHidden() {
-	c.nsmGuiHiddenOutChan <- true
+func (c *NsmClient) nsmQueueReply(nsmReply NsmReply) {
+	msg := okReplyOscMsg(nsmReply)
+	if nsmReply.Code() != NSM_ERR_OK {
+		msg = errorReplyOscMsg(nsmReply)
+	}
+	if err := c.nsmQueue(nsmOutItem{msg: msg}); err != nil {
+		c.nsmReportError(fmt.Errorf("reply to %s: %w", nsmReply.Addr(), err))
+	}
 }
 
-func (c *NsmClient) NsmSendGuiShown() {
-	c.nsmGuiShownOutChan <- true
+func (c *NsmClient) nsmQueue(item nsmOutItem) error {
+	if c.nsmOutQueue == nil {
+		return fmt.Errorf("NSM client not initialized")
+	}
+	return c.nsmOutQueue.push(item)
 }
 
 func (c *NsmClient) NsmSetAnnounceTimeout(t time.Duration) {
 	c.nsmAnnounceTimeout = t
 }
 
-func (c *NsmClient) NsmAnnounce() error {
+// NsmSetStopTimeout sets how long NsmStop waits for the goroutines, in milliseconds.
+func (c *NsmClient) NsmSetStopTimeout(t time.Duration) {
+	c.nsmStopTimeout = t
+}
 
-	c.nsmAnnounceOutChan <- true
+func (c *NsmClient) NsmAnnounce() error {
+	name := c.nsmExecutableName
+	if c.nsmPrettyClientName == "" {
+		c.nsmPrettyClientName = name
+	}
+	if c.nsmClientCapabilities == "" {
+		return fmt.Errorf("err: no capabilities, can't send empty osc field, because of a bug in scgolang/osc")
+	}
+	msg := c.announceOscMsg(c.nsmPrettyClientName, c.nsmClientCapabilities, name, c.nsmClientPid)
+	c.nsmAnnouncePending.Store(true) // before the reply can come
+	if err := c.nsmQueue(nsmOutItem{msg: msg, always: true}); err != nil {
+		c.nsmAnnouncePending.Store(false)
+		return err
+	}
 
 	var announceTimeout time.Duration
 	if c.nsmAnnounceTimeout == 0 {
@@ -457,49 +680,58 @@ func (c *NsmClient) NsmCheckNoWait() error {
 }
 
 func (c *NsmClient) nsmReceiver(timeout <-chan time.Time) error { // nsmCheckWait
+	// the welcome comes first, the requests after it are answered only once the client is active.
+	select {
+	case result := <-c.nsmActiveInChan:
+		return c.nsmApplyAnnounce(result)
+	default:
+	}
+
 	select {
 	case args := <-c.nsmOpenInChan:
 		var (
 			outMsg string
 			err    error
 		)
+		c.nsmDisplayName = args[1]
+		c.nsmClientId = args[2]
 		outMsg, err = c.open(args[0], args[1], args[2])
 		if err != nil {
-			c.nsmReplyOutChan <- NsmReply{NsmAddrClientOpen, NsmError{NSM_ERR_GENERAL_ERROR, outMsg}}
+			c.nsmQueueReply(NsmReply{NsmAddrClientOpen, NsmError{NSM_ERR_GENERAL_ERROR, outMsg}})
 			break
 		}
-		c.nsmReplyOutChan <- NsmReply{NsmAddrClientOpen, NsmError{NSM_ERR_OK, nsmOkMsg}}
+		c.nsmQueueReply(NsmReply{NsmAddrClientOpen, NsmError{NSM_ERR_OK, nsmOkMsg}})
 	case <-c.nsmSaveInChan:
 		outMsg, err := c.save()
 		if err != nil {
-			c.nsmReplyOutChan <- NsmReply{NsmAddrClientSave, NsmError{NSM_ERR_GENERAL_ERROR, outMsg}}
+			c.nsmQueueReply(NsmReply{NsmAddrClientSave, NsmError{NSM_ERR_GENERAL_ERROR, outMsg}})
 			break
 		}
-		c.nsmReplyOutChan <- NsmReply{NsmAddrClientSave, NsmError{NSM_ERR_OK, nsmOkMsg}}
-		c.nsmIsCleanOutChan <- true
+		c.nsmQueueReply(NsmReply{NsmAddrClientSave, NsmError{NSM_ERR_OK, nsmOkMsg}})
+		if err := c.NsmSendIsClean(); err != nil {
+			c.nsmReportError(err)
+		}
 	case <-c.nsmSessionIsLoadedInChan:
 		c.sessionIsLoaded()
-	case active := <-c.nsmActiveInChan:
-		if !active {
-			return fmt.Errorf("%w", NsmServerInactiveErr)
-		} else if c.active != nil {
-			c.active(active)
-		}
-	case <-c.nsmGuiShowInChan:
-		if err := c.show(); err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
+	case result := <-c.nsmActiveInChan:
+		return c.nsmApplyAnnounce(result)
+	case show := <-c.nsmGuiInChan:
+		var gui func() error = c.hide
+		if show {
+			gui = c.show
 		}
-	case <-c.nsmGuiHideInChan:
-		if err := c.hide(); err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
+		if err := gui(); err != nil {
+			c.nsmReportError(err)
 		}
 	case <-c.nsmBroadcastChan:
 	case err := <-c.nsmSenderErrChan:
-		fmt.Fprintf(os.Stderr, "%v\n", err)
+		c.nsmReportError(err)
 	case <-c.nsmSigtermSignal:
 		return fmt.Errorf("%w", NsmGotSigtermErr)
 	case err := <-c.nsmOscErrLogChan:
-		fmt.Printf("%v\n", err)
+		c.nsmReportError(err)
+	case <-c.nsmOscCtx.Done():
+		return fmt.Errorf("%w", NsmClientStoppedErr)
 	case <-timeout:
 		return fmt.Errorf("%w", nsmReceiverTimeoutErr)
 	}
@@ -507,69 +739,116 @@ func (c *NsmClient) nsmReceiver(timeout <-chan time.Time) error { // nsmCheckWai
 	return nil
 }
 
+func (c *NsmClient) nsmApplyAnnounce(result nsmAnnounceResult) error {
+	if !result.active {
+		c.setNsmIsActive(false)
+		return fmt.Errorf("%w", NsmServerInactiveErr)
+	}
+	c.setSessionManagerName(result.serverName)
+	c.setNsmServerCapabilities(result.capabilities)
+	c.nsmServerWelcome = result.welcome
+	c.nsmServerApiMajor, c.nsmServerApiMinor = c.nsmServerApiVersion(result.serverName)
+	c.setNsmIsActive(true)
+	c.nsmSendState()
+	if c.active != nil {
+		c.active(result.active)
+	}
+	return nil
+}
+
+// nsmOffer hands v from the OSC server goroutine to nsmReceiver without waiting,
+// it returns false when the previous value wasn't taken yet.
+func nsmOffer[T any](ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+// nsmReplace is nsmOffer for states, a value nsmReceiver didn't take yet is replaced by v.
+// Only the OSC server goroutine sends on ch, so this ends.
+func nsmReplace[T any](ch chan T, v T) {
+	for !nsmOffer(ch, v) {
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// nsmReportError hands errors of the sender and the OSC server to the error callback,
+// without one they are logged.
+func (c *NsmClient) nsmReportError(err error) {
+	if c.errLog != nil {
+		c.errLog(err)
+		return
+	}
+	c.nsmLogger.Error(err.Error())
+}
+
 func (c *NsmClient) NsmSetPrettyName(name string) {
 	c.nsmPrettyClientName = name
 }
 
+// NsmSetExecutableName sets the executable name of the announce, os.Args[0] by default.
+// The server starts it to restore the client, so clients sharing a process need a
+// name it can launch, e.g. a wrapper that starts the host with the sub-client.
+func (c *NsmClient) NsmSetExecutableName(name string) {
+	c.nsmExecutableName = name
+}
+
+// NsmSetLoopbackOnly listens on the loopback interface only, so other hosts can't
+// reach the client's port. The server has to be on loopback too. It has to be called
+// before NsmInit, NSM_CLIENT_LOOPBACK sets the default.
+func (c *NsmClient) NsmSetLoopbackOnly(b bool) {
+	c.nsmLoopbackOnly = b
+}
+
+// NsmSetPid sets the pid of the announce, os.Getpid() by default.
+// The server sends signals to it, so sub-clients normally keep the process' pid.
+func (c *NsmClient) NsmSetPid(pid int) {
+	c.nsmClientPid = pid
+}
+
 // Sender goroutine
 
-// handleNsmClientInfo runs a goroutine that handles the client to server informational messages.
+// nsmSender drains the outbound queue and sends to the NSM server.
 // This is a persistent goroutine.
 func (c *NsmClient) nsmSender() error {
 	for {
 		select {
 		case <-c.nsmCloseSenderChan:
+			c.nsmFlush()
 			return nil
-		case <-c.nsmAnnounceOutChan:
-			if err := c.nsmSendAnnounce(); err != nil {
-				c.nsmSenderErrChan <- err
-			}
-		case nsmReply := <-c.nsmReplyOutChan:
-			var err error
-			if nsmReply.Code() == 0 {
-				err = c.nsmSendReply(nsmReply)
-			} else {
-				err = c.nsmSendErrorReply(nsmReply)
-			}
-			if err != nil {
-				c.nsmSenderErrChan <- err
-			}
-		case <-c.nsmGuiShownOutChan:
-			if err := c.nsmSendIsShown(); err != nil {
-				c.nsmSenderErrChan <- err
-			}
-		case <-c.nsmGuiHiddenOutChan:
-			if err := c.nsmSendIsHidden(); err != nil {
-				c.nsmSenderErrChan <- err
-			}
-		case <-c.nsmIsDirtyOutChan:
-			if err := c.nsmSendIsDirty(); err != nil {
-				c.nsmSenderErrChan <- err
-			}
-		case <-c.nsmIsCleanOutChan:
-			if err := c.nsmSendIsClean(); err != nil {
-				c.nsmSenderErrChan <- err
-			}
-			/*
-				case x := <-c.nsmProgressOutChan:
-					if err := c.nsmSendProgress(x); err != nil {
-						c.nsmSenderErrChan <- err
-					}
-				case msg := <-c.nsmMessageOutChan:
-					if err := c.nsmSendMessage(msg); err != nil {
-						c.nsmSenderErrChan <- err
-					}
-				case msg := <-c.nsmLabelOutChan:
-					if err := c.nsmSendLabel(msg); err != nil {
-						c.nsmSenderErrChan <- err
-					}
-				case msg := <-c.nsmBroadcastOutChan:
-					//c.nsmSenderErrChan <- err
-			*/
+		case <-c.nsmOutQueue.ready:
+			c.nsmFlush()
+		}
+	}
+}
+
+// nsmFlush sends everything in the outbound queue.
+func (c *NsmClient) nsmFlush() {
+	for _, item := range c.nsmOutQueue.popAll() {
+		if !item.always && !c.nsmServerIsActive.Load() {
+			continue
+		}
+		if err := c.Send(item.msg); err != nil {
+			c.nsmSenderError(fmt.Errorf("send %s: %v", item.msg.Address, err))
 		}
 	}
 }
 
+// nsmSenderError passes err on to nsmReceiver, without waiting for it.
+// When errors pile up faster than NsmCheckWait reports them, the newest are dropped.
+func (c *NsmClient) nsmSenderError(err error) {
+	select {
+	case c.nsmSenderErrChan <- err:
+	default:
+	}
+}
+
 func NsmUrlIsSet() (string, bool) {
 	url, found := os.LookupEnv(NsmEnvUrl)
 	return url, found
@@ -580,10 +859,38 @@ func (c *NsmClient) NsmInit(nsmUrl string) error {
 	if err := c.nsmInitOsc(nsmUrl); err != nil {
 		return err
 	}
-	c.nsmInitChannels()
+	return c.nsmStart()
+}
+
+// NsmInitWithConn is NsmInit on an existing connection to the server at serverAddr,
+// e.g. a FakeConn. A conn with a SetContext method gets the context NsmStop cancels.
+func (c *NsmClient) NsmInitWithConn(conn osc.Conn, serverAddr net.Addr) error {
+	c.setNsmServerAddress(serverAddr.String())
+	c.nsmServerAddr = serverAddr
+	c.nsmOscCtx, c.nsmOscCancel = context.WithCancel(context.Background())
+	if ctxConn, ok := conn.(interface{ SetContext(context.Context) }); ok {
+		ctxConn.SetContext(c.nsmOscCtx)
+	}
+	c.Conn = conn
+	return c.nsmStart()
+}
 
-	go c.nsmStartOscServer() // starts a goroutine
-	go c.nsmSender()         // starts goroutine for sending msg to the NSM server.
+func (c *NsmClient) nsmStart() error {
+	if err := c.nsmOpenCapture(); err != nil {
+		return err
+	}
+	c.nsmInitChannels()
+	c.nsmOutQueue = newNsmOutQueue(nsmOutQueueSize)
+
+	c.nsmWaitGroup.Add(2)
+	go func() { // starts a goroutine
+		defer c.nsmWaitGroup.Done()
+		c.nsmStartOscServer()
+	}()
+	go func() { // starts goroutine for sending msg to the NSM server.
+		defer c.nsmWaitGroup.Done()
+		c.nsmSender()
+	}()
 
 	return nil
 }
@@ -595,22 +902,68 @@ func (c *NsmClient) NsmCancelOscServer() error {
 }
 
 func (c *NsmClient) NsmCancelOscSender() error {
-	// stops the nsmOscSender goroutine
-	c.nsmCloseSenderChan <- true
+	// stops the nsmOscSender goroutine, after it sent the queued messages.
+	close(c.nsmCloseSenderChan)
 	return nil
 }
 
+// NsmStop shuts the client down, it can be called more than once:
+// 1. runs the shutdown callback.
+// 2. cancels the osc server, NsmCheckWait returns NsmClientStoppedErr from now on.
+// 3. stops the sender goroutine, after it flushed the outbound queue.
+// 4. waits for both goroutines, at most the stop timeout, and closes the connection.
 func (c *NsmClient) NsmStop() error {
-	// 1. cancels the osc server.
-	// 2. cancels the nsmOscSender goroutine
+	if c.nsmOscCancel == nil {
+		return nil // NsmInit failed or wasn't called
+	}
+	var err error
+	c.nsmStopOnce.Do(func() {
+		err = c.nsmStop()
+	})
+	return err
+}
+
+func (c *NsmClient) nsmStop() error {
+	c.nsmRemoveFromSignalHandlers()
+
+	var errs []error
+	if c.shutdown != nil {
+		if err := c.shutdown(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	c.NsmCancelOscServer()
 	c.NsmCancelOscSender()
-	return nil
+
+	stopTimeout := c.nsmStopTimeout
+	if stopTimeout == 0 {
+		stopTimeout = nsmDefaultStopTimeout
+	}
+	done := make(chan struct{})
+	go func() {
+		c.nsmWaitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(stopTimeout * time.Millisecond):
+		errs = append(errs, NsmStopTimeoutErr)
+	}
+
+	if err := c.Conn.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("%v", err))
+	}
+	if err := c.nsmCloseCapture(); err != nil {
+		errs = append(errs, fmt.Errorf("capture: %v", err))
+	}
+	return errors.Join(errs...)
 }
 
+// NsmHandleSigterm adds the client to NsmDefaultSignalHandler, which all clients of
+// the process share. It has to be called after NsmInit.
 func (c *NsmClient) NsmHandleSigterm() error {
-	signal.Notify(c.nsmSigtermSignal, os.Interrupt, syscall.SIGTERM)
-	return nil
+	return NsmDefaultSignalHandler().Add(c)
 }
 
 // func makeMessageMsg() {} TODO
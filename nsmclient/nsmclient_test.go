@@ -0,0 +1,591 @@
+package nsmclient
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/scgolang/osc"
+)
+
+// fakeServer answers the announce like an API 1.1 server and records every other message it gets.
+type fakeServer struct {
+	conn *net.UDPConn
+	msgs chan osc.Message
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{conn: conn, msgs: make(chan osc.Message, 1024)}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			msg, err := osc.ParseMessage(buf[:n], addr)
+			if err != nil {
+				continue
+			}
+			if msg.Address == NsmAddrServerAnnouce {
+				reply := osc.Message{Address: NsmAddrReply, Arguments: osc.Arguments{
+					osc.String(NsmAddrServerAnnouce),
+					osc.String("hi"),
+					osc.String("New Session Manager"),
+					osc.String(NSM_S_SERVER_CONTROL.String() + NSM_S_OPTIONAL_GUI.String())}}
+				conn.WriteToUDP(reply.Bytes(), addr)
+				continue
+			}
+			s.msgs <- msg
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) url() string {
+	return nsmOscUrlPrefix + s.conn.LocalAddr().String() + "/"
+}
+
+// next returns the next recorded message, or fails after a timeout.
+func (s *fakeServer) next(t *testing.T) osc.Message {
+	t.Helper()
+	select {
+	case msg := <-s.msgs:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for a message")
+	}
+	return osc.Message{}
+}
+
+// newAnnouncedClient runs setup, if any, before NsmInit, where the callbacks are set.
+func newAnnouncedClient(t *testing.T, s *fakeServer, setup func(c *NsmClient)) *NsmClient {
+	t.Helper()
+	c := NsmNewClient()
+	if setup != nil {
+		setup(c)
+	}
+	if err := c.NsmInit(s.url()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.NsmStop() })
+	if err := c.NsmSetClientCapabilities(NSM_DIRTY); err != nil {
+		t.Fatal(err)
+	}
+	c.NsmSetAnnounceTimeout(2000)
+	if err := c.NsmAnnounce(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.NsmIsActive() {
+		t.Fatal("not active after announce")
+	}
+	return c
+}
+
+func TestSendConcurrent(t *testing.T) {
+	s := newFakeServer(t)
+	c := newAnnouncedClient(t, s, nil)
+
+	// nothing calls NsmCheckWait here, the sends mustn't depend on it.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				var err error
+				if (i+j)%2 == 0 {
+					err = c.NsmSendIsDirty()
+				} else {
+					err = c.NsmSendIsClean()
+				}
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("NsmSend* blocked")
+	}
+
+	// the last state the server sees is the last one queued.
+	if err := c.NsmSendIsDirty(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg := s.next(t)
+		if msg.Address != NsmAddrClientIsDirty && msg.Address != NsmAddrClientIsClean {
+			t.Fatalf("unexpected message %s", msg.Address)
+		}
+		if msg.Address == NsmAddrClientIsDirty && c.nsmOutQueue.len() == 0 && len(s.msgs) == 0 {
+			break
+		}
+	}
+}
+
+func TestSendOrder(t *testing.T) {
+	s := newFakeServer(t)
+	c := newAnnouncedClient(t, s, nil)
+
+	if err := c.NsmSendServerSave(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NsmSendGuiShown(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{NsmAddrServerSave, NsmAddrClientGuiIsShown} {
+		if got := s.next(t).Address; got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestSendMessageAndLabel(t *testing.T) {
+	s := newFakeServer(t)
+	c := newAnnouncedClient(t, s, nil)
+
+	if err := c.NsmSendMessage(NSM_MESSAGE_PRIORITY_HIGH, "x"); err == nil {
+		t.Fatal("message sent without the :message: capability")
+	}
+	if err := c.NsmSetClientCapabilities(NSM_DIRTY, NSM_MESSAGE); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NsmSendMessage(NSM_MESSAGE_PRIORITY_HIGH, "disk full"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NsmSendLabel(""); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := s.next(t)
+	priority, _ := msg.Arguments[0].ReadInt32()
+	text, _ := msg.Arguments[1].ReadString()
+	if msg.Address != NsmAddrClientMessage || priority != int32(NSM_MESSAGE_PRIORITY_HIGH) || text != "disk full" {
+		t.Fatalf("got %s %v", msg.Address, msg.Arguments)
+	}
+	// an empty label still has its argument.
+	msg = s.next(t)
+	if msg.Address != NsmAddrClientLabel || len(msg.Arguments) != 1 {
+		t.Fatalf("got %s %v", msg.Address, msg.Arguments)
+	}
+}
+
+func TestSendExtension(t *testing.T) {
+	s := newFakeServer(t)
+	c := NsmNewClient()
+	if err := c.NsmInit(s.url()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.NsmStop() })
+	if err := c.NsmSetClientCapabilities(NSM_DIRTY); err != nil {
+		t.Fatal(err)
+	}
+	// dropped, the server doesn't know the client yet.
+	if err := c.NsmSendExtension(osc.Message{Address: "/test/early"}); err != nil {
+		t.Fatal(err)
+	}
+	c.NsmSetAnnounceTimeout(2000)
+	if err := c.NsmAnnounce(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NsmSendExtension(osc.Message{Address: "/test/late"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.next(t).Address; got != "/test/late" {
+		t.Fatalf("got %s, want /test/late", got)
+	}
+}
+
+func TestSenderErrorsDontBlock(t *testing.T) {
+	s := newFakeServer(t)
+	c := newAnnouncedClient(t, s, nil)
+
+	// sending to port 0 fails, with nobody reading the errors.
+	c.nsmServerAddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	for i := 0; i < 4*nsmSenderErrChanSize; i++ {
+		c.NsmSendServerSave()
+		time.Sleep(time.Millisecond)
+	}
+
+	var reported int
+	c.NsmSetErrorCallback(func(err error) { reported++ })
+	for i := 0; i < 2*nsmSenderErrChanSize; i++ {
+		c.NsmCheckNoWait()
+	}
+	if reported == 0 {
+		t.Fatal("no sender errors reported")
+	}
+	if reported > nsmSenderErrChanSize {
+		t.Fatalf("%d errors reported, the channel holds %d", reported, nsmSenderErrChanSize)
+	}
+}
+
+func TestStopFlushes(t *testing.T) {
+	s := newFakeServer(t)
+	c := newAnnouncedClient(t, s, nil)
+
+	var calls int
+	c.NsmSetShutdownCallback(func() error {
+		calls++
+		return c.NsmSendIsClean()
+	})
+	if err := c.NsmSendServerSave(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NsmStop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NsmStop(); err != nil {
+		t.Fatalf("second NsmStop: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("shutdown callback ran %d times", calls)
+	}
+
+	for _, want := range []string{NsmAddrServerSave, NsmAddrClientIsClean} {
+		if got := s.next(t).Address; got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+	if err := c.NsmCheckWait(10); !errors.Is(err, NsmClientStoppedErr) {
+		t.Fatalf("NsmCheckWait after stop: err = %v, want %v", err, NsmClientStoppedErr)
+	}
+}
+
+func TestStopWithPendingRequest(t *testing.T) {
+	s := newFakeServer(t)
+	c := newAnnouncedClient(t, s, func(c *NsmClient) {
+		c.NsmSetSaveCallback(func() (string, error) { return "", nil })
+	})
+
+	// the OSC server goroutine waits for NsmCheckWait, which never comes.
+	save := osc.Message{Address: NsmAddrClientSave}
+	port := c.LocalAddr().(*net.UDPAddr).Port
+	if _, err := s.conn.WriteToUDP(save.Bytes(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	c.NsmSetStopTimeout(1000)
+	if err := c.NsmStop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTraceLogger(t *testing.T) {
+	s := newFakeServer(t)
+	var buf syncBuffer
+	c := newAnnouncedClient(t, s, func(c *NsmClient) {
+		c.NsmSetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	})
+
+	if err := c.NsmSendIsDirty(); err != nil {
+		t.Fatal(err)
+	}
+	s.next(t)
+
+	log := buf.String()
+	for _, want := range []string{
+		`msg="osc out" addr=/nsm/server/announce`,
+		`msg="osc in" addr=/reply args="\"/nsm/server/announce\" \"hi\"`,
+		`msg="osc out" addr=/nsm/client/is_dirty`,
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log doesn't contain %s:\n%s", want, log)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer for loggers used by more than one goroutine.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCaptureWithFakeConn(t *testing.T) {
+	conn := NewFakeConn()
+	var capture syncBuffer
+	c := NsmNewClient()
+	c.NsmSetCapture(&capture)
+	c.NsmSetOpenCallback(func(path, displayName, clientId string) (string, error) { return "", nil })
+	if err := c.NsmInitWithConn(conn, conn.RemoteAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NsmSetClientCapabilities(NSM_DIRTY); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.AcceptAnnounce("fake", ":server_control:")
+	c.NsmSetAnnounceTimeout(2000)
+	if err := c.NsmAnnounce(); err != nil {
+		t.Fatal(err)
+	}
+	conn.Deliver(osc.Message{Address: NsmAddrClientOpen, Arguments: osc.Arguments{
+		osc.String("/tmp/notes"), osc.String("Notes"), osc.String("nABCD")}})
+	if err := c.NsmCheckWait(2000); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NsmStop(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := NsmReadCapture(strings.NewReader(capture.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range records {
+		got = append(got, r.String())
+	}
+	want := []string{
+		`out ` + NsmAddrServerAnnouce,
+		`in  /reply "/nsm/server/announce" "hi" "fake" ":server_control:"`,
+		`in  /nsm/client/open "/tmp/notes" "Notes" "nABCD"`,
+		`out /reply "/nsm/client/open" "Ok"`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("capture:\n%s", strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("record %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	// the messages survive the round trip through json.
+	announce, err := records[0].Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(announce.Arguments); n != 6 {
+		t.Fatalf("announce has %d arguments", n)
+	}
+	if pid, err := announce.Arguments[5].ReadInt32(); err != nil || int(pid) != os.Getpid() {
+		t.Fatalf("announce pid = %v (%v), want %d", pid, err, os.Getpid())
+	}
+}
+
+// newFakeConnClient announces a client on a FakeConn, the announce goes to announces.
+func newFakeConnClient(t testing.TB, setup func(c *NsmClient), announces chan<- osc.Message) *NsmClient {
+	t.Helper()
+	return newFakeConnClientOf(t, "fake", setup, announces)
+}
+
+// newFakeConnClientOf announces to a fake server that calls itself serverName.
+func newFakeConnClientOf(t testing.TB, serverName string, setup func(c *NsmClient), announces chan<- osc.Message) *NsmClient {
+	t.Helper()
+	conn := NewFakeConn()
+	c := NsmNewClient()
+	if setup != nil {
+		setup(c)
+	}
+	if err := c.NsmInitWithConn(conn, conn.RemoteAddr()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.NsmStop() })
+	if err := c.NsmSetClientCapabilities(NSM_DIRTY); err != nil {
+		t.Fatal(err)
+	}
+	announced := conn.AcceptAnnounce(serverName, "")
+	c.NsmSetAnnounceTimeout(2000)
+	if err := c.NsmAnnounce(); err != nil {
+		t.Fatal(err)
+	}
+	announces <- <-announced
+	return c
+}
+
+func TestSeveralClients(t *testing.T) {
+	announces := make(chan osc.Message, 2)
+	var clients []*NsmClient
+	for i, name := range []string{"notes-a", "notes-b"} {
+		i, name := i, name
+		clients = append(clients, newFakeConnClient(t, func(c *NsmClient) {
+			c.NsmSetPrettyName(name)
+			c.NsmSetExecutableName("nsm-" + name)
+			c.NsmSetPid(1000 + i)
+		}, announces))
+	}
+
+	for i, name := range []string{"notes-a", "notes-b"} {
+		msg := <-announces
+		pretty, _ := msg.Arguments[0].ReadString()
+		exe, _ := msg.Arguments[2].ReadString()
+		pid, _ := msg.Arguments[5].ReadInt32()
+		if pretty != name || exe != "nsm-"+name || int(pid) != 1000+i {
+			t.Errorf("announce %d: %q %q %d", i, pretty, exe, pid)
+		}
+	}
+
+	h := NsmNewSignalHandler()
+	defer h.Stop()
+	for _, c := range clients {
+		if err := h.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clients[1].NsmStop()
+
+	h.deliver(syscall.SIGTERM)
+	if err := clients[0].NsmCheckWait(1000); !errors.Is(err, NsmGotSigtermErr) {
+		t.Fatalf("client a: err = %v, want %v", err, NsmGotSigtermErr)
+	}
+	if err := clients[1].NsmCheckWait(10); !errors.Is(err, NsmClientStoppedErr) {
+		t.Fatalf("stopped client b: err = %v, want %v", err, NsmClientStoppedErr)
+	}
+}
+
+func TestCommandsOnlyFromSessionManager(t *testing.T) {
+	s := newFakeServer(t)
+	saved := make(chan bool, 2)
+	var log syncBuffer
+	c := newAnnouncedClient(t, s, func(c *NsmClient) {
+		c.NsmSetLogger(slog.New(slog.NewTextHandler(&log, nil)))
+		c.NsmSetSaveCallback(func() (string, error) { saved <- true; return "", nil })
+	})
+
+	other, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: c.LocalAddr().(*net.UDPAddr).Port}
+	save := osc.Message{Address: NsmAddrClientSave}
+	for _, conn := range []*net.UDPConn{other, s.conn} {
+		if _, err := conn.WriteToUDP(save.Bytes(), client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.NsmCheckWait(2000); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NsmCheckWait(50); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(saved); n != 1 {
+		t.Fatalf("saved %d times, want once, for the session manager", n)
+	}
+	if want := "from=" + other.LocalAddr().String(); !strings.Contains(log.String(), want) {
+		t.Fatalf("rejected save not logged with %s:\n%s", want, log.String())
+	}
+}
+
+func TestLoopbackOnly(t *testing.T) {
+	c := NsmNewClient()
+	c.NsmSetLoopbackOnly(true)
+	if err := c.NsmInit(nsmOscUrlPrefix + "192.0.2.1:7000/"); err == nil {
+		c.NsmStop()
+		t.Fatal("server on another host accepted")
+	}
+
+	s := newFakeServer(t)
+	c = newAnnouncedClient(t, s, func(c *NsmClient) { c.NsmSetLoopbackOnly(true) })
+	if ip := c.LocalAddr().(*net.UDPAddr).IP; !ip.IsLoopback() {
+		t.Fatalf("listening on %v", ip)
+	}
+	if url := c.NsmClientUrl(); !strings.Contains(url, "127.0.0.1:") {
+		t.Fatalf("client url %s", url)
+	}
+}
+
+func TestApiVersion(t *testing.T) {
+	s := newFakeServer(t)
+	c := newAnnouncedClient(t, s, func(c *NsmClient) {
+		// kept until the welcome.
+		if err := c.NsmSendLabel("take 3"); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.NsmSendGuiHidden(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if major, minor := c.NsmGetApiVersion(); major != 1 || minor != 1 {
+		t.Fatalf("api version %d.%d, want 1.1", major, minor)
+	}
+	if welcome := c.NsmGetServerWelcome(); welcome != "hi" {
+		t.Fatalf("welcome %q", welcome)
+	}
+	got := map[string]osc.Message{}
+	for i := 0; i < 2; i++ {
+		msg := s.next(t)
+		got[msg.Address] = msg
+	}
+	if _, ok := got[NsmAddrClientGuiIsHidden]; !ok {
+		t.Errorf("gui state not sent after the welcome: %v", got)
+	}
+	if label, _ := got[NsmAddrClientLabel].Arguments[0].ReadString(); label != "take 3" {
+		t.Errorf("label %q sent after the welcome", label)
+	}
+
+	// servers not known by name, like RaySession before it was added, get the label.
+	for _, name := range []string{"RaySession", "some session manager"} {
+		other := newFakeConnClientOf(t, name, nil, make(chan osc.Message, 1))
+		if major, minor := other.NsmGetApiVersion(); major != 1 || minor != 1 {
+			t.Fatalf("%s: api version %d.%d, want 1.1", name, major, minor)
+		}
+		if err := other.NsmSendLabel("take 5"); err != nil {
+			t.Fatalf("%s: label: %v", name, err)
+		}
+		sent, labelSent := other.Conn.(*FakeConn).Sent(), false
+		for !labelSent {
+			select {
+			case msg := <-sent:
+				labelSent = msg.Address == NsmAddrClientLabel
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%s: label not sent", name)
+			}
+		}
+	}
+
+	// an API 1.0 server gets neither.
+	old := newFakeConnClientOf(t, "Non Session Manager", func(c *NsmClient) { c.NsmSendLabel("take 3") }, make(chan osc.Message, 1))
+	if major, minor := old.NsmGetServerApiVersion(); major != 1 || minor != 0 {
+		t.Fatalf("server api version %d.%d, want 1.0", major, minor)
+	}
+	if err := old.NsmSendLabel("take 4"); !errors.Is(err, NsmUnsupportedErr) {
+		t.Fatalf("label to a 1.0 server: err = %v, want %v", err, NsmUnsupportedErr)
+	}
+	old.NsmStop()
+	sent := old.Conn.(*FakeConn).Sent()
+	for len(sent) > 0 {
+		if msg := <-sent; msg.Address == NsmAddrClientLabel {
+			t.Fatal("label sent to a 1.0 server")
+		}
+	}
+}
@@ -1,60 +1,85 @@
 package nsmclient
 
+// The API version the client announces. 1.1 added the label and the GUI state
+// sent right after the announce, neither is in the 1.0 nsm.h.
 const (
-	nsmApiVersionMajor = 1
-	nsmApiVersionMinor = 0
+	NsmApiVersionMajor = 1
+	NsmApiVersionMinor = 1
 )
 
+// nsmServerApiVersions are the API versions of the servers by name, the announce
+// reply doesn't tell. Other servers are taken to speak the client's version, only
+// the old Non Session Manager is known to lack 1.1.
+var nsmServerApiVersions = map[string][2]int{
+	"Non Session Manager": {1, 0},
+	"New Session Manager": {1, 1},
+	"RaySession":          {1, 1},
+	"nsm-notes nsmd":      {1, 1},
+}
+
 const (
 	nsmOkMsg                  = "Ok"
 	NsmEnvUrl                 = "NSM_URL"
+	NsmEnvDebug               = "NSM_CLIENT_DEBUG"    // traces the OSC messages, see NsmNewLogger
+	NsmEnvCapture             = "NSM_CLIENT_CAPTURE"  // capture file, see NsmSetCapture
+	NsmEnvLoopback            = "NSM_CLIENT_LOOPBACK" // listens on loopback only, see NsmSetLoopbackOnly
 	nsmOscUrlPrefix           = "osc.udp://"
 	nsmDefaultAnnounceTimeout = 100000 // milliseconds
+	nsmDefaultStopTimeout     = 2000   // milliseconds
+)
+
+const (
+	nsmOutQueueSize      = 64 // messages waiting for the sender goroutine
+	nsmSenderErrChanSize = 8
 )
 
-type nsmErr int
+type NsmErrCode int
 
 const (
-	NSM_ERR_OK                nsmErr = 0
-	NSM_ERR_GENERAL_ERROR     nsmErr = -1
-	NSM_ERR_INCOMPATIBLE_API  nsmErr = -2
-	NSM_ERR_BLACKLISTED       nsmErr = -3
-	NSM_ERR_LAUNCH_FAILED     nsmErr = -4
-	NSM_ERR_NO_SUCH_FILE      nsmErr = -5
-	NSM_ERR_NO_SESSION_OPEN   nsmErr = -6
-	NSM_ERR_UNSAVED_CHANGES   nsmErr = -7
-	NSM_ERR_NOT_NOW           nsmErr = -8
-	NSM_ERR_BAD_PROJECT       nsmErr = -9
-	NSM_ERR_CREATE_FAILED     nsmErr = -10
-	NSM_ERR_SESSION_LOCKED    nsmErr = -11
-	NSM_ERR_OPERATION_PENDING nsmErr = -12
+	NSM_ERR_OK                NsmErrCode = 0
+	NSM_ERR_GENERAL_ERROR     NsmErrCode = -1
+	NSM_ERR_INCOMPATIBLE_API  NsmErrCode = -2
+	NSM_ERR_BLACKLISTED       NsmErrCode = -3
+	NSM_ERR_LAUNCH_FAILED     NsmErrCode = -4
+	NSM_ERR_NO_SUCH_FILE      NsmErrCode = -5
+	NSM_ERR_NO_SESSION_OPEN   NsmErrCode = -6
+	NSM_ERR_UNSAVED_CHANGES   NsmErrCode = -7
+	NSM_ERR_NOT_NOW           NsmErrCode = -8
+	NSM_ERR_BAD_PROJECT       NsmErrCode = -9
+	NSM_ERR_CREATE_FAILED     NsmErrCode = -10
+	NSM_ERR_SESSION_LOCKED    NsmErrCode = -11
+	NSM_ERR_OPERATION_PENDING NsmErrCode = -12
 )
 
 // NSM Client capabilities
 const (
-	NSM_SWITCH       nsmCapability = ":switch:"
-	NSM_OPTIONAL_GUI nsmCapability = ":optional-gui:"
-	NSM_MESSAGE      nsmCapability = ":message:"
-	NSM_BROADCAST    nsmCapability = ":broadcast:"
-	NSM_DIRTY        nsmCapability = ":dirty:"
-	NSM_PROGRESS     nsmCapability = ":progress:"
+	NSM_SWITCH       NsmCapability = ":switch:"
+	NSM_OPTIONAL_GUI NsmCapability = ":optional-gui:"
+	NSM_MESSAGE      NsmCapability = ":message:"
+	NSM_BROADCAST    NsmCapability = ":broadcast:"
+	NSM_DIRTY        NsmCapability = ":dirty:"
+	NSM_PROGRESS     NsmCapability = ":progress:"
+	NSM_MONITOR      NsmCapability = ":monitor:" // RaySession sends the other clients' states, see package ray
 )
 
 // NSM server capabilities
 const (
-	NSM_S_OPTIONAL_GUI   nsmServerCapability = ":optional-gui:"
-	NSM_S_SERVER_CONTROL nsmServerCapability = ":server_control:"
-	NSM_S_BROADCAST      nsmServerCapability = ":broadcast:"
+	NSM_S_OPTIONAL_GUI   NsmServerCapability = ":optional-gui:"
+	NSM_S_SERVER_CONTROL NsmServerCapability = ":server_control:"
+	NSM_S_BROADCAST      NsmServerCapability = ":broadcast:"
 )
 
-type nsmMsgLevel int
+// NsmSessionFileName lists the clients of a session directory, one "name:executable:client_id" per line.
+const NsmSessionFileName = "session.nsm"
+
+type NsmMsgLevel int
 
 // NSM :message: priority levels.
 const (
-	NSM_MESSAGE_PRIORITY_LOWEST nsmMsgLevel = 0
-	NSM_MESSAGE_PRIORITY_LOW    nsmMsgLevel = 1
-	NSM_MESSAGE_PRIORITY_MED    nsmMsgLevel = 2
-	NSM_MESSAGE_PRIORITY_HIGH   nsmMsgLevel = 3
+	NSM_MESSAGE_PRIORITY_LOWEST NsmMsgLevel = 0
+	NSM_MESSAGE_PRIORITY_LOW    NsmMsgLevel = 1
+	NSM_MESSAGE_PRIORITY_MED    NsmMsgLevel = 2
+	NSM_MESSAGE_PRIORITY_HIGH   NsmMsgLevel = 3
 )
 
 const (
@@ -74,4 +99,51 @@ const (
 	NsmAddrClientLabel           = "/nsm/client/label"
 	NsmAddrServerBroadcast       = "/nsm/server/broadcast"
 	NsmAddrServerAnnouce         = "/nsm/server/announce"
+	NsmAddrServerSave            = "/nsm/server/save"
+)
+
+// NSM server control, for clients with :server_control: and session manager GUIs.
+const (
+	NsmAddrServerAdd       = "/nsm/server/add"
+	NsmAddrServerNew       = "/nsm/server/new"
+	NsmAddrServerOpen      = "/nsm/server/open"
+	NsmAddrServerClose     = "/nsm/server/close"
+	NsmAddrServerAbort     = "/nsm/server/abort"
+	NsmAddrServerQuit      = "/nsm/server/quit"
+	NsmAddrServerDuplicate = "/nsm/server/duplicate"
+	NsmAddrServerList      = "/nsm/server/list" // one reply per session, then one with ""
+)
+
+// NSM GUI protocol, between the server and session manager GUIs.
+const (
+	NsmAddrGuiAnnounce              = "/nsm/gui/gui_announce"
+	NsmAddrGuiSessionRoot           = "/nsm/gui/session/root"
+	NsmAddrGuiSessionName           = "/nsm/gui/session/name"
+	NsmAddrGuiServerMessage         = "/nsm/gui/server/message"
+	NsmAddrGuiClientNew             = "/nsm/gui/client/new"
+	NsmAddrGuiClientStatus          = "/nsm/gui/client/status"
+	NsmAddrGuiClientDirty           = "/nsm/gui/client/dirty"
+	NsmAddrGuiClientGuiVisible      = "/nsm/gui/client/gui_visible"
+	NsmAddrGuiClientHasOptionalGui  = "/nsm/gui/client/has_optional_gui"
+	NsmAddrGuiClientProgress        = "/nsm/gui/client/progress"
+	NsmAddrGuiClientMessage         = "/nsm/gui/client/message"
+	NsmAddrGuiClientLabel           = "/nsm/gui/client/label"
+	NsmAddrGuiClientStop            = "/nsm/gui/client/stop"
+	NsmAddrGuiClientRemove          = "/nsm/gui/client/remove"
+	NsmAddrGuiClientResume          = "/nsm/gui/client/resume"
+	NsmAddrGuiClientSave            = "/nsm/gui/client/save"
+	NsmAddrGuiClientShowOptionalGui = "/nsm/gui/client/show_optional_gui"
+	NsmAddrGuiClientHideOptionalGui = "/nsm/gui/client/hide_optional_gui"
+)
+
+// Client states in /nsm/gui/client/status.
+const (
+	NsmStatusLaunch  = "launch"
+	NsmStatusOpen    = "open"
+	NsmStatusReady   = "ready"
+	NsmStatusSave    = "save"
+	NsmStatusQuit    = "quit"
+	NsmStatusStopped = "stopped"
+	NsmStatusRemoved = "removed"
+	NsmStatusError   = "error"
 )
@@ -0,0 +1,244 @@
+package nsmclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/scgolang/osc"
+)
+
+const (
+	nsmControlQueueSize      = 256
+	nsmDefaultControlTimeout = 30 * time.Second
+)
+
+var NsmControlTimeoutErr = errors.New("Nsm server didn't reply in time")
+
+// NsmControl talks to an NSM server like a session manager GUI does: it sends the
+// server control requests and receives the /nsm/gui/ updates. It isn't a client of
+// the session, it doesn't announce.
+type NsmControl struct {
+	conn    *osc.UDPConn
+	server  net.Addr
+	ctx     context.Context
+	cancel  context.CancelFunc
+	msgs    chan osc.Message
+	mu      sync.Mutex // one request at a time
+	timeout time.Duration
+	logger  atomic.Pointer[slog.Logger] // set while the OSC server runs
+}
+
+// NsmGuiUpdate is a message of the server to its GUIs. Client is the client id of
+// the /nsm/gui/client/ messages, Args are the remaining arguments.
+type NsmGuiUpdate struct {
+	Address string
+	Client  string
+	Args    []NsmCaptureArg
+}
+
+// nsmControlDispatcher passes every message on to the NsmControl, whatever its address.
+type nsmControlDispatcher struct {
+	c *NsmControl
+}
+
+func (d nsmControlDispatcher) Dispatch(b osc.Bundle, exactMatch bool) error {
+	for _, p := range b.Packets {
+		if msg, ok := p.(osc.Message); ok {
+			d.Invoke(msg, exactMatch)
+		}
+	}
+	return nil
+}
+
+func (d nsmControlDispatcher) Invoke(msg osc.Message, exactMatch bool) error {
+	nsmTraceTo(d.c.log(), NsmCaptureIn, msg, msg.Sender)
+	select {
+	case d.c.msgs <- msg:
+	default:
+		d.c.log().Warn("dropped message, nobody reads them", "addr", msg.Address)
+	}
+	return nil
+}
+
+// nsmResolveUrl returns the UDP address of an osc.udp:// url like NSM_URL.
+func nsmResolveUrl(nsmUrl string) (*net.UDPAddr, error) {
+	nsmUrl = strings.TrimPrefix(nsmUrl, nsmOscUrlPrefix) // This osc library can't handle it?
+	nsmUrl = strings.TrimSuffix(nsmUrl, "/")
+
+	addr, err := net.ResolveUDPAddr("udp", nsmUrl)
+	if err != nil {
+		return nil, fmt.Errorf("resolve udp addr failed: %v", err)
+	}
+	return addr, nil
+}
+
+// NsmNewControl connects to the server at nsmUrl.
+func NsmNewControl(nsmUrl string) (*NsmControl, error) {
+	server, err := nsmResolveUrl(nsmUrl)
+	if err != nil {
+		return nil, err
+	}
+	laddr, err := net.ResolveUDPAddr("udp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	c := &NsmControl{
+		server:  server,
+		msgs:    make(chan osc.Message, nsmControlQueueSize),
+		timeout: nsmDefaultControlTimeout,
+	}
+	c.logger.Store(NsmNewLogger(os.Stderr))
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.conn, err = osc.ListenUDPContext(c.ctx, "udp", laddr)
+	if err != nil {
+		c.cancel()
+		return nil, fmt.Errorf("listen udp failed: %v", err)
+	}
+	go func() {
+		err := NsmServePackets(c.ctx, c.conn, nsmControlDispatcher{c}, func(from net.Addr, err error) {
+			c.log().Warn("rejected packet", "from", from, "err", err)
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			c.log().Error("osc server stopped", "err", err)
+		}
+	}()
+	return c, nil
+}
+
+// NsmSetTimeout sets how long a request waits for the server's reply.
+func (c *NsmControl) NsmSetTimeout(t time.Duration) {
+	c.timeout = t
+}
+
+// NsmSetLogger sets the logger of the traces, nil discards them.
+func (c *NsmControl) NsmSetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	c.logger.Store(logger)
+}
+
+func (c *NsmControl) log() *slog.Logger {
+	return c.logger.Load()
+}
+
+func (c *NsmControl) NsmClose() error {
+	c.cancel()
+	return c.conn.Close()
+}
+
+func (c *NsmControl) send(msg osc.Message) error {
+	nsmTraceTo(c.log(), NsmCaptureOut, msg, c.server)
+	if err := c.conn.SendTo(c.server, msg); err != nil {
+		return fmt.Errorf("send %s: %v", msg.Address, err)
+	}
+	return nil
+}
+
+// nsmAnswer waits for the next /reply or /error to path. An /error is returned
+// as *NsmError with the server's code.
+func (c *NsmControl) nsmAnswer(path string, timeout <-chan time.Time) (string, error) {
+	for {
+		select {
+		case msg := <-c.msgs:
+			if msg.Address != NsmAddrReply && msg.Address != NsmAddrError || len(msg.Arguments) < 2 {
+				continue
+			}
+			if p, _ := msg.Arguments[0].ReadString(); p != path {
+				continue
+			}
+			if msg.Address == NsmAddrReply {
+				text, _ := msg.Arguments[1].ReadString()
+				return text, nil
+			}
+			code, _ := msg.Arguments[1].ReadInt32()
+			text := ""
+			if len(msg.Arguments) > 2 {
+				text, _ = msg.Arguments[2].ReadString()
+			}
+			err := NsmErr(NsmErrCode(code), text)
+			return "", &err
+		case <-timeout:
+			return "", fmt.Errorf("%s: %w", path, NsmControlTimeoutErr)
+		}
+	}
+}
+
+// NsmRequest sends a server control request, like NsmAddrServerOpen with the
+// session name, and returns the text of the reply.
+func (c *NsmControl) NsmRequest(addr string, args ...string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg := osc.Message{Address: addr}
+	for _, arg := range args {
+		msg.Arguments = append(msg.Arguments, NsmOscString(arg))
+	}
+	if err := c.send(msg); err != nil {
+		return "", err
+	}
+	return c.nsmAnswer(addr, time.After(c.timeout))
+}
+
+// NsmList returns the session names, the server replies one per session and then "".
+func (c *NsmControl) NsmList() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.send(osc.Message{Address: NsmAddrServerList}); err != nil {
+		return nil, err
+	}
+	timeout := time.After(c.timeout)
+	var names []string
+	for {
+		name, err := c.nsmAnswer(NsmAddrServerList, timeout)
+		if err != nil {
+			return names, err
+		}
+		if name == "" {
+			return names, nil
+		}
+		names = append(names, name)
+	}
+}
+
+// NsmWatch announces as a GUI and calls update with every /nsm/gui/ message,
+// until ctx is done or update returns an error.
+func (c *NsmControl) NsmWatch(ctx context.Context, update func(NsmGuiUpdate) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.send(osc.Message{Address: NsmAddrGuiAnnounce}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-c.msgs:
+			if !strings.HasPrefix(msg.Address, "/nsm/gui/") || msg.Address == NsmAddrGuiAnnounce {
+				continue
+			}
+			if err := update(nsmGuiUpdateOf(msg)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func nsmGuiUpdateOf(msg osc.Message) NsmGuiUpdate {
+	args := NsmCaptureRecordOf(NsmCaptureIn, msg, nil).Args
+	u := NsmGuiUpdate{Address: msg.Address}
+	if strings.HasPrefix(msg.Address, "/nsm/gui/client/") && len(args) > 0 && args[0].Type == "s" {
+		u.Client, _ = args[0].Value.(string)
+		args = args[1:]
+	}
+	u.Args = args
+	return u
+}
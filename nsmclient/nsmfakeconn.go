@@ -0,0 +1,145 @@
+package nsmclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/scgolang/osc"
+)
+
+var errFakeConnClosed = errors.New("fake conn closed")
+
+const fakeConnQueueSize = 1024
+
+// fakeAddr is the address of both ends of a FakeConn.
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "fake" }
+func (a fakeAddr) String() string  { return string(a) }
+
+// FakeConn is an in-memory osc.Conn for tests and replaying captures, see NsmInitWithConn.
+// Deliver plays the server, the client's messages come out of Sent.
+type FakeConn struct {
+	mu        sync.Mutex
+	ctx       context.Context
+	incoming  chan osc.Message
+	sent      chan osc.Message
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewFakeConn() *FakeConn {
+	return &FakeConn{
+		ctx:      context.Background(),
+		incoming: make(chan osc.Message, fakeConnQueueSize),
+		sent:     make(chan osc.Message, fakeConnQueueSize),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Deliver queues msg for the client's OSC server, as if the server sent it.
+func (f *FakeConn) Deliver(msg osc.Message) error {
+	if msg.Sender == nil {
+		msg.Sender = f.RemoteAddr()
+	}
+	select {
+	case <-f.closed:
+		return errFakeConnClosed
+	case f.incoming <- msg:
+		return nil
+	}
+}
+
+// Sent returns the messages the client sends, it isn't closed.
+func (f *FakeConn) Sent() <-chan osc.Message {
+	return f.sent
+}
+
+// Serve dispatches the delivered messages one by one, like a single worker osc server.
+func (f *FakeConn) Serve(numWorkers int, dispatcher osc.Dispatcher) error {
+	if dispatcher == nil {
+		return osc.ErrNilDispatcher
+	}
+	ctx := f.Context()
+	for {
+		select {
+		case <-f.closed:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-f.incoming:
+			if err := dispatcher.Invoke(msg, false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// AcceptAnnounce plays a server called serverName with the capabilities, like
+// ":server_control:": the next message the client sends, its announce, gets the
+// welcome. Call it before NsmAnnounce, the announce comes out of the channel.
+func (f *FakeConn) AcceptAnnounce(serverName, capabilities string) <-chan osc.Message {
+	announce := make(chan osc.Message, 1)
+	go func() {
+		select {
+		case <-f.closed:
+		case msg := <-f.sent:
+			f.Deliver(osc.Message{Address: NsmAddrReply, Arguments: osc.Arguments{
+				osc.String(NsmAddrServerAnnouce), osc.String("hi"), osc.String(serverName), osc.String(capabilities)}})
+			announce <- msg
+		}
+	}()
+	return announce
+}
+
+func (f *FakeConn) Send(p osc.Packet) error {
+	return f.SendTo(f.RemoteAddr(), p)
+}
+
+// SendTo records messages, bundles aren't supported.
+func (f *FakeConn) SendTo(addr net.Addr, p osc.Packet) error {
+	msg, ok := p.(osc.Message)
+	if !ok {
+		return errors.New("fake conn: only messages can be sent")
+	}
+	select {
+	case <-f.closed:
+		return errFakeConnClosed
+	case f.sent <- msg:
+		return nil
+	default:
+		return errors.New("fake conn: sent queue full")
+	}
+}
+
+func (f *FakeConn) Context() context.Context {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ctx
+}
+
+// SetContext is called by NsmInitWithConn, canceling ctx stops Serve.
+func (f *FakeConn) SetContext(ctx context.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ctx = ctx
+}
+
+func (f *FakeConn) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *FakeConn) LocalAddr() net.Addr  { return fakeAddr("fake-client") }
+func (f *FakeConn) RemoteAddr() net.Addr { return fakeAddr("fake-server") }
+
+// The plain net.Conn methods aren't used by the client.
+
+func (f *FakeConn) Read(b []byte) (int, error)         { return 0, errors.New("fake conn: use Deliver") }
+func (f *FakeConn) Write(b []byte) (int, error)        { return 0, errors.New("fake conn: use SendTo") }
+func (f *FakeConn) SetDeadline(t time.Time) error      { return nil }
+func (f *FakeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (f *FakeConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,82 @@
+package nsmclient
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/scgolang/osc"
+)
+
+// NsmNewLogger returns the text logger the client uses by default, writing to w.
+// It logs errors and warnings, and with NSM_CLIENT_DEBUG set (not "0") it also
+// traces every OSC message the client receives and sends.
+func NsmNewLogger(w io.Writer) *slog.Logger {
+	level := slog.LevelInfo
+	if nsmDebugEnabled() {
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})).With("pkg", "nsmclient")
+}
+
+func nsmDebugEnabled() bool {
+	return nsmEnvEnabled(NsmEnvDebug)
+}
+
+// nsmEnvEnabled reports whether the switch name is set, and not to "0".
+func nsmEnvEnabled(name string) bool {
+	v := os.Getenv(name)
+	return v != "" && v != "0"
+}
+
+// NsmSetLogger replaces the default stderr logger, nil silences the client.
+// It has to be called before NsmInit. The messages are traced at slog.LevelDebug.
+func (c *NsmClient) NsmSetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	c.nsmLogger = logger
+}
+
+// nsmTrace logs an OSC message, dir is NsmCaptureIn or NsmCaptureOut.
+func (c *NsmClient) nsmTrace(dir string, msg osc.Message, peer net.Addr) {
+	nsmTraceTo(c.nsmLogger, dir, msg, peer)
+}
+
+func nsmTraceTo(logger *slog.Logger, dir string, msg osc.Message, peer net.Addr) {
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	peerAddr := ""
+	if peer != nil {
+		peerAddr = peer.String()
+	}
+	logger.Debug("osc "+dir,
+		"addr", msg.Address,
+		"args", oscArgumentsString(msg.Arguments),
+		"peer", peerAddr)
+}
+
+// nsmTraceMethod wraps method, so the messages it handles are traced and captured.
+func (c *NsmClient) nsmTraceMethod(method osc.MessageHandler) osc.Method {
+	return func(msg osc.Message) error {
+		c.nsmObserve(NsmCaptureIn, msg, msg.Sender)
+		return method.Handle(msg)
+	}
+}
+
+func oscArgumentsString(args osc.Arguments) string {
+	s := make([]string, len(args))
+	for i, arg := range args {
+		if str, ok := arg.(osc.String); ok {
+			s[i] = strconv.Quote(string(str))
+			continue
+		}
+		s[i] = arg.String()
+	}
+	return strings.Join(s, " ")
+}
@@ -2,30 +2,37 @@ package nsmclient
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"strings"
 
 	"github.com/scgolang/osc"
 )
 
 func (c *NsmClient) nsmInitOsc(nsmUrl string) error {
-	var err error
-	c.nsmClientAddr, err = net.ResolveUDPAddr("udp", ":0")
+	serverAddr, err := nsmResolveUrl(nsmUrl)
 	if err != nil {
-		return fmt.Errorf("%v", err)
+		return err
 	}
+	c.nsmServerAddr = serverAddr
 
-	nsmUrl = strings.TrimPrefix(nsmUrl, nsmOscUrlPrefix) // This osc library can't handle it?
-	nsmUrl = strings.TrimSuffix(nsmUrl, "/")
-
-	c.nsmServerAddr, err = net.ResolveUDPAddr("udp", nsmUrl)
+	listen := ":0"
+	if c.nsmLoopbackOnly {
+		if !serverAddr.IP.IsLoopback() {
+			return fmt.Errorf("listening on loopback only, but the NSM server %v isn't on loopback", serverAddr)
+		}
+		listen = net.JoinHostPort(serverAddr.IP.String(), "0")
+	}
+	c.nsmClientAddr, err = net.ResolveUDPAddr("udp", listen)
 	if err != nil {
-		return fmt.Errorf("resolve udp addr failed: %v\n", err)
+		return fmt.Errorf("%v", err)
 	}
 
+	// not connected to the server, so other programs can reach the methods added with NsmAddOscMethod.
 	c.nsmOscCtx, c.nsmOscCancel = context.WithCancel(context.Background())
-	c.Conn, err = osc.DialUDPContext(c.nsmOscCtx, "udp", c.nsmClientAddr, c.nsmServerAddr)
+	c.Conn, err = osc.ListenUDPContext(c.nsmOscCtx, "udp", c.nsmClientAddr)
 	if err != nil {
 		return fmt.Errorf("listen udp failed: %v\n", err)
 	}
@@ -33,13 +40,120 @@ func (c *NsmClient) nsmInitOsc(nsmUrl string) error {
 	return nil
 }
 
-func (c *NsmClient) nsmOscHandler() osc.PatternMatching { // TODO oscConn
-	return osc.PatternMatching{
+// Send sends to the NSM server, the conn itself isn't connected.
+func (c *NsmClient) Send(p osc.Packet) error {
+	if msg, ok := p.(osc.Message); ok {
+		c.nsmObserve(NsmCaptureOut, msg, c.nsmServerAddr)
+	}
+	return c.Conn.SendTo(c.nsmServerAddr, p)
+}
+
+// NsmSendTo sends msg to addr, e.g. a reply to the sender of a message handled by a NsmAddOscMethod method.
+func (c *NsmClient) NsmSendTo(addr net.Addr, msg osc.Message) error {
+	c.nsmObserve(NsmCaptureOut, msg, addr)
+	return c.Conn.SendTo(addr, msg)
+}
+
+// NsmAddOscMethod serves an application specific OSC address on the client's port.
+// It has to be called before NsmInit. The method runs in the OSC server goroutine,
+// an error returned from it is only logged, so report errors to the sender too.
+func (c *NsmClient) NsmAddOscMethod(addr string, method osc.Method) error {
+	if err := osc.ValidateAddress(addr); err != nil {
+		return fmt.Errorf("%s: %v", addr, err)
+	}
+	if strings.HasPrefix(addr, "/nsm/") || addr == NsmAddrReply || addr == NsmAddrError {
+		return fmt.Errorf("%s is reserved for the NSM protocol", addr)
+	}
+	if c.nsmOscMethods == nil {
+		c.nsmOscMethods = make(map[string]osc.Method)
+	}
+	c.nsmOscMethods[addr] = method
+	return nil
+}
+
+// NsmAddExtensionMethod serves an /nsm/ address a session manager adds to the
+// protocol, like RaySession's monitor messages. It has to be called before NsmInit.
+// Like the session requests, the method only gets messages of the session manager
+// after the announce.
+func (c *NsmClient) NsmAddExtensionMethod(addr string, method osc.Method) error {
+	if err := osc.ValidateAddress(addr); err != nil {
+		return fmt.Errorf("%s: %v", addr, err)
+	}
+	if !strings.HasPrefix(addr, "/nsm/") {
+		return fmt.Errorf("%s isn't an NSM address, use NsmAddOscMethod", addr)
+	}
+	if _, ok := c.nsmExtensionMethods[addr]; ok {
+		return fmt.Errorf("%s added twice", addr)
+	}
+	if _, ok := c.nsmOscHandler().(nsmDispatcher).methods[addr]; ok {
+		return fmt.Errorf("%s is handled by the client", addr)
+	}
+	if c.nsmExtensionMethods == nil {
+		c.nsmExtensionMethods = make(map[string]osc.Method)
+	}
+	c.nsmExtensionMethods[addr] = method
+	return nil
+}
+
+// NsmClientUrl is the OSC url of the client's own port, with the host name like liblo does.
+func (c *NsmClient) NsmClientUrl() string {
+	if c.Conn == nil {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(c.LocalAddr().String())
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		if host, err = os.Hostname(); err != nil {
+			host = "localhost"
+		}
+	}
+	return nsmOscUrlPrefix + net.JoinHostPort(host, port) + "/"
+}
+
+// nsmDispatcher never fails, so one bad message doesn't stop the OSC server:
+// osc.PatternMatching fails on addresses it can't match and with the errors of the methods.
+type nsmDispatcher struct {
+	c       *NsmClient
+	methods osc.PatternMatching
+}
+
+func (d nsmDispatcher) Dispatch(b osc.Bundle, exactMatch bool) error {
+	if err := d.methods.Dispatch(b, exactMatch); err != nil {
+		d.c.nsmLogger.Warn("ignored bundle", "err", err)
+	}
+	return nil
+}
+
+func (d nsmDispatcher) Invoke(msg osc.Message, exactMatch bool) error {
+	if err := d.methods.Invoke(msg, exactMatch); err != nil {
+		d.c.nsmLogger.Warn("ignored message", "addr", msg.Address, "from", msg.Sender, "err", err)
+	}
+	return nil
+}
+
+// nsmCheckArgs fails unless the arguments of msg have the typetags, e.g. "sis".
+// With more, further arguments are allowed.
+func nsmCheckArgs(msg osc.Message, typetags string, more bool) error {
+	if len(msg.Arguments) < len(typetags) || !more && len(msg.Arguments) > len(typetags) {
+		return fmt.Errorf("expected %d arguments, got %d", len(typetags), len(msg.Arguments))
+	}
+	for i := range typetags {
+		if tag := msg.Arguments[i].Typetag(); tag != typetags[i] {
+			return fmt.Errorf("argument %d: expected type %c, got %c", i, typetags[i], tag)
+		}
+	}
+	return nil
+}
+
+func (c *NsmClient) nsmOscHandler() osc.Dispatcher {
+	handler := osc.PatternMatching{
 		NsmAddrError: osc.Method(func(msg osc.Message) error {
 			return c.nsmOscError(msg)
 		}),
 		NsmAddrReply: osc.Method(func(msg osc.Message) error {
-			return c.nsmOscAnnounceReply(msg)
+			return c.nsmOscReply(msg)
 		}),
 		NsmAddrClientOpen: osc.Method(func(msg osc.Message) error {
 			return c.nsmOscOpen(msg)
@@ -58,9 +172,37 @@ func (c *NsmClient) nsmOscHandler() osc.PatternMatching { // TODO oscConn
 		}),
 		//Broadcast
 	}
+	for addr, method := range c.nsmOscMethods {
+		handler[addr] = method
+	}
+	for addr, method := range c.nsmExtensionMethods {
+		method := method
+		handler[addr] = osc.Method(func(msg osc.Message) error {
+			if err := c.nsmCheckSessionManager(msg); err != nil {
+				return err
+			}
+			return method(msg)
+		})
+	}
+	for addr, method := range handler {
+		handler[addr] = c.nsmTraceMethod(method)
+	}
+	return nsmDispatcher{c, handler}
 }
 
 // goroutine
 func (c *NsmClient) nsmStartOscServer() {
-	c.nsmOscErrLogChan <- c.Serve(1, c.nsmOscHandler()) // TODO messagehandler
+	var err error
+	if conn, ok := c.Conn.(NsmPacketConn); ok {
+		// osc.UDPConn.Serve stops at the first malformed packet.
+		err = NsmServePackets(c.nsmOscCtx, conn, c.nsmOscHandler(), func(from net.Addr, err error) {
+			c.nsmLogger.Warn("rejected packet", "from", from, "err", err)
+		})
+	} else {
+		err = c.Serve(1, c.nsmOscHandler())
+	}
+	if err == nil || errors.Is(err, context.Canceled) {
+		return // stopped by NsmStop
+	}
+	c.nsmHandlerError(err)
 }
@@ -1,98 +1,9 @@
 package nsmclient
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/scgolang/osc"
 )
 
-func (c *NsmClient) nsmSendReply(nsmReply NsmReply) error {
-	if c.nsmServerIsActive {
-		oscMsg := okReplyOscMsg(nsmReply)
-		if err := c.Send(oscMsg); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (c *NsmClient) nsmSendErrorReply(nsmReply NsmReply) error {
-	if c.nsmServerIsActive {
-		oscMsg := errorReplyOscMsg(nsmReply)
-		if err := c.Send(oscMsg); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (c *NsmClient) nsmSendIsDirty() error {
-	if c.nsmServerIsActive {
-		oscMsg := isDirtyOscMsg()
-		if err := c.Send(oscMsg); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (c *NsmClient) nsmSendIsClean() error {
-	if c.nsmServerIsActive {
-		oscMsg := isCleanOscMsg()
-		if err := c.Send(oscMsg); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (c *NsmClient) nsmSendIsShown() error {
-	if c.nsmServerIsActive {
-		oscMsg := guiShownOscMsg()
-		if err := c.Send(oscMsg); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (c *NsmClient) nsmSendIsHidden() error {
-	if c.nsmServerIsActive {
-		oscMsg := guiHiddenOscMsg()
-		if err := c.Send(oscMsg); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-/*
-func (c *NsmClient) NsmSendProgress() {
-	if c.nsmServerIsActive {
-		oscMsg := progressOscMsg()
-		if err := c.Send( oscMsg); err != nil {
-			jlog.Errorf("%v", err)
-		}
-	}
-}
-*/
-
-func (c *NsmClient) nsmSendAnnounce() error {
-	name := os.Args[0]
-	if c.nsmPrettyClientName == "" {
-		c.nsmPrettyClientName = name
-	}
-	if c.nsmClientCapabilities == "" {
-		return fmt.Errorf("err: no capabilities, can't send empty osc field, because of a bug in scgolang/osc")
-	}
-	oscMsg := c.announceOscMsg(c.nsmPrettyClientName, c.nsmClientCapabilities, name, c.nsmClientPid)
-	if err := c.Send(oscMsg); err != nil {
-		return fmt.Errorf("%v", err)
-	}
-	return nil
-}
-
 func okReplyOscMsg(nsmReply NsmReply) osc.Message {
 	return osc.Message{Address: NsmAddrReply,
 		Arguments: osc.Arguments{
@@ -129,11 +40,32 @@ func guiHiddenOscMsg() osc.Message {
 	return osc.Message{Address: addr}
 }
 
+func guiOscMsg(shown bool) osc.Message {
+	if shown {
+		return guiShownOscMsg()
+	}
+	return guiHiddenOscMsg()
+}
+
+func serverSaveOscMsg() osc.Message {
+	var addr = NsmAddrServerSave
+	return osc.Message{Address: addr}
+}
+
 func progressOscMsg(x float32) osc.Message {
 	var addr = NsmAddrClientProgress
 	return osc.Message{Address: addr, Arguments: osc.Arguments{osc.Float(x)}}
 }
 
+func messageOscMsg(priority NsmMsgLevel, text string) osc.Message {
+	return osc.Message{Address: NsmAddrClientMessage,
+		Arguments: osc.Arguments{osc.Int(int32(priority)), NsmOscString(text)}}
+}
+
+func labelOscMsg(label string) osc.Message {
+	return osc.Message{Address: NsmAddrClientLabel, Arguments: osc.Arguments{NsmOscString(label)}}
+}
+
 func (c *NsmClient) announceOscMsg(prettyName, capabilities, name string, pid int) osc.Message {
 
 	return osc.Message{Address: NsmAddrServerAnnouce,
@@ -0,0 +1,38 @@
+package nsmclient
+
+import (
+	"io"
+
+	"github.com/scgolang/osc"
+)
+
+// NsmOscString is an osc.String that can be empty: scgolang/osc leaves out the
+// null bytes of "", which other OSC implementations reject.
+type NsmOscString string
+
+func (s NsmOscString) Bytes() []byte {
+	if s == "" {
+		return make([]byte, 4)
+	}
+	return osc.String(s).Bytes()
+}
+
+// Equal compares the strings, osc.String's Equal only takes an osc.String.
+func (s NsmOscString) Equal(other osc.Argument) bool {
+	switch o := other.(type) {
+	case osc.String:
+		return string(s) == string(o)
+	case NsmOscString:
+		return s == o
+	}
+	return false
+}
+
+func (s NsmOscString) ReadInt32() (int32, error)          { return osc.String(s).ReadInt32() }
+func (s NsmOscString) ReadFloat32() (float32, error)      { return osc.String(s).ReadFloat32() }
+func (s NsmOscString) ReadBool() (bool, error)            { return osc.String(s).ReadBool() }
+func (s NsmOscString) ReadString() (string, error)        { return string(s), nil }
+func (s NsmOscString) ReadBlob() ([]byte, error)          { return osc.String(s).ReadBlob() }
+func (s NsmOscString) String() string                     { return osc.String(s).String() }
+func (s NsmOscString) Typetag() byte                      { return osc.TypetagString }
+func (s NsmOscString) WriteTo(w io.Writer) (int64, error) { return osc.String(s).WriteTo(w) }
@@ -0,0 +1,28 @@
+package nsmclient
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/scgolang/osc"
+)
+
+func TestNsmOscString(t *testing.T) {
+	if got := NsmOscString("").Bytes(); !bytes.Equal(got, []byte{0, 0, 0, 0}) {
+		t.Fatalf("empty string: %v", got)
+	}
+	for _, test := range []struct {
+		other osc.Argument
+		want  bool
+	}{
+		{NsmOscString("take"), true},
+		{NsmOscString("take 2"), false},
+		{osc.String("take"), true},
+		{osc.String(""), false},
+		{osc.Int(0), false},
+	} {
+		if got := NsmOscString("take").Equal(test.other); got != test.want {
+			t.Errorf("Equal(%#v) = %t", test.other, got)
+		}
+	}
+}
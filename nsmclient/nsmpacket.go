@@ -0,0 +1,82 @@
+package nsmclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/scgolang/osc"
+)
+
+const nsmPacketSize = 65536
+
+// NsmPacketConn is the part of osc.UDPConn NsmServePackets reads with.
+type NsmPacketConn interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	SetReadDeadline(t time.Time) error
+}
+
+// NsmServePackets reads OSC packets from conn and dispatches them, until ctx is
+// done or conn is closed. Unlike osc.UDPConn.Serve, malformed packets and dispatch
+// errors don't stop serving, they go to rejected with their source.
+func NsmServePackets(ctx context.Context, conn NsmPacketConn, d osc.Dispatcher, rejected func(from net.Addr, err error)) error {
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now()) // unblocks ReadFromUDP
+		case <-stopped:
+		}
+	}()
+
+	buf := make([]byte, nsmPacketSize)
+	for {
+		n, sender, err := conn.ReadFromUDP(buf)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+		if err := nsmHandlePacket(buf[:n], sender, d); err != nil {
+			rejected(sender, err)
+		}
+	}
+}
+
+// nsmHandlePacket parses and dispatches one packet. scgolang/osc panics on some
+// malformed packets, the panic is returned as error.
+func nsmHandlePacket(data []byte, sender net.Addr, d osc.Dispatcher) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed packet: %v", r)
+		}
+	}()
+	if len(data) == 0 {
+		return errors.New("empty packet")
+	}
+	switch data[0] {
+	case osc.BundleTag[0]:
+		bundle, err := osc.ParseBundle(data, sender)
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+		return d.Dispatch(bundle, false)
+	case osc.MessageChar:
+		msg, err := osc.ParseMessage(data, sender)
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+		if err := osc.ValidateAddress(msg.Address); err != nil {
+			return fmt.Errorf("%q: %v", msg.Address, err)
+		}
+		return d.Invoke(msg, false)
+	}
+	return errors.New("not an OSC packet")
+}
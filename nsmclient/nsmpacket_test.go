@@ -0,0 +1,247 @@
+package nsmclient
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/scgolang/osc"
+)
+
+// nsmRequests are the messages a server sends to its clients, the seeds of the fuzz targets.
+var nsmRequests = []osc.Message{
+	{Address: NsmAddrClientOpen, Arguments: osc.Arguments{osc.String("/tmp/notes"), osc.String("Notes"), osc.String("nABCD")}},
+	{Address: NsmAddrClientSave},
+	{Address: NsmAddrClientSessionIsLoaded},
+	{Address: NsmAddrClientShowOptionalGui},
+	{Address: NsmAddrClientHideOptionalGui},
+	{Address: NsmAddrReply, Arguments: osc.Arguments{osc.String(NsmAddrServerAnnouce), osc.String("hi"), osc.String("fake"), osc.String(":server_control:")}},
+	{Address: NsmAddrReply, Arguments: osc.Arguments{osc.String(NsmAddrServerSave), osc.String("Saved")}},
+	{Address: NsmAddrError, Arguments: osc.Arguments{osc.String(NsmAddrServerAnnouce), osc.Int(int32(NSM_ERR_INCOMPATIBLE_API)), osc.String("no")}},
+	{Address: NsmAddrError, Arguments: osc.Arguments{osc.String(NsmAddrServerAnnouce), osc.String("no")}},
+}
+
+// newPacketTestClient is a client on a FakeConn with all callbacks, nothing calls NsmCheckWait.
+func newPacketTestClient(t testing.TB, announced bool) *NsmClient {
+	t.Helper()
+	setup := func(c *NsmClient) {
+		c.NsmSetLogger(nil)
+		c.NsmSetOpenCallback(func(path, displayName, clientId string) (string, error) { return "", nil })
+		c.NsmSetSaveCallback(func() (string, error) { return "", nil })
+		c.NsmSetShowCallback(func() error { return nil })
+		c.NsmSetHideCallback(func() error { return nil })
+		c.NsmSetSessionIsLoadedCallback(func() error { return nil })
+	}
+	if announced {
+		c := newFakeConnClient(t, setup, make(chan osc.Message, 1))
+		c.NsmCheckNoWait() // nothing left from the announce
+		return c
+	}
+	c := NsmNewClient()
+	setup(c)
+	conn := NewFakeConn()
+	if err := c.NsmInitWithConn(conn, conn.RemoteAddr()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.NsmStop() })
+	return c
+}
+
+// handle passes data from the server through the client's handler table, like its
+// OSC server goroutine does, and fails when that panics or blocks.
+func handle(t testing.TB, c *NsmClient, d osc.Dispatcher, data []byte) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		nsmHandlePacket(data, c.nsmServerAddr, d)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("handling %q blocked", data)
+	}
+}
+
+// pending counts what the OSC server goroutine handed to nsmReceiver or the sender.
+func pending(c *NsmClient) int {
+	return len(c.nsmOpenInChan) + len(c.nsmSaveInChan) + len(c.nsmSessionIsLoadedInChan) +
+		len(c.nsmActiveInChan) + len(c.nsmGuiInChan) + len(c.nsmOscErrLogChan) + c.nsmOutQueue.len()
+}
+
+func FuzzHandlePacket(f *testing.F) {
+	for _, msg := range nsmRequests {
+		data := msg.Bytes()
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+	f.Add([]byte("#bundle\x00"))
+	f.Add([]byte("/nsm/client/open\x00\x00\x00\x00,sss\x00\x00\x00\x00"))
+	f.Add([]byte("/[\x00\x00"))
+
+	c := newPacketTestClient(f, false)
+	d := c.nsmOscHandler()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		handle(t, c, d, data)
+		// without an announce nothing gets through, not even a welcome.
+		if c.nsmAnnounced.Load() {
+			t.Fatalf("%q announced the client", data)
+		}
+		if n := pending(c); n != 0 {
+			t.Fatalf("%q left %d events", data, n)
+		}
+	})
+}
+
+// FuzzAnnouncedHandlers sends the addresses of the handler table with random
+// arguments to an announced client.
+func FuzzAnnouncedHandlers(f *testing.F) {
+	for i, msg := range nsmRequests {
+		var tags, s string
+		var n int32
+		for _, arg := range msg.Arguments {
+			tags += string(arg.Typetag())
+			if str, err := arg.ReadString(); err == nil {
+				s = str
+			}
+			if x, err := arg.ReadInt32(); err == nil {
+				n = x
+			}
+		}
+		f.Add(uint8(i), tags, s, n)
+	}
+
+	c := newPacketTestClient(f, true)
+	d := c.nsmOscHandler()
+	f.Fuzz(func(t *testing.T, addr uint8, tags, s string, n int32) {
+		msg := osc.Message{Address: nsmRequests[int(addr)%len(nsmRequests)].Address}
+		for _, tag := range tags {
+			switch tag {
+			case 's':
+				msg.Arguments = append(msg.Arguments, osc.String(s))
+			case 'i':
+				msg.Arguments = append(msg.Arguments, osc.Int(n))
+			case 'f':
+				msg.Arguments = append(msg.Arguments, osc.Float(float32(n)))
+			}
+		}
+		// twice, the second one finds the first still pending.
+		for i := 0; i < 2; i++ {
+			handle(t, c, d, msg.Bytes())
+		}
+
+		select {
+		case args := <-c.nsmOpenInChan:
+			if len(args) != 3 || nsmCheckArgs(msg, "sss", false) != nil {
+				t.Fatalf("open delivered for %v", msg.Arguments)
+			}
+		default:
+		}
+		// the announce was answered already.
+		if len(c.nsmActiveInChan) != 0 {
+			t.Fatalf("announce state changed by %s %v", msg.Address, msg.Arguments)
+		}
+		for _, ch := range []chan bool{c.nsmSaveInChan, c.nsmSessionIsLoadedInChan, c.nsmGuiInChan} {
+			select {
+			case <-ch:
+			default:
+			}
+		}
+		for len(c.nsmOscErrLogChan) > 0 {
+			<-c.nsmOscErrLogChan
+		}
+		c.nsmOutQueue.popAll()
+	})
+}
+
+func TestMalformedPacketsDontStopServer(t *testing.T) {
+	s := newFakeServer(t)
+	saved := make(chan bool, 1)
+	c := newAnnouncedClient(t, s, func(c *NsmClient) {
+		c.NsmSetLogger(nil)
+		c.NsmSetSaveCallback(func() (string, error) { saved <- true; return "", nil })
+	})
+
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: c.LocalAddr().(*net.UDPAddr).Port}
+	open := nsmRequests[0].Bytes()
+	for _, data := range [][]byte{
+		[]byte("garbage"),
+		open[:len(open)-3],
+		[]byte("/[\x00\x00,\x00\x00\x00"),
+		(osc.Message{Address: NsmAddrClientSave, Arguments: osc.Arguments{osc.Int(1)}}).Bytes(),
+		(osc.Message{Address: NsmAddrClientSave}).Bytes(),
+	} {
+		if _, err := s.conn.WriteToUDP(data, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.NsmCheckWait(2000); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-saved:
+	default:
+		t.Fatal("save after the malformed packets not handled")
+	}
+}
+
+func TestRequestWhilePending(t *testing.T) {
+	c := newPacketTestClient(t, true)
+	conn := c.Conn.(*FakeConn)
+
+	// nothing calls NsmCheckWait, the second open mustn't wait for the first.
+	for i := 0; i < 2; i++ {
+		if err := conn.Deliver(nsmRequests[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg := nextSent(t, conn)
+	code, _ := msg.Arguments[1].ReadInt32()
+	if msg.Address != NsmAddrError || NsmErrCode(code) != NSM_ERR_OPERATION_PENDING {
+		t.Fatalf("got %s %v, want %s %d", msg.Address, msg.Arguments, NsmAddrError, NSM_ERR_OPERATION_PENDING)
+	}
+
+	if err := c.NsmCheckWait(1000); err != nil {
+		t.Fatal(err)
+	}
+	if msg := nextSent(t, conn); msg.Address != NsmAddrReply {
+		t.Fatalf("got %s %v, want the reply to the first open", msg.Address, msg.Arguments)
+	}
+}
+
+func TestAnnounceRefused(t *testing.T) {
+	conn := NewFakeConn()
+	c := NsmNewClient()
+	c.NsmSetLogger(nil)
+	if err := c.NsmInitWithConn(conn, conn.RemoteAddr()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.NsmStop() })
+	if err := c.NsmSetClientCapabilities(NSM_DIRTY); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-conn.Sent()
+		conn.Deliver(osc.Message{Address: NsmAddrError, Arguments: osc.Arguments{
+			osc.String(NsmAddrServerAnnouce), osc.Int(int32(NSM_ERR_INCOMPATIBLE_API)), osc.String("incompatible")}})
+	}()
+	c.NsmSetAnnounceTimeout(2000)
+	if err := c.NsmAnnounce(); !errors.Is(err, NsmServerInactiveErr) {
+		t.Fatalf("err = %v, want %v", err, NsmServerInactiveErr)
+	}
+	if c.nsmAnnounced.Load() {
+		t.Fatal("refused client announced")
+	}
+}
+
+func nextSent(t *testing.T, conn *FakeConn) osc.Message {
+	t.Helper()
+	select {
+	case msg := <-conn.Sent():
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for a message")
+	}
+	return osc.Message{}
+}
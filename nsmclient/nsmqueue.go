@@ -0,0 +1,81 @@
+package nsmclient
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/scgolang/osc"
+)
+
+var NsmOutQueueFullErr = errors.New("NSM outbound queue full, message dropped")
+
+// Coalescing keys, a queued message with the same key is replaced by the newer one.
+const (
+	nsmOutKeyNone  = ""
+	nsmOutKeyDirty = "dirty" // is_dirty, is_clean
+	nsmOutKeyGui   = "gui"   // gui_is_shown, gui_is_hidden
+	nsmOutKeyLabel = "label"
+)
+
+type nsmOutItem struct {
+	key    string
+	msg    osc.Message
+	always bool // also sent when the server isn't active yet, the announce.
+}
+
+// nsmOutQueue is the bounded queue between the NsmSend* calls and the sender goroutine.
+// push never blocks: state messages collapse to the latest state, other messages are
+// dropped with NsmOutQueueFullErr when the queue holds size items.
+type nsmOutQueue struct {
+	mu    sync.Mutex
+	items []nsmOutItem
+	size  int
+	ready chan struct{} // signalled after a push, the sender drains the queue
+}
+
+func newNsmOutQueue(size int) *nsmOutQueue {
+	return &nsmOutQueue{
+		size:  size,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (q *nsmOutQueue) push(item nsmOutItem) error {
+	q.mu.Lock()
+	if item.key != nsmOutKeyNone {
+		// the old state goes, the new one is queued behind the messages sent before it.
+		for i := range q.items {
+			if q.items[i].key == item.key {
+				q.items = append(q.items[:i], q.items[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(q.items) >= q.size {
+		q.mu.Unlock()
+		return NsmOutQueueFullErr
+	}
+	q.items = append(q.items, item)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default: // the sender is already signalled
+	}
+	return nil
+}
+
+// popAll takes every queued item, in order.
+func (q *nsmOutQueue) popAll() []nsmOutItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items
+	q.items = nil
+	return items
+}
+
+func (q *nsmOutQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
@@ -0,0 +1,103 @@
+package nsmclient
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/scgolang/osc"
+)
+
+func queueAddresses(items []nsmOutItem) []string {
+	addrs := make([]string, len(items))
+	for i, item := range items {
+		addrs[i] = item.msg.Address
+	}
+	return addrs
+}
+
+func TestOutQueueCoalesce(t *testing.T) {
+	q := newNsmOutQueue(8)
+
+	for _, item := range []nsmOutItem{
+		{key: nsmOutKeyDirty, msg: isDirtyOscMsg()},
+		{key: nsmOutKeyGui, msg: guiShownOscMsg()},
+		{msg: serverSaveOscMsg()},
+		{key: nsmOutKeyDirty, msg: isCleanOscMsg()},
+		{key: nsmOutKeyDirty, msg: isDirtyOscMsg()},
+		{key: nsmOutKeyGui, msg: guiHiddenOscMsg()},
+	} {
+		if err := q.push(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := fmt.Sprint(queueAddresses(q.popAll()))
+	want := fmt.Sprint([]string{NsmAddrServerSave, NsmAddrClientIsDirty, NsmAddrClientGuiIsHidden})
+	if got != want {
+		t.Fatalf("queue = %s, want %s", got, want)
+	}
+	if n := q.len(); n != 0 {
+		t.Fatalf("queue holds %d items after popAll", n)
+	}
+}
+
+func TestOutQueueOverflow(t *testing.T) {
+	q := newNsmOutQueue(2)
+
+	if err := q.push(nsmOutItem{key: nsmOutKeyDirty, msg: isDirtyOscMsg()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.push(nsmOutItem{msg: serverSaveOscMsg()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.push(nsmOutItem{msg: serverSaveOscMsg()}); !errors.Is(err, NsmOutQueueFullErr) {
+		t.Fatalf("push on full queue: err = %v, want %v", err, NsmOutQueueFullErr)
+	}
+	if err := q.push(nsmOutItem{key: nsmOutKeyGui, msg: guiShownOscMsg()}); !errors.Is(err, NsmOutQueueFullErr) {
+		t.Fatalf("new state on full queue: err = %v, want %v", err, NsmOutQueueFullErr)
+	}
+	// a state that is already queued still gets updated.
+	if err := q.push(nsmOutItem{key: nsmOutKeyDirty, msg: isCleanOscMsg()}); err != nil {
+		t.Fatalf("coalescing on full queue: %v", err)
+	}
+
+	got := fmt.Sprint(queueAddresses(q.popAll()))
+	want := fmt.Sprint([]string{NsmAddrServerSave, NsmAddrClientIsClean})
+	if got != want {
+		t.Fatalf("queue = %s, want %s", got, want)
+	}
+}
+
+func TestOutQueueConcurrent(t *testing.T) {
+	const pushers, pushes = 8, 200
+	q := newNsmOutQueue(pushers * pushes)
+
+	var (
+		wg       sync.WaitGroup
+		received int
+		done     = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for received < pushers*pushes {
+			<-q.ready
+			received += len(q.popAll())
+		}
+	}()
+
+	for i := 0; i < pushers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < pushes; j++ {
+				if err := q.push(nsmOutItem{msg: osc.Message{Address: NsmAddrServerSave}}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+}
@@ -1,7 +1,7 @@
 package nsmclient
 
 type NsmError struct {
-	code nsmErr
+	code NsmErrCode
 	msg  string
 	//err  error
 }
@@ -10,7 +10,7 @@ func (e *NsmError) Error() string {
 	return e.msg
 }
 
-func (e *NsmError) Code() nsmErr {
+func (e *NsmError) Code() NsmErrCode {
 	return e.code
 }
 
@@ -18,7 +18,7 @@ func (e *NsmError) Msg() string {
 	return e.msg
 }
 
-func NsmErr(code nsmErr, msg string) NsmError {
+func NsmErr(code NsmErrCode, msg string) NsmError {
 	return NsmError{code: code, msg: msg}
 }
 
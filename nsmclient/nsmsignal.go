@@ -0,0 +1,109 @@
+package nsmclient
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// NsmSignalHandler passes SIGTERM and SIGINT on to every client added to it, so several
+// clients in one process share one signal.Notify. Each client's NsmCheckWait then
+// returns NsmGotSigtermErr.
+type NsmSignalHandler struct {
+	mu       sync.Mutex
+	clients  map[*NsmClient]struct{}
+	signals  chan os.Signal
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var (
+	nsmDefaultSignalHandler     *NsmSignalHandler
+	nsmDefaultSignalHandlerOnce sync.Once
+)
+
+// NsmNewSignalHandler starts listening for SIGTERM and SIGINT.
+func NsmNewSignalHandler() *NsmSignalHandler {
+	h := &NsmSignalHandler{
+		clients: make(map[*NsmClient]struct{}),
+		signals: make(chan os.Signal, 1),
+		stop:    make(chan struct{}),
+	}
+	signal.Notify(h.signals, os.Interrupt, syscall.SIGTERM)
+	go h.run()
+	return h
+}
+
+// NsmDefaultSignalHandler is the process wide handler NsmHandleSigterm adds clients to.
+func NsmDefaultSignalHandler() *NsmSignalHandler {
+	nsmDefaultSignalHandlerOnce.Do(func() {
+		nsmDefaultSignalHandler = NsmNewSignalHandler()
+	})
+	return nsmDefaultSignalHandler
+}
+
+// Add passes the signals on to c, which has to be initialized with NsmInit.
+// NsmStop removes the client again.
+func (h *NsmSignalHandler) Add(c *NsmClient) error {
+	if c.nsmSigtermSignal == nil {
+		return errors.New("NSM client not initialized")
+	}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	c.nsmSignalMu.Lock()
+	c.nsmSignalHandlers = append(c.nsmSignalHandlers, h)
+	c.nsmSignalMu.Unlock()
+	return nil
+}
+
+func (h *NsmSignalHandler) Remove(c *NsmClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+// Stop stops listening, the clients don't get signals through h any more.
+func (h *NsmSignalHandler) Stop() {
+	h.stopOnce.Do(func() {
+		signal.Stop(h.signals)
+		close(h.stop)
+	})
+}
+
+func (h *NsmSignalHandler) run() {
+	for {
+		select {
+		case <-h.stop:
+			return
+		case sig := <-h.signals:
+			h.deliver(sig)
+		}
+	}
+}
+
+func (h *NsmSignalHandler) deliver(sig os.Signal) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		select {
+		case c.nsmSigtermSignal <- sig:
+		default: // the client has one pending already
+		}
+	}
+}
+
+// nsmRemoveFromSignalHandlers is called by NsmStop.
+func (c *NsmClient) nsmRemoveFromSignalHandlers() {
+	c.nsmSignalMu.Lock()
+	handlers := c.nsmSignalHandlers
+	c.nsmSignalHandlers = nil
+	c.nsmSignalMu.Unlock()
+
+	for _, h := range handlers {
+		h.Remove(c)
+	}
+}
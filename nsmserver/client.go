@@ -0,0 +1,257 @@
+package nsmserver
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/scgolang/osc"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+const (
+	clientIdLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	clientIdLength  = 4
+	quitPoll        = 50 * time.Millisecond
+)
+
+// clientReply is a /reply or /error of a client to a request of the server.
+type clientReply struct {
+	code nsm.NsmErrCode
+	msg  string
+}
+
+type client struct {
+	id           string
+	name         string
+	executable   string
+	capabilities string
+	pid          int
+	addr         net.Addr // nil until announced
+	proc         *os.Process
+	status       string
+	dirty        bool
+	guiVisible   bool
+	label        string
+
+	// renewed on every launch, guarded by Server.mu
+	announced chan struct{} // closed on announce
+	opened    chan struct{} // closed after the open reply, or when the client gave up
+	exited    chan struct{} // closed when a launched process exits
+
+	waiters map[string]chan clientReply // per request path, guarded by Server.mu
+}
+
+func newClient(id, name, executable string) *client {
+	c := &client{
+		id:         id,
+		name:       name,
+		executable: executable,
+		waiters:    make(map[string]chan clientReply),
+	}
+	c.reset()
+	return c
+}
+
+func (c *client) reset() {
+	c.announced = make(chan struct{})
+	c.opened = make(chan struct{})
+	c.exited = make(chan struct{})
+	c.addr = nil
+	c.proc = nil
+	c.pid = 0
+}
+
+// closeOnce closes ch unless it is closed already. The caller holds Server.mu.
+func closeOnce(ch chan struct{}) {
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// clientName makes an announced name usable in the session file and as a path.
+func clientName(name string) string {
+	name = strings.NewReplacer("/", "_", ":", "_", "\n", " ").Replace(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "client"
+	}
+	return name
+}
+
+func (c *client) entry() sessionEntry {
+	return sessionEntry{name: c.name, executable: c.executable, id: c.id}
+}
+
+// newClientId returns an unused "nXXXX" id. The caller holds s.mu.
+func (s *Server) newClientId() string {
+	for {
+		b := make([]byte, clientIdLength)
+		rand.Read(b)
+		id := []byte("n")
+		for _, x := range b {
+			id = append(id, clientIdLetters[int(x)%len(clientIdLetters)])
+		}
+		if s.clientById(string(id)) == nil {
+			return string(id)
+		}
+	}
+}
+
+// clientById and clientByAddr expect the caller to hold s.mu.
+func (s *Server) clientById(id string) *client {
+	for _, c := range s.clients {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *Server) clientByAddr(addr net.Addr) *client {
+	if addr == nil {
+		return nil
+	}
+	for _, c := range s.clients {
+		if c.addr != nil && c.addr.String() == addr.String() {
+			return c
+		}
+	}
+	return nil
+}
+
+// clientPath is the path the client stores its data under.
+func (s *Server) clientPath(c *client) string {
+	return filepath.Join(s.session.path, c.name+"."+c.id)
+}
+
+// setStatus tells the GUIs about the client. The caller holds s.mu.
+func (s *Server) setStatus(c *client, status string) {
+	c.status = status
+	s.guiSend(nsm.NsmAddrGuiClientStatus, nsm.NsmOscString(c.id), nsm.NsmOscString(status))
+}
+
+// launch starts the executable of c with NSM_URL set. The caller holds s.mu.
+func (s *Server) launch(c *client) error {
+	cmd := exec.Command(c.executable)
+	cmd.Env = append(os.Environ(), nsm.NsmEnvUrl+"="+s.url)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// the clients mustn't get the terminal's SIGINT, the server quits them.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	s.guiSend(nsm.NsmAddrGuiClientNew, nsm.NsmOscString(c.id), nsm.NsmOscString(c.name))
+	c.reset()
+	if err := cmd.Start(); err != nil {
+		s.setStatus(c, nsm.NsmStatusStopped)
+		return nsmError(nsm.NSM_ERR_LAUNCH_FAILED, "Failed to launch %s: %v", c.executable, err)
+	}
+	c.proc = cmd.Process
+	c.pid = cmd.Process.Pid
+	exited, opened := c.exited, c.opened
+	s.setStatus(c, nsm.NsmStatusLaunch)
+
+	go func() {
+		err := cmd.Wait()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.logger.Info("client exited", "id", c.id, "executable", c.executable, "err", err)
+		close(exited)
+		closeOnce(opened)
+		if c.exited != exited {
+			return // relaunched meanwhile
+		}
+		c.proc = nil
+		c.addr = nil
+		if c.status != nsm.NsmStatusRemoved {
+			s.setStatus(c, nsm.NsmStatusStopped)
+		}
+	}()
+	return nil
+}
+
+// expect registers for the client's reply to path, before the request is sent.
+// The caller holds s.mu.
+func (c *client) expect(path string) chan clientReply {
+	ch := make(chan clientReply, 1)
+	c.waiters[path] = ch
+	return ch
+}
+
+// request sends msg to c and waits for the reply to it.
+func (s *Server) request(c *client, msg osc.Message) error {
+	s.mu.Lock()
+	addr, exited := c.addr, c.exited
+	ch := c.expect(msg.Address)
+	s.mu.Unlock()
+	if addr == nil {
+		return fmt.Errorf("%s isn't running", c.id)
+	}
+	s.send(addr, msg)
+
+	select {
+	case r := <-ch:
+		if r.code != nsm.NSM_ERR_OK {
+			return nsmError(r.code, "%s: %s", c.id, r.msg)
+		}
+		return nil
+	case <-exited:
+		return fmt.Errorf("%s exited", c.id)
+	case <-time.After(s.cfg.ReplyTimeout):
+		return fmt.Errorf("%s didn't reply to %s", c.id, msg.Address)
+	}
+}
+
+// quitClient sends SIGTERM, and SIGKILL when the client doesn't exit in time.
+// Processes the server didn't launch are only signalled by pid.
+func (s *Server) quitClient(c *client) {
+	s.mu.Lock()
+	proc, pid, exited := c.proc, c.pid, c.exited
+	if c.status != nsm.NsmStatusStopped {
+		s.setStatus(c, nsm.NsmStatusQuit)
+	}
+	s.mu.Unlock()
+
+	launched := proc != nil
+	if !launched {
+		if pid <= 1 || pid == os.Getpid() || !processAlive(pid) {
+			return
+		}
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			return
+		}
+		proc = p
+	}
+	proc.Signal(syscall.SIGTERM)
+
+	deadline := time.After(s.cfg.QuitTimeout)
+	poll := time.NewTicker(quitPoll)
+	defer poll.Stop()
+	for {
+		select {
+		case <-exited:
+			return
+		case <-poll.C:
+			if !launched && !processAlive(pid) {
+				s.mu.Lock()
+				c.addr = nil
+				s.setStatus(c, nsm.NsmStatusStopped)
+				s.mu.Unlock()
+				return
+			}
+		case <-deadline:
+			s.logger.Warn("client didn't quit, killing it", "id", c.id, "pid", pid)
+			proc.Kill()
+			return
+		}
+	}
+}
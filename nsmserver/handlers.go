@@ -0,0 +1,522 @@
+package nsmserver
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/scgolang/osc"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+const announceWelcome = "Howdy, what took you so long?"
+
+// handlers returns the OSC methods of the server. Their errors are logged here,
+// NsmServePackets would report them as rejected packets.
+func (s *Server) handlers() osc.PatternMatching {
+	methods := map[string]func(osc.Message) error{
+		nsm.NsmAddrServerAnnouce:     s.oscAnnounce,
+		nsm.NsmAddrReply:             s.oscReply,
+		nsm.NsmAddrError:             s.oscError,
+		nsm.NsmAddrClientIsDirty:     s.oscDirty,
+		nsm.NsmAddrClientIsClean:     s.oscDirty,
+		nsm.NsmAddrClientGuiIsShown:  s.oscGuiVisible,
+		nsm.NsmAddrClientGuiIsHidden: s.oscGuiVisible,
+		nsm.NsmAddrClientProgress:    s.oscProgress,
+		nsm.NsmAddrClientMessage:     s.oscMessage,
+		nsm.NsmAddrClientLabel:       s.oscLabel,
+		nsm.NsmAddrServerBroadcast:   s.oscBroadcast,
+
+		nsm.NsmAddrServerAdd:       s.oscAdd,
+		nsm.NsmAddrServerNew:       s.oscNew,
+		nsm.NsmAddrServerOpen:      s.oscOpen,
+		nsm.NsmAddrServerSave:      s.oscSave,
+		nsm.NsmAddrServerClose:     s.oscClose,
+		nsm.NsmAddrServerAbort:     s.oscAbort,
+		nsm.NsmAddrServerQuit:      s.oscQuit,
+		nsm.NsmAddrServerDuplicate: s.oscDuplicate,
+		nsm.NsmAddrServerList:      s.oscList,
+
+		nsm.NsmAddrGuiAnnounce:              s.oscGuiAnnounce,
+		nsm.NsmAddrGuiClientStop:            s.oscGuiClient,
+		nsm.NsmAddrGuiClientRemove:          s.oscGuiClient,
+		nsm.NsmAddrGuiClientResume:          s.oscGuiClient,
+		nsm.NsmAddrGuiClientSave:            s.oscGuiClient,
+		nsm.NsmAddrGuiClientShowOptionalGui: s.oscGuiClient,
+		nsm.NsmAddrGuiClientHideOptionalGui: s.oscGuiClient,
+	}
+	handler := osc.PatternMatching{}
+	for addr, method := range methods {
+		method := method
+		handler[addr] = osc.Method(func(msg osc.Message) error {
+			s.logger.Debug("osc in", "addr", msg.Address, "from", msg.Sender)
+			if err := method(msg); err != nil {
+				s.logger.Warn(msg.Address, "from", msg.Sender, "err", err)
+			}
+			return nil
+		})
+	}
+	return handler
+}
+
+// checkArgs checks the argument types of msg against typetags, like "ssi".
+func checkArgs(msg osc.Message, typetags string) error {
+	if len(msg.Arguments) < len(typetags) {
+		return fmt.Errorf("%s: %d arguments, want %d", msg.Address, len(msg.Arguments), len(typetags))
+	}
+	for i := range typetags {
+		if msg.Arguments[i].Typetag() != typetags[i] {
+			return fmt.Errorf("%s: argument %d is %c, want %c", msg.Address, i, msg.Arguments[i].Typetag(), typetags[i])
+		}
+	}
+	return nil
+}
+
+func (s *Server) oscAnnounce(msg osc.Message) error {
+	path := nsm.NsmAddrServerAnnouce
+	if err := checkArgs(msg, "sssiii"); err != nil {
+		s.replyError(msg.Sender, path, nsmError(nsm.NSM_ERR_GENERAL_ERROR, "%v", err))
+		return err
+	}
+	name, _ := msg.Arguments[0].ReadString()
+	capabilities, _ := msg.Arguments[1].ReadString()
+	executable, _ := msg.Arguments[2].ReadString()
+	major, _ := msg.Arguments[3].ReadInt32()
+	pid, _ := msg.Arguments[5].ReadInt32()
+
+	if major != nsm.NsmApiVersionMajor {
+		err := nsmError(nsm.NSM_ERR_INCOMPATIBLE_API, "Sorry, but your API version is incompatible")
+		s.replyError(msg.Sender, path, err)
+		return err
+	}
+
+	s.mu.Lock()
+	if s.session == nil {
+		s.mu.Unlock()
+		err := nsmError(nsm.NSM_ERR_NO_SESSION_OPEN, "Sorry, but there's no session open for this application to join.")
+		s.replyError(msg.Sender, path, err)
+		return err
+	}
+	c := s.announcingClient(int(pid), executable, msg.Sender)
+	if c == nil {
+		c = newClient(s.newClientId(), clientName(name), executable)
+		c.pid = int(pid)
+		s.clients = append(s.clients, c)
+		s.guiSend(nsm.NsmAddrGuiClientNew, nsm.NsmOscString(c.id), nsm.NsmOscString(c.name))
+	}
+	c.name = clientName(name)
+	c.capabilities = capabilities
+	c.addr = msg.Sender
+	if c.pid == 0 {
+		c.pid = int(pid)
+	}
+	closeOnce(c.announced)
+	s.setStatus(c, nsm.NsmStatusOpen)
+	if hasCapability(capabilities, nsm.NSM_OPTIONAL_GUI) {
+		s.guiSend(nsm.NsmAddrGuiClientHasOptionalGui, nsm.NsmOscString(c.id))
+	}
+	s.mu.Unlock()
+
+	s.logger.Info("client announced", "id", c.id, "name", name, "capabilities", capabilities, "pid", pid)
+	s.send(msg.Sender, osc.Message{Address: nsm.NsmAddrReply, Arguments: osc.Arguments{
+		nsm.NsmOscString(path),
+		nsm.NsmOscString(announceWelcome),
+		nsm.NsmOscString(ServerName),
+		nsm.NsmOscString(serverCapabilities),
+	}})
+	go s.openClient(c)
+	return nil
+}
+
+// announcingClient finds the client an announce comes from: the launched process
+// with its pid, a client that re-announces, or one launched through a wrapper.
+// The caller holds s.mu.
+func (s *Server) announcingClient(pid int, executable string, sender net.Addr) *client {
+	if c := s.clientByAddr(sender); c != nil {
+		return c
+	}
+	for _, c := range s.clients {
+		if c.addr == nil && c.proc != nil && c.pid == pid {
+			return c
+		}
+	}
+	for _, c := range s.clients {
+		if c.addr == nil && c.proc != nil && c.executable == executable {
+			return c
+		}
+	}
+	return nil
+}
+
+// openClient tells an announced client to open its part of the session.
+func (s *Server) openClient(c *client) {
+	s.mu.Lock()
+	if s.session == nil {
+		s.mu.Unlock()
+		return
+	}
+	opened := c.opened
+	msg := osc.Message{Address: nsm.NsmAddrClientOpen, Arguments: osc.Arguments{
+		nsm.NsmOscString(s.clientPath(c)),
+		nsm.NsmOscString(s.session.name),
+		nsm.NsmOscString(c.id),
+	}}
+	s.mu.Unlock()
+
+	err := s.request(c, msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.logger.Warn("client failed to open", "id", c.id, "err", err)
+		s.setStatus(c, nsm.NsmStatusError)
+	} else {
+		s.setStatus(c, nsm.NsmStatusReady)
+	}
+	closeOnce(opened)
+}
+
+// senderClient returns the client msg comes from, or an error for unknown senders.
+func (s *Server) senderClient(msg osc.Message) (*client, error) {
+	c := s.clientByAddr(msg.Sender)
+	if c == nil {
+		return nil, fmt.Errorf("%s from unknown client %v", msg.Address, msg.Sender)
+	}
+	return c, nil
+}
+
+func (s *Server) oscReply(msg osc.Message) error {
+	if err := checkArgs(msg, "s"); err != nil {
+		return err
+	}
+	path, _ := msg.Arguments[0].ReadString()
+	text := ""
+	if len(msg.Arguments) > 1 {
+		text, _ = msg.Arguments[1].ReadString()
+	}
+	return s.clientReplied(msg, path, clientReply{code: nsm.NSM_ERR_OK, msg: text})
+}
+
+func (s *Server) oscError(msg osc.Message) error {
+	if err := checkArgs(msg, "sis"); err != nil {
+		return err
+	}
+	path, _ := msg.Arguments[0].ReadString()
+	code, _ := msg.Arguments[1].ReadInt32()
+	text, _ := msg.Arguments[2].ReadString()
+	if code == int32(nsm.NSM_ERR_OK) {
+		code = int32(nsm.NSM_ERR_GENERAL_ERROR)
+	}
+	return s.clientReplied(msg, path, clientReply{code: nsm.NsmErrCode(code), msg: text})
+}
+
+func (s *Server) clientReplied(msg osc.Message, path string, r clientReply) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.senderClient(msg)
+	if err != nil {
+		return err
+	}
+	ch, ok := c.waiters[path]
+	if !ok {
+		return fmt.Errorf("unexpected %s to %s from %s", msg.Address, path, c.id)
+	}
+	delete(c.waiters, path)
+	ch <- r
+	if r.code != nsm.NSM_ERR_OK {
+		s.guiSend(nsm.NsmAddrGuiClientMessage, nsm.NsmOscString(c.id),
+			osc.Int(int32(nsm.NSM_MESSAGE_PRIORITY_HIGH)), nsm.NsmOscString(r.msg))
+	}
+	return nil
+}
+
+func (s *Server) oscDirty(msg osc.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.senderClient(msg)
+	if err != nil {
+		return err
+	}
+	c.dirty = msg.Address == nsm.NsmAddrClientIsDirty
+	dirty := int32(0)
+	if c.dirty {
+		dirty = 1
+	}
+	s.guiSend(nsm.NsmAddrGuiClientDirty, nsm.NsmOscString(c.id), osc.Int(dirty))
+	return nil
+}
+
+func (s *Server) oscGuiVisible(msg osc.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.senderClient(msg)
+	if err != nil {
+		return err
+	}
+	c.guiVisible = msg.Address == nsm.NsmAddrClientGuiIsShown
+	visible := int32(0)
+	if c.guiVisible {
+		visible = 1
+	}
+	s.guiSend(nsm.NsmAddrGuiClientGuiVisible, nsm.NsmOscString(c.id), osc.Int(visible))
+	return nil
+}
+
+func (s *Server) oscProgress(msg osc.Message) error {
+	if err := checkArgs(msg, "f"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.senderClient(msg)
+	if err != nil {
+		return err
+	}
+	s.guiSend(nsm.NsmAddrGuiClientProgress, nsm.NsmOscString(c.id), msg.Arguments[0])
+	return nil
+}
+
+func (s *Server) oscMessage(msg osc.Message) error {
+	if err := checkArgs(msg, "is"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.senderClient(msg)
+	if err != nil {
+		return err
+	}
+	text, _ := msg.Arguments[1].ReadString()
+	s.guiSend(nsm.NsmAddrGuiClientMessage, nsm.NsmOscString(c.id), msg.Arguments[0], nsm.NsmOscString(text))
+	return nil
+}
+
+func (s *Server) oscLabel(msg osc.Message) error {
+	if err := checkArgs(msg, "s"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.senderClient(msg)
+	if err != nil {
+		return err
+	}
+	c.label, _ = msg.Arguments[0].ReadString()
+	s.guiSend(nsm.NsmAddrGuiClientLabel, nsm.NsmOscString(c.id), nsm.NsmOscString(c.label))
+	return nil
+}
+
+// oscBroadcast relays the message in the arguments to the other clients with :broadcast:.
+func (s *Server) oscBroadcast(msg osc.Message) error {
+	if err := checkArgs(msg, "s"); err != nil {
+		return err
+	}
+	path, _ := msg.Arguments[0].ReadString()
+	if err := osc.ValidateAddress(path); err != nil {
+		return err
+	}
+	relay := osc.Message{Address: path}
+	for _, arg := range msg.Arguments[1:] {
+		// as parsed, an empty string would be relayed without its null bytes.
+		if str, ok := arg.(osc.String); ok {
+			arg = nsm.NsmOscString(str)
+		}
+		relay.Arguments = append(relay.Arguments, arg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		if c.addr == nil || c.addr.String() == msg.Sender.String() ||
+			!hasCapability(c.capabilities, nsm.NSM_BROADCAST) {
+			continue
+		}
+		s.send(c.addr, relay)
+	}
+	return nil
+}
+
+// stringArg returns the first argument of a server control request, replying the
+// error for a missing one.
+func (s *Server) stringArg(msg osc.Message) (string, error) {
+	if err := checkArgs(msg, "s"); err != nil {
+		s.replyError(msg.Sender, msg.Address, nsmError(nsm.NSM_ERR_GENERAL_ERROR, "%v", err))
+		return "", err
+	}
+	arg, _ := msg.Arguments[0].ReadString()
+	return arg, nil
+}
+
+func (s *Server) oscAdd(msg osc.Message) error {
+	executable, err := s.stringArg(msg)
+	if err != nil {
+		return err
+	}
+	s.operation(msg.Sender, msg.Address, func() (string, error) {
+		return "Launched.", s.addClient(executable)
+	})
+	return nil
+}
+
+func (s *Server) oscNew(msg osc.Message) error {
+	name, err := s.stringArg(msg)
+	if err != nil {
+		return err
+	}
+	s.operation(msg.Sender, msg.Address, func() (string, error) {
+		return "Created.", s.newSession(name)
+	})
+	return nil
+}
+
+func (s *Server) oscOpen(msg osc.Message) error {
+	name, err := s.stringArg(msg)
+	if err != nil {
+		return err
+	}
+	s.operation(msg.Sender, msg.Address, func() (string, error) {
+		return "Loaded.", s.openSession(name)
+	})
+	return nil
+}
+
+func (s *Server) oscSave(msg osc.Message) error {
+	s.operation(msg.Sender, msg.Address, func() (string, error) {
+		return "Saved.", s.saveSession()
+	})
+	return nil
+}
+
+func (s *Server) oscClose(msg osc.Message) error {
+	s.operation(msg.Sender, msg.Address, func() (string, error) {
+		if err := s.saveSession(); err != nil {
+			return "", err
+		}
+		return "Closed.", s.closeSession()
+	})
+	return nil
+}
+
+func (s *Server) oscAbort(msg osc.Message) error {
+	s.operation(msg.Sender, msg.Address, func() (string, error) {
+		return "Aborted.", s.closeSession()
+	})
+	return nil
+}
+
+func (s *Server) oscQuit(msg osc.Message) error {
+	s.operation(msg.Sender, msg.Address, func() (string, error) {
+		s.closeSession()
+		s.quitOnce.Do(func() { close(s.done) })
+		return "Quitting.", nil
+	})
+	return nil
+}
+
+func (s *Server) oscDuplicate(msg osc.Message) error {
+	name, err := s.stringArg(msg)
+	if err != nil {
+		return err
+	}
+	s.operation(msg.Sender, msg.Address, func() (string, error) {
+		return "Loaded.", s.duplicateSession(name)
+	})
+	return nil
+}
+
+// oscList replies every session name, then an empty one.
+func (s *Server) oscList(msg osc.Message) error {
+	names, err := s.listSessions()
+	if err != nil {
+		s.replyError(msg.Sender, msg.Address, err)
+		return err
+	}
+	for _, name := range names {
+		s.reply(msg.Sender, msg.Address, name)
+	}
+	s.reply(msg.Sender, msg.Address, "")
+	return nil
+}
+
+// oscGuiAnnounce registers a session manager GUI and tells it about the session.
+func (s *Server) oscGuiAnnounce(msg osc.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known := false
+	for _, gui := range s.guis {
+		known = known || gui.String() == msg.Sender.String()
+	}
+	if !known {
+		s.guis = append(s.guis, msg.Sender)
+	}
+	s.send(msg.Sender, osc.Message{Address: nsm.NsmAddrGuiAnnounce, Arguments: osc.Arguments{nsm.NsmOscString("hi")}})
+	s.send(msg.Sender, osc.Message{Address: nsm.NsmAddrGuiSessionRoot, Arguments: osc.Arguments{nsm.NsmOscString(s.cfg.SessionRoot)}})
+	if s.session == nil {
+		return nil
+	}
+	s.send(msg.Sender, osc.Message{Address: nsm.NsmAddrGuiSessionName, Arguments: osc.Arguments{
+		nsm.NsmOscString(s.session.name), nsm.NsmOscString(s.session.name)}})
+	for _, c := range s.clients {
+		id := nsm.NsmOscString(c.id)
+		s.send(msg.Sender, osc.Message{Address: nsm.NsmAddrGuiClientNew, Arguments: osc.Arguments{id, nsm.NsmOscString(c.name)}})
+		s.send(msg.Sender, osc.Message{Address: nsm.NsmAddrGuiClientStatus, Arguments: osc.Arguments{id, nsm.NsmOscString(c.status)}})
+		if hasCapability(c.capabilities, nsm.NSM_OPTIONAL_GUI) {
+			s.send(msg.Sender, osc.Message{Address: nsm.NsmAddrGuiClientHasOptionalGui, Arguments: osc.Arguments{id}})
+		}
+		if c.label != "" {
+			s.send(msg.Sender, osc.Message{Address: nsm.NsmAddrGuiClientLabel, Arguments: osc.Arguments{id, nsm.NsmOscString(c.label)}})
+		}
+	}
+	return nil
+}
+
+// oscGuiClient handles the per client requests of the GUIs, the first argument is the client id.
+func (s *Server) oscGuiClient(msg osc.Message) error {
+	if err := checkArgs(msg, "s"); err != nil {
+		return err
+	}
+	id, _ := msg.Arguments[0].ReadString()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.clientById(id)
+	if c == nil {
+		return fmt.Errorf("no client %q", id)
+	}
+
+	switch msg.Address {
+	case nsm.NsmAddrGuiClientStop:
+		go s.quitClient(c)
+	case nsm.NsmAddrGuiClientRemove:
+		if c.addr != nil || c.proc != nil {
+			return fmt.Errorf("can't remove running client %s", id)
+		}
+		s.removeClient(c)
+	case nsm.NsmAddrGuiClientResume:
+		if c.addr != nil || c.proc != nil {
+			return nil
+		}
+		return s.launch(c)
+	case nsm.NsmAddrGuiClientSave:
+		go func() {
+			if err := s.request(c, osc.Message{Address: nsm.NsmAddrClientSave}); err != nil {
+				s.logger.Warn("client save failed", "id", c.id, "err", err)
+			}
+		}()
+	case nsm.NsmAddrGuiClientShowOptionalGui:
+		s.send(c.addr, osc.Message{Address: nsm.NsmAddrClientShowOptionalGui})
+	case nsm.NsmAddrGuiClientHideOptionalGui:
+		s.send(c.addr, osc.Message{Address: nsm.NsmAddrClientHideOptionalGui})
+	}
+	return nil
+}
+
+// removeClient drops a stopped client from the session. The caller holds s.mu.
+func (s *Server) removeClient(c *client) {
+	for i, other := range s.clients {
+		if other == c {
+			s.clients = append(s.clients[:i], s.clients[i+1:]...)
+			break
+		}
+	}
+	s.setStatus(c, nsm.NsmStatusRemoved)
+}
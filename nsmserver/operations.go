@@ -0,0 +1,302 @@
+package nsmserver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/scgolang/osc"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+// The session operations run one at a time, with s.op held.
+
+func (s *Server) noSession() error {
+	return nsmError(nsm.NSM_ERR_NO_SESSION_OPEN, "No session open")
+}
+
+// setSession makes dir the open session, the caller holds s.mu.
+func (s *Server) setSession(name, dir string) {
+	s.session = &session{name: name, path: dir}
+	s.guiSend(nsm.NsmAddrGuiSessionName, nsm.NsmOscString(name), nsm.NsmOscString(name))
+}
+
+// switchAway saves and closes the open session, before another one is opened.
+func (s *Server) switchAway() error {
+	s.mu.Lock()
+	open := s.session != nil
+	s.mu.Unlock()
+	if !open {
+		return nil
+	}
+	if err := s.saveSession(); err != nil {
+		return err
+	}
+	return s.closeSession()
+}
+
+func (s *Server) newSession(name string) error {
+	dir, err := s.sessionPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, nsm.NsmSessionFileName)); err == nil {
+		return nsmError(nsm.NSM_ERR_CREATE_FAILED, "Session %q already exists", name)
+	}
+	if err := s.switchAway(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nsmError(nsm.NSM_ERR_CREATE_FAILED, "Could not create the session directory: %v", err)
+	}
+	if err := lockSession(dir); err != nil {
+		return err
+	}
+	if err := writeSessionFile(dir, nil); err != nil {
+		unlockSession(dir)
+		return nsmError(nsm.NSM_ERR_CREATE_FAILED, "%v", err)
+	}
+
+	s.mu.Lock()
+	s.setSession(name, dir)
+	s.mu.Unlock()
+	s.guiMessage("Created session %s", name)
+	return nil
+}
+
+func (s *Server) openSession(name string) error {
+	dir, err := s.sessionPath(name)
+	if err != nil {
+		return err
+	}
+	entries, err := readSessionFile(dir)
+	if err != nil {
+		return err
+	}
+	if err := s.switchAway(); err != nil {
+		return err
+	}
+	if err := lockSession(dir); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.setSession(name, dir)
+	var clients []*client
+	for _, e := range entries {
+		c := newClient(e.id, e.name, e.executable)
+		s.clients = append(s.clients, c)
+		if err := s.launch(c); err != nil {
+			s.logger.Warn("launch failed", "id", c.id, "err", err)
+			continue
+		}
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	s.waitOpened(clients)
+	s.sessionIsLoaded()
+	s.guiMessage("Loaded session %s", name)
+	return nil
+}
+
+// waitOpened waits for the launched clients to announce and open the session.
+func (s *Server) waitOpened(clients []*client) {
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		s.mu.Lock()
+		announced, opened, exited := c.announced, c.opened, c.exited
+		s.mu.Unlock()
+
+		wg.Add(1)
+		go func(c *client) {
+			defer wg.Done()
+			select {
+			case <-announced:
+			case <-exited:
+				return
+			case <-time.After(s.cfg.AnnounceTimeout):
+				s.guiMessage("%s (%s) didn't announce", c.id, c.executable)
+				return
+			}
+			select {
+			case <-opened:
+			case <-time.After(s.cfg.ReplyTimeout):
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (s *Server) sessionIsLoaded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		s.send(c.addr, osc.Message{Address: nsm.NsmAddrClientSessionIsLoaded})
+	}
+}
+
+// saveSession asks the running clients to save and writes the session file.
+func (s *Server) saveSession() error {
+	s.mu.Lock()
+	if s.session == nil {
+		s.mu.Unlock()
+		return s.noSession()
+	}
+	dir := s.session.path
+	clients := append([]*client(nil), s.clients...)
+	for _, c := range clients {
+		if c.addr != nil {
+			s.setStatus(c, nsm.NsmStatusSave)
+		}
+	}
+	s.mu.Unlock()
+
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		s.mu.Lock()
+		running := c.addr != nil
+		s.mu.Unlock()
+		if !running {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, c *client) {
+			defer wg.Done()
+			errs[i] = s.request(c, osc.Message{Address: nsm.NsmAddrClientSave})
+		}(i, c)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	entries := make([]sessionEntry, 0, len(clients))
+	for i, c := range clients {
+		entries = append(entries, c.entry())
+		if errs[i] != nil {
+			s.logger.Warn("client save failed", "id", c.id, "err", errs[i])
+			s.setStatus(c, nsm.NsmStatusError)
+		} else if c.addr != nil {
+			c.dirty = false
+			s.setStatus(c, nsm.NsmStatusReady)
+		}
+	}
+	s.mu.Unlock()
+
+	if err := writeSessionFile(dir, entries); err != nil {
+		return nsmError(nsm.NSM_ERR_GENERAL_ERROR, "Could not write the session file: %v", err)
+	}
+	for _, err := range errs {
+		if err != nil {
+			s.guiMessage("Some clients failed to save")
+			return err
+		}
+	}
+	s.guiMessage("Saved session")
+	return nil
+}
+
+// closeSession quits the clients of the open session, without saving.
+func (s *Server) closeSession() error {
+	s.mu.Lock()
+	if s.session == nil {
+		s.mu.Unlock()
+		return s.noSession()
+	}
+	clients := append([]*client(nil), s.clients...)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *client) {
+			defer wg.Done()
+			s.quitClient(c)
+		}(c)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	unlockSession(s.session.path)
+	for _, c := range s.clients {
+		s.setStatus(c, nsm.NsmStatusRemoved)
+	}
+	s.clients = nil
+	s.session = nil
+	s.guiSend(nsm.NsmAddrGuiSessionName, nsm.NsmOscString(""), nsm.NsmOscString(""))
+	s.mu.Unlock()
+	return nil
+}
+
+// duplicateSession saves the open session, copies it to name and opens the copy.
+func (s *Server) duplicateSession(name string) error {
+	s.mu.Lock()
+	open := s.session != nil
+	var src string
+	if open {
+		src = s.session.path
+	}
+	s.mu.Unlock()
+	if !open {
+		return s.noSession()
+	}
+	dst, err := s.sessionPath(name)
+	if err != nil {
+		return err
+	}
+	// the copy would walk into itself.
+	if isWithin(dst, src) || isWithin(src, dst) {
+		return nsmError(nsm.NSM_ERR_CREATE_FAILED, "Session %q can't be inside the open session or contain it", name)
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nsmError(nsm.NSM_ERR_CREATE_FAILED, "Session %q already exists", name)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nsmError(nsm.NSM_ERR_CREATE_FAILED, "%v", err)
+	}
+	if err := s.saveSession(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return nsmError(nsm.NSM_ERR_CREATE_FAILED, "%v", err)
+	}
+	if err := copyDir(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return nsmError(nsm.NSM_ERR_CREATE_FAILED, "Could not copy the session: %v", err)
+	}
+	// saved already, openSession would save it again.
+	if err := s.closeSession(); err != nil {
+		return err
+	}
+	return s.openSession(name)
+}
+
+// addClient launches executable as a new client of the open session.
+func (s *Server) addClient(executable string) error {
+	if executable == "" || filepath.Base(executable) != executable && !filepath.IsAbs(executable) {
+		return nsmError(nsm.NSM_ERR_LAUNCH_FAILED, "Invalid executable %q", executable)
+	}
+	s.mu.Lock()
+	if s.session == nil {
+		s.mu.Unlock()
+		return s.noSession()
+	}
+	c := newClient(s.newClientId(), filepath.Base(executable), executable)
+	s.clients = append(s.clients, c)
+	err := s.launch(c)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.waitOpened([]*client{c})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.addr == nil {
+		return nsmError(nsm.NSM_ERR_LAUNCH_FAILED, "%s didn't announce", executable)
+	}
+	s.send(c.addr, osc.Message{Address: nsm.NsmAddrClientSessionIsLoaded})
+	return nil
+}
@@ -0,0 +1,221 @@
+// Package nsmserver is an NSM session daemon, compatible with nsmd. It manages the
+// sessions in a session root directory, launches their clients with NSM_URL set
+// and speaks the server half of the protocol nsmclient implements.
+package nsmserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scgolang/osc"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+const (
+	ServerName         = "nsm-notes nsmd" // nsmclient knows it for an API 1.1 server
+	serverCapabilities = ":server_control:broadcast:optional-gui:"
+)
+
+const (
+	defaultAnnounceTimeout = 10 * time.Second
+	defaultReplyTimeout    = 30 * time.Second
+	defaultQuitTimeout     = 10 * time.Second
+)
+
+type Config struct {
+	SessionRoot     string
+	Listen          string        // udp address, ":0" picks a free port
+	Host            string        // host in the NSM_URL, os.Hostname() by default
+	AnnounceTimeout time.Duration // for launched clients to announce
+	ReplyTimeout    time.Duration // for clients to answer open and save
+	QuitTimeout     time.Duration // for clients to exit after SIGTERM, then they are killed
+	Logger          *slog.Logger  // nil logs to stderr
+}
+
+type Server struct {
+	cfg    Config
+	conn   *osc.UDPConn
+	url    string
+	logger *slog.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	quitOnce sync.Once
+
+	op sync.Mutex // held by the session operations, one at a time
+
+	mu      sync.Mutex
+	session *session // nil without open session
+	clients []*client
+	guis    []net.Addr
+}
+
+func New(cfg Config) (*Server, error) {
+	if cfg.SessionRoot == "" {
+		return nil, errors.New("no session root")
+	}
+	if err := os.MkdirAll(cfg.SessionRoot, 0755); err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = ":0"
+	}
+	if cfg.Host == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "localhost"
+		}
+		cfg.Host = host
+	}
+	if cfg.AnnounceTimeout == 0 {
+		cfg.AnnounceTimeout = defaultAnnounceTimeout
+	}
+	if cfg.ReplyTimeout == 0 {
+		cfg.ReplyTimeout = defaultReplyTimeout
+	}
+	if cfg.QuitTimeout == 0 {
+		cfg.QuitTimeout = defaultQuitTimeout
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	s := &Server{cfg: cfg, logger: cfg.Logger, done: make(chan struct{})}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.conn, err = osc.ListenUDPContext(s.ctx, "udp", laddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen udp failed: %v", err)
+	}
+	_, port, err := net.SplitHostPort(s.conn.LocalAddr().String())
+	if err != nil {
+		s.conn.Close()
+		return nil, fmt.Errorf("%v", err)
+	}
+	s.url = "osc.udp://" + net.JoinHostPort(cfg.Host, port) + "/"
+	return s, nil
+}
+
+// Url is the NSM_URL of the server.
+func (s *Server) Url() string {
+	return s.url
+}
+
+// Done is closed when a client or GUI asked the server to quit.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Serve handles OSC messages until Close. Malformed packets are logged and
+// dropped, osc.UDPConn.Serve would stop at the first one.
+func (s *Server) Serve() error {
+	err := nsm.NsmServePackets(s.ctx, s.conn, s.handlers(), func(from net.Addr, err error) {
+		s.logger.Warn("rejected packet", "from", from, "err", err)
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// Open opens the session name, like /nsm/server/open.
+func (s *Server) Open(name string) error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	return s.openSession(name)
+}
+
+// Close quits the clients of the open session without saving, like nsmd on SIGTERM,
+// and stops serving.
+func (s *Server) Close() error {
+	s.op.Lock()
+	if err := s.closeSession(); err == nil {
+		s.logger.Info("closed the session")
+	}
+	s.op.Unlock()
+
+	s.cancel()
+	return s.conn.Close()
+}
+
+func (s *Server) send(addr net.Addr, msg osc.Message) {
+	if addr == nil {
+		return
+	}
+	if err := s.conn.SendTo(addr, msg); err != nil {
+		s.logger.Error("send", "addr", msg.Address, "to", addr, "err", err)
+	}
+}
+
+func (s *Server) reply(addr net.Addr, path, text string) {
+	s.send(addr, osc.Message{Address: nsm.NsmAddrReply,
+		Arguments: osc.Arguments{nsm.NsmOscString(path), nsm.NsmOscString(text)}})
+}
+
+func (s *Server) replyError(addr net.Addr, path string, err error) {
+	code := nsm.NSM_ERR_GENERAL_ERROR
+	var nsmErr *nsm.NsmError
+	if errors.As(err, &nsmErr) {
+		code = nsmErr.Code()
+	}
+	s.send(addr, osc.Message{Address: nsm.NsmAddrError,
+		Arguments: osc.Arguments{nsm.NsmOscString(path), osc.Int(int32(code)), nsm.NsmOscString(err.Error())}})
+}
+
+// guiSend sends msg to every announced GUI. The caller holds s.mu.
+func (s *Server) guiSend(address string, args ...osc.Argument) {
+	for _, gui := range s.guis {
+		s.send(gui, osc.Message{Address: address, Arguments: args})
+	}
+}
+
+func (s *Server) guiMessage(format string, args ...any) {
+	text := fmt.Sprintf(format, args...)
+	s.logger.Info(text)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.guiSend(nsm.NsmAddrGuiServerMessage, nsm.NsmOscString(text))
+}
+
+// nsmError returns an error with an NSM error code, the code goes into the /error reply.
+func nsmError(code nsm.NsmErrCode, format string, args ...any) error {
+	err := nsm.NsmErr(code, fmt.Sprintf(format, args...))
+	return &err
+}
+
+// operation runs a session operation in its own goroutine, the OSC server can't wait
+// for the clients' replies. Busy with another operation, the request fails with
+// NSM_ERR_OPERATION_PENDING.
+func (s *Server) operation(sender net.Addr, path string, op func() (string, error)) {
+	if !s.op.TryLock() {
+		s.replyError(sender, path, nsmError(nsm.NSM_ERR_OPERATION_PENDING, "An operation pending."))
+		return
+	}
+	go func() {
+		text, err := op()
+		// unlocked before the reply, the sender may send its next request on it.
+		s.op.Unlock()
+		if err != nil {
+			s.logger.Warn(path, "err", err)
+			s.replyError(sender, path, err)
+			return
+		}
+		s.reply(sender, path, text)
+	}()
+}
+
+func hasCapability(capabilities string, capability nsm.NsmCapability) bool {
+	return strings.Contains(capabilities, capability.String())
+}
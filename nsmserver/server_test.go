@@ -0,0 +1,449 @@
+package nsmserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scgolang/osc"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+// testClientEnv makes the test binary run as an NSM client, for the server to launch.
+const testClientEnv = "NSMSERVER_TEST_CLIENT"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testClientEnv) != "" {
+		os.Exit(runTestClient())
+	}
+	os.Exit(m.Run())
+}
+
+// runTestClient keeps a counter in its file, which every save increments.
+func runTestClient() int {
+	c := nsm.NsmNewClient()
+	var (
+		path  string
+		saves int
+	)
+	c.NsmSetOpenCallback(func(p, displayName, clientId string) (string, error) {
+		path = p
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "can't read", err
+		}
+		saves = strings.Count(string(data), "saved\n")
+		return "", nil
+	})
+	c.NsmSetSaveCallback(func() (string, error) {
+		saves++
+		if err := os.WriteFile(path, []byte(strings.Repeat("saved\n", saves)), 0644); err != nil {
+			return "can't write", err
+		}
+		return "", nil
+	})
+	c.NsmSetSessionIsLoadedCallback(func() error { return nil })
+	if err := c.NsmInit(os.Getenv(nsm.NsmEnvUrl)); err != nil {
+		return 1
+	}
+	defer c.NsmStop()
+	c.NsmHandleSigterm()
+	c.NsmSetClientCapabilities(nsm.NSM_DIRTY)
+	c.NsmSetPrettyName("test client")
+	c.NsmSetAnnounceTimeout(5000)
+	if err := c.NsmAnnounce(); err != nil {
+		return 1
+	}
+	for {
+		if err := c.NsmCheckWait(50); err != nil {
+			if errors.Is(err, nsm.NsmGotSigtermErr) {
+				return 0
+			}
+			return 1
+		}
+	}
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s, err := New(Config{
+		SessionRoot:     t.TempDir(),
+		Listen:          "127.0.0.1:0",
+		Host:            "127.0.0.1",
+		AnnounceTimeout: 5 * time.Second,
+		ReplyTimeout:    5 * time.Second,
+		QuitTimeout:     2 * time.Second,
+		Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// peer is the test's end of an OSC conversation with the server: a control
+// program, a GUI or a client.
+type peer struct {
+	conn   *net.UDPConn
+	server *net.UDPAddr
+	msgs   chan osc.Message
+}
+
+func newPeer(t *testing.T, s *Server) *peer {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	p := &peer{conn: conn, server: s.conn.LocalAddr().(*net.UDPAddr), msgs: make(chan osc.Message, 256)}
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if msg, err := osc.ParseMessage(buf[:n], addr); err == nil {
+				p.msgs <- msg
+			}
+		}
+	}()
+	return p
+}
+
+func (p *peer) send(t *testing.T, address string, args ...osc.Argument) {
+	t.Helper()
+	msg := osc.Message{Address: address, Arguments: args}
+	if _, err := p.conn.WriteToUDP(msg.Bytes(), p.server); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next returns the next message to address, the others are skipped.
+func (p *peer) next(t *testing.T, address string) osc.Message {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg := <-p.msgs:
+			if msg.Address == address {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for %s", address)
+		}
+	}
+}
+
+// answer returns the /reply or /error to path.
+func (p *peer) answer(t *testing.T, path string) osc.Message {
+	t.Helper()
+	timeout := time.After(20 * time.Second)
+	for {
+		select {
+		case msg := <-p.msgs:
+			if msg.Address != nsm.NsmAddrReply && msg.Address != nsm.NsmAddrError {
+				continue
+			}
+			if s, _ := msg.Arguments[0].ReadString(); s == path {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for the reply to %s", path)
+		}
+	}
+}
+
+func (p *peer) request(t *testing.T, address string, args ...osc.Argument) string {
+	t.Helper()
+	p.send(t, address, args...)
+	msg := p.answer(t, address)
+	if msg.Address == nsm.NsmAddrError {
+		t.Fatalf("%s: %v", address, msg.Arguments)
+	}
+	text, _ := msg.Arguments[1].ReadString()
+	return text
+}
+
+func (p *peer) errorCode(t *testing.T, address string, args ...osc.Argument) nsm.NsmErrCode {
+	t.Helper()
+	p.send(t, address, args...)
+	return p.answerCode(t, address)
+}
+
+// answerCode returns the code of the /error to path.
+func (p *peer) answerCode(t *testing.T, path string) nsm.NsmErrCode {
+	t.Helper()
+	msg := p.answer(t, path)
+	if msg.Address != nsm.NsmAddrError {
+		t.Fatalf("%s: no error, %v", path, msg.Arguments)
+	}
+	code, _ := msg.Arguments[1].ReadInt32()
+	return nsm.NsmErrCode(code)
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSessionWithLaunchedClient(t *testing.T) {
+	s := newTestServer(t)
+	ctl := newPeer(t, s)
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(testClientEnv, "1")
+
+	ctl.request(t, nsm.NsmAddrServerNew, osc.String("album/song"))
+	ctl.request(t, nsm.NsmAddrServerAdd, osc.String(exe))
+	ctl.request(t, nsm.NsmAddrServerSave)
+
+	dir := filepath.Join(s.cfg.SessionRoot, "album", "song")
+	entries, err := readSessionFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].executable != exe || entries[0].name != "test client" {
+		t.Fatalf("session file: %+v", entries)
+	}
+	clientFile := filepath.Join(dir, "test client."+entries[0].id)
+	if got := readFile(t, clientFile); got != "saved\n" {
+		t.Fatalf("client file = %q after one save", got)
+	}
+
+	ctl.send(t, nsm.NsmAddrServerList)
+	var names []string
+	for {
+		name, _ := ctl.answer(t, nsm.NsmAddrServerList).Arguments[1].ReadString()
+		if name == "" {
+			break
+		}
+		names = append(names, name)
+	}
+	if len(names) != 1 || names[0] != "album/song" {
+		t.Fatalf("list = %q", names)
+	}
+
+	// the copy is opened, its client saves into the copied file.
+	ctl.request(t, nsm.NsmAddrServerDuplicate, osc.String("album/copy"))
+	ctl.request(t, nsm.NsmAddrServerSave)
+	copyFile := filepath.Join(s.cfg.SessionRoot, "album", "copy", "test client."+entries[0].id)
+	if got := readFile(t, copyFile); got != "saved\nsaved\nsaved\n" {
+		t.Fatalf("copied client file = %q", got)
+	}
+	if got := readFile(t, clientFile); got != "saved\nsaved\n" {
+		t.Fatalf("client file = %q after the duplicate", got)
+	}
+
+	ctl.request(t, nsm.NsmAddrServerClose)
+	if code := ctl.errorCode(t, nsm.NsmAddrServerSave); code != nsm.NSM_ERR_NO_SESSION_OPEN {
+		t.Fatalf("save without session: code %d", code)
+	}
+	ctl.request(t, nsm.NsmAddrServerOpen, osc.String("album/song"))
+	ctl.request(t, nsm.NsmAddrServerSave)
+	if got := readFile(t, clientFile); got != "saved\nsaved\nsaved\n" {
+		t.Fatalf("client file = %q after reopening", got)
+	}
+	ctl.request(t, nsm.NsmAddrServerAbort)
+}
+
+func TestRequestErrors(t *testing.T) {
+	s := newTestServer(t)
+	client := newPeer(t, s)
+	announce := func(major int32) {
+		client.send(t, nsm.NsmAddrServerAnnouce, osc.String("test"), osc.String(":dirty:"),
+			osc.String("test"), osc.Int(major), osc.Int(0), osc.Int(int32(os.Getpid())))
+	}
+
+	announce(nsm.NsmApiVersionMajor)
+	if code := client.answerCode(t, nsm.NsmAddrServerAnnouce); code != nsm.NSM_ERR_NO_SESSION_OPEN {
+		t.Fatalf("announce without session: code %d", code)
+	}
+	for _, name := range []string{"../outside", "/abs", "."} {
+		if code := client.errorCode(t, nsm.NsmAddrServerNew, osc.String(name)); code != nsm.NSM_ERR_BAD_PROJECT {
+			t.Fatalf("new %q: code %d", name, code)
+		}
+	}
+	client.request(t, nsm.NsmAddrServerNew, osc.String("s"))
+	if code := client.errorCode(t, nsm.NsmAddrServerNew, osc.String("s")); code != nsm.NSM_ERR_CREATE_FAILED {
+		t.Fatalf("new existing session: code %d", code)
+	}
+	if code := client.errorCode(t, nsm.NsmAddrServerDuplicate, osc.String("s/copy")); code != nsm.NSM_ERR_CREATE_FAILED {
+		t.Fatalf("duplicate into the session: code %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(s.cfg.SessionRoot, "s", "copy")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("duplicate into the session left %v", err)
+	}
+	if code := client.errorCode(t, nsm.NsmAddrServerOpen, osc.String("nothing")); code != nsm.NSM_ERR_NO_SUCH_FILE {
+		t.Fatalf("open missing session: code %d", code)
+	}
+
+	announce(nsm.NsmApiVersionMajor + 1)
+	if code := client.answerCode(t, nsm.NsmAddrServerAnnouce); code != nsm.NSM_ERR_INCOMPATIBLE_API {
+		t.Fatalf("announce with API %d: code %d", nsm.NsmApiVersionMajor+1, code)
+	}
+}
+
+func TestMalformedPackets(t *testing.T) {
+	s := newTestServer(t)
+	ctl := newPeer(t, s)
+	// more than osc.UDPConn.Serve had workers, each one stopped at a bad packet.
+	badTypetag := append(osc.Message{Address: nsm.NsmAddrServerList}.Bytes()[:20], ",q\x00\x00"...)
+	for _, packet := range [][]byte{
+		[]byte("hello"),
+		{0},
+		{0xff, 0xfe},
+		badTypetag,
+		badTypetag,
+		[]byte("no osc"),
+	} {
+		if _, err := ctl.conn.WriteToUDP(packet, ctl.server); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// still serving.
+	if name := ctl.request(t, nsm.NsmAddrServerList); name != "" {
+		t.Fatalf("list = %q, want the empty end of the list", name)
+	}
+}
+
+// announcePeer announces p as a client with capabilities and answers the open.
+func announcePeer(t *testing.T, p *peer, capabilities string) (id string) {
+	t.Helper()
+	p.send(t, nsm.NsmAddrServerAnnouce, osc.String("peer"), osc.String(capabilities),
+		osc.String("peer"), osc.Int(nsm.NsmApiVersionMajor), osc.Int(0), osc.Int(int32(os.Getpid())))
+	reply := p.answer(t, nsm.NsmAddrServerAnnouce)
+	if reply.Address != nsm.NsmAddrReply || len(reply.Arguments) != 4 {
+		t.Fatalf("announce reply: %s %v", reply.Address, reply.Arguments)
+	}
+	if name, _ := reply.Arguments[2].ReadString(); name != ServerName {
+		t.Fatalf("server name %q", name)
+	}
+	open := p.next(t, nsm.NsmAddrClientOpen)
+	id, _ = open.Arguments[2].ReadString()
+	p.send(t, nsm.NsmAddrReply, osc.String(nsm.NsmAddrClientOpen), osc.String("Ok"))
+	return id
+}
+
+func TestGuiAndBroadcast(t *testing.T) {
+	s := newTestServer(t)
+	gui := newPeer(t, s)
+	a, b, c := newPeer(t, s), newPeer(t, s), newPeer(t, s)
+
+	gui.request(t, nsm.NsmAddrServerNew, osc.String("s"))
+	gui.send(t, nsm.NsmAddrGuiAnnounce)
+	gui.next(t, nsm.NsmAddrGuiAnnounce)
+
+	idA := announcePeer(t, a, ":dirty:broadcast:")
+	announcePeer(t, b, ":broadcast:")
+	announcePeer(t, c, ":dirty:")
+
+	a.send(t, nsm.NsmAddrClientIsDirty)
+	dirty := gui.next(t, nsm.NsmAddrGuiClientDirty)
+	if id, _ := dirty.Arguments[0].ReadString(); id != idA {
+		t.Fatalf("dirty client %s, want %s", id, idA)
+	}
+	if v, _ := dirty.Arguments[1].ReadInt32(); v != 1 {
+		t.Fatalf("dirty = %d", v)
+	}
+
+	a.send(t, nsm.NsmAddrServerBroadcast, osc.String("/peer/hello"), osc.String("hi"))
+	relayed := b.next(t, "/peer/hello")
+	if text, _ := relayed.Arguments[0].ReadString(); text != "hi" {
+		t.Fatalf("broadcast argument %q", text)
+	}
+	// empty strings are relayed null padded, the arguments after them stay in place.
+	a.send(t, nsm.NsmAddrServerBroadcast, osc.String("/peer/empty"), nsm.NsmOscString(""), osc.String("hi"), osc.Int(7))
+	relayed = b.next(t, "/peer/empty")
+	if want := (osc.Arguments{osc.String(""), osc.String("hi"), osc.Int(7)}); !relayed.Equal(osc.Message{Address: "/peer/empty", Arguments: want}) {
+		t.Fatalf("broadcast arguments %v, want %v", relayed.Arguments, want)
+	}
+	select {
+	case msg := <-c.msgs:
+		t.Fatalf("client without :broadcast: got %s", msg.Address)
+	case msg := <-a.msgs:
+		t.Fatalf("sender got %s", msg.Address)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// a save waits for the replies of the announced clients.
+	gui.send(t, nsm.NsmAddrServerSave)
+	for _, p := range []*peer{a, b, c} {
+		p.next(t, nsm.NsmAddrClientSave)
+		p.send(t, nsm.NsmAddrReply, osc.String(nsm.NsmAddrClientSave), osc.String("Ok"))
+	}
+	if reply := gui.answer(t, nsm.NsmAddrServerSave); reply.Address != nsm.NsmAddrReply {
+		t.Fatalf("save: %v", reply.Arguments)
+	}
+}
+
+func TestControl(t *testing.T) {
+	s := newTestServer(t)
+	ctl, err := nsm.NsmNewControl("osc.udp://" + s.conn.LocalAddr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctl.NsmClose()
+	ctl.NsmSetLogger(nil)
+	ctl.NsmSetTimeout(5 * time.Second)
+
+	if _, err := ctl.NsmRequest(nsm.NsmAddrServerNew, "one"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctl.NsmRequest(nsm.NsmAddrServerNew, "two"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := ctl.NsmList()
+	if err != nil || strings.Join(names, ",") != "one,two" {
+		t.Fatalf("list = %q, %v", names, err)
+	}
+	_, err = ctl.NsmRequest(nsm.NsmAddrServerOpen, "three")
+	var nsmErr *nsm.NsmError
+	if !errors.As(err, &nsmErr) || nsmErr.Code() != nsm.NSM_ERR_NO_SUCH_FILE {
+		t.Fatalf("open missing session: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan nsm.NsmGuiUpdate, 64)
+	go ctl.NsmWatch(ctx, func(u nsm.NsmGuiUpdate) error {
+		updates <- u
+		return nil
+	})
+	// the session root comes first, after the GUI announce was handled.
+	for u := range updates {
+		if u.Address == nsm.NsmAddrGuiSessionRoot {
+			break
+		}
+	}
+	id := announcePeer(t, newPeer(t, s), ":dirty:")
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case u := <-updates:
+			if u.Address == nsm.NsmAddrGuiClientStatus && u.Client == id && u.Args[0].Value == nsm.NsmStatusReady {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no ready status of %s", id)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package nsmserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+const sessionLockName = ".nsm-notes-nsmd.lock"
+
+type session struct {
+	name string // relative to the session root, with slashes
+	path string
+}
+
+// sessionEntry is a line of the session file.
+type sessionEntry struct {
+	name       string
+	executable string
+	id         string
+}
+
+// sessionPath checks a session name from a request and returns its directory.
+func (s *Server) sessionPath(name string) (string, error) {
+	clean := path.Clean(name)
+	if name == "" || path.IsAbs(name) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", nsmError(nsm.NSM_ERR_BAD_PROJECT, "Invalid session name %q", name)
+	}
+	return filepath.Join(s.cfg.SessionRoot, filepath.FromSlash(clean)), nil
+}
+
+// listSessions returns the names of the directories under the root with a session file.
+func (s *Server) listSessions() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(s.cfg.SessionRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != nsm.NsmSessionFileName {
+			return nil
+		}
+		rel, err := filepath.Rel(s.cfg.SessionRoot, filepath.Dir(p))
+		if err != nil || rel == "." {
+			return nil
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+func readSessionFile(dir string) ([]sessionEntry, error) {
+	f, err := os.Open(filepath.Join(dir, nsm.NsmSessionFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nsmError(nsm.NSM_ERR_NO_SUCH_FILE, "No such session")
+		}
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer f.Close()
+
+	var entries []sessionEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 {
+			return nil, nsmError(nsm.NSM_ERR_BAD_PROJECT, "Bad line in session file: %q", line)
+		}
+		entries = append(entries, sessionEntry{name: parts[0], executable: parts[1], id: parts[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	return entries, nil
+}
+
+// writeSessionFile replaces the session file, through a temporary file.
+func writeSessionFile(dir string, entries []sessionEntry) error {
+	var sb strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&sb, "%s:%s:%s\n", e.name, e.executable, e.id)
+	}
+	tmp := filepath.Join(dir, nsm.NsmSessionFileName+".tmp")
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, nsm.NsmSessionFileName)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
+
+// lockSession keeps other servers from opening the session, a lock of a dead
+// server is taken over.
+func lockSession(dir string) error {
+	lockPath := filepath.Join(dir, sessionLockName)
+	if data, err := os.ReadFile(lockPath); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && pid != os.Getpid() && processAlive(pid) {
+			return nsmError(nsm.NSM_ERR_SESSION_LOCKED, "Session is locked by another process")
+		}
+	}
+	if err := os.WriteFile(lockPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return nsmError(nsm.NSM_ERR_SESSION_LOCKED, "Can't lock session: %v", err)
+	}
+	return nil
+}
+
+func unlockSession(dir string) {
+	os.Remove(filepath.Join(dir, sessionLockName))
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// copyDir copies the session directory src to the new directory dst, without the lock.
+// isWithin tells if p is dir or a path below it.
+func isWithin(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case d.Name() == sessionLockName:
+			return nil
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+		return copyFile(p, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/commands"
+)
+
+// commandPalette lists every command with its shortcut, filtered by a fuzzy search.
+type commandPalette struct {
+	win     *fltk.Window
+	input   *fltk.Input
+	browser *fltk.HoldBrowser
+	shown   []*command
+}
+
+func (a *app) buildCommandPalette() {
+	p := &commandPalette{}
+	p.win = fltk.NewWindow(paletteWidth, paletteHeight)
+	p.win.SetLabel(APP_TITLE + " commands")
+	p.win.SetColor(windowColor)
+
+	p.input = fltk.NewInput(0, 0, paletteWidth, paletteInputHeight)
+	p.input.SetCallbackCondition(fltk.WhenChanged)
+	p.input.SetCallback(func() {
+		a.filterCommandPalette()
+	})
+	p.input.SetEventHandler(func(e fltk.Event) bool {
+		if e != fltk.KEYDOWN {
+			return false
+		}
+		switch fltk.EventKey() {
+		case fltk.UP:
+			if v := p.browser.Value(); v > 1 {
+				p.browser.SetValue(v - 1)
+			}
+			return true
+		case fltk.DOWN:
+			if v := p.browser.Value(); v < p.browser.Size() {
+				p.browser.SetValue(v + 1)
+			}
+			return true
+		case fltk.ENTER_KEY:
+			a.runPaletteSelection()
+			return true
+		}
+		return false
+	})
+
+	p.browser = fltk.NewHoldBrowser(0, paletteInputHeight, paletteWidth, paletteHeight-paletteInputHeight)
+	p.browser.SetColumnChar('\t')
+	p.browser.SetColumnWidths(paletteWidth * 2 / 3)
+	p.browser.SetCallback(func() {
+		if fltk.EventClicks() > 0 {
+			a.runPaletteSelection()
+		}
+	})
+
+	p.win.End()
+	p.win.Resizable(p.browser)
+
+	a.palette = p
+}
+
+func (a *app) showCommandPalette() {
+	a.palette.input.SetValue("")
+	a.filterCommandPalette()
+	a.palette.win.Show()
+	a.palette.input.TakeFocus()
+}
+
+func (a *app) filterCommandPalette() {
+	p := a.palette
+	pattern := p.input.Value()
+
+	type match struct {
+		cmd   *command
+		score int
+	}
+	var matches []match
+	for _, c := range a.commands.commands {
+		if score, ok := commands.FuzzyScore(pattern, c.title()); ok {
+			matches = append(matches, match{c, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	p.browser.Clear()
+	p.shown = p.shown[:0]
+	keys := shortcutKeys()
+	for _, m := range matches {
+		p.browser.Add(m.cmd.title() + "\t" + keys.ShortcutString(m.cmd.shortcut))
+		p.shown = append(p.shown, m.cmd)
+	}
+	if len(p.shown) > 0 {
+		p.browser.SetValue(1)
+	}
+}
+
+func (a *app) runPaletteSelection() {
+	p := a.palette
+	line := p.browser.Value()
+	if line < 1 || line > len(p.shown) {
+		return
+	}
+	p.win.Hide()
+	p.shown[line-1].action()
+}
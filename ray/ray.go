@@ -0,0 +1,247 @@
+// Package ray adds the protocol extensions of RaySession to an nsmclient.NsmClient:
+// the monitor messages, which tell a client about the other clients of the session,
+// client properties and saving a client as template. With other session managers
+// the extensions stay unused.
+package ray
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/scgolang/osc"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+// ServerName is the name ray-daemon answers the announce with.
+const ServerName = "RaySession"
+
+// ServerCapabilityMonitor is announced by servers sending the monitor messages to
+// clients with nsm.NSM_MONITOR.
+const ServerCapabilityMonitor nsm.NsmServerCapability = ":monitor:"
+
+const (
+	// s:client_id s:jack_client_name i:started, for each client after the announce
+	// and after AddrServerMonitorReset.
+	AddrMonitorClientState = "/nsm/client/monitor/client_state"
+	// s:client_id s:jack_client_name i:started, when a client changed.
+	AddrMonitorClientUpdated = "/nsm/client/monitor/client_updated"
+	// s:client_id s:event
+	AddrMonitorClientEvent = "/nsm/client/monitor/client_event"
+	// asks for the client states again.
+	AddrServerMonitorReset = "/nsm/server/monitor_reset"
+
+	AddrClientSetProperties  = "/ray/client/set_properties"   // s:client_id s:"key:value" lines
+	AddrClientSaveAsTemplate = "/ray/client/save_as_template" // s:client_id s:template_name
+)
+
+// The events of AddrMonitorClientEvent, others are kept as they are.
+const (
+	EventAdded           = "added"
+	EventRemoved         = "removed"
+	EventStarted         = "started"
+	EventReady           = "ready"
+	EventSaved           = "saved"
+	EventDirty           = "dirty"
+	EventClean           = "clean"
+	EventStoppedByServer = "stopped_by_server"
+	EventStoppedByItself = "stopped_by_itself"
+)
+
+// SessionClient is another client of the session, as the monitor messages tell.
+type SessionClient struct {
+	ID      string
+	Name    string // the JACK client name
+	Started bool
+	Dirty   bool
+	Event   string // the last event
+}
+
+type Ray struct {
+	c *nsm.NsmClient
+
+	mu      sync.Mutex // the monitor methods run in the OSC server goroutine
+	clients map[string]*SessionClient
+	changes uint64
+}
+
+// New adds the monitor methods to c, it has to be called before c.NsmInit.
+// The client has to announce nsm.NSM_MONITOR to get the messages.
+func New(c *nsm.NsmClient) (*Ray, error) {
+	r := &Ray{c: c, clients: make(map[string]*SessionClient)}
+	for addr, method := range map[string]osc.Method{
+		AddrMonitorClientState:   r.oscClientState,
+		AddrMonitorClientUpdated: r.oscClientState,
+		AddrMonitorClientEvent:   r.oscClientEvent,
+	} {
+		if err := c.NsmAddExtensionMethod(addr, method); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Detect reports whether the session manager c announced to is RaySession.
+func Detect(c *nsm.NsmClient) bool {
+	return c.NsmIsActive() && strings.HasPrefix(c.NsmGetSessionManagerName(), ServerName)
+}
+
+// IsRay is Detect for the client of r.
+func (r *Ray) IsRay() bool {
+	return Detect(r.c)
+}
+
+// Monitored reports whether the server sends the monitor messages.
+func (r *Ray) Monitored() bool {
+	return r.c.NsmIsActive() && r.c.NsmClientHasCapability(nsm.NSM_MONITOR) &&
+		(r.IsRay() || r.c.NsmServerHasCapability(ServerCapabilityMonitor))
+}
+
+// Clients returns the other clients of the session, by client ID.
+func (r *Ray) Clients() []SessionClient {
+	own := r.c.NsmGetClientId()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := make([]SessionClient, 0, len(r.clients))
+	for id, client := range r.clients {
+		if id != own {
+			clients = append(clients, *client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// Changes counts the monitor messages that changed the clients, for polling.
+func (r *Ray) Changes() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.changes
+}
+
+func (r *Ray) oscClientState(msg osc.Message) error {
+	if len(msg.Arguments) != 3 {
+		return fmt.Errorf("%s: expected 3 arguments, got %d", msg.Address, len(msg.Arguments))
+	}
+	id, err := msg.Arguments[0].ReadString()
+	if err != nil {
+		return fmt.Errorf("%s: %v", msg.Address, err)
+	}
+	name, err := msg.Arguments[1].ReadString()
+	if err != nil {
+		return fmt.Errorf("%s: %v", msg.Address, err)
+	}
+	started, err := msg.Arguments[2].ReadInt32()
+	if err != nil {
+		return fmt.Errorf("%s: %v", msg.Address, err)
+	}
+	if id == "" {
+		return nil // the end of the states
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client := r.client(id)
+	client.Name = name
+	client.Started = started != 0
+	r.changes++
+	return nil
+}
+
+func (r *Ray) oscClientEvent(msg osc.Message) error {
+	if len(msg.Arguments) != 2 {
+		return fmt.Errorf("%s: expected 2 arguments, got %d", msg.Address, len(msg.Arguments))
+	}
+	id, err := msg.Arguments[0].ReadString()
+	if err != nil {
+		return fmt.Errorf("%s: %v", msg.Address, err)
+	}
+	event, err := msg.Arguments[1].ReadString()
+	if err != nil {
+		return fmt.Errorf("%s: %v", msg.Address, err)
+	}
+	if id == "" {
+		return fmt.Errorf("%s: no client id", msg.Address)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes++
+	if event == EventRemoved {
+		delete(r.clients, id)
+		return nil
+	}
+	client := r.client(id)
+	client.Event = event
+	switch event {
+	case EventStarted, EventReady:
+		client.Started = true
+	case EventStoppedByServer, EventStoppedByItself:
+		client.Started = false
+	case EventDirty:
+		client.Dirty = true
+	case EventClean, EventSaved:
+		client.Dirty = false
+	}
+	return nil
+}
+
+// client returns the client with id, added if it's new. r.mu is held.
+func (r *Ray) client(id string) *SessionClient {
+	client, ok := r.clients[id]
+	if !ok {
+		client = &SessionClient{ID: id}
+		r.clients[id] = client
+	}
+	return client
+}
+
+// ResetMonitor asks the server for the states of all clients again.
+func (r *Ray) ResetMonitor() error {
+	if !r.Monitored() {
+		return fmt.Errorf("monitor: %w", nsm.NsmUnsupportedErr)
+	}
+	return r.send(osc.Message{Address: AddrServerMonitorReset})
+}
+
+// SetProperties sets properties of the client in RaySession, e.g. "icon".
+func (r *Ray) SetProperties(props map[string]string) error {
+	if !r.IsRay() {
+		return fmt.Errorf("properties: %w", nsm.NsmUnsupportedErr)
+	}
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		if key == "" || strings.ContainsAny(key, ":\n") || strings.Contains(props[key], "\n") {
+			return fmt.Errorf("bad property %q", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = key + ":" + props[key]
+	}
+	return r.send(osc.Message{Address: AddrClientSetProperties, Arguments: osc.Arguments{
+		nsm.NsmOscString(r.c.NsmGetClientId()), nsm.NsmOscString(strings.Join(lines, "\n"))}})
+}
+
+// SaveAsTemplate asks RaySession to save the client, with its files, as client template.
+func (r *Ray) SaveAsTemplate(name string) error {
+	if !r.IsRay() {
+		return fmt.Errorf("save as template: %w", nsm.NsmUnsupportedErr)
+	}
+	if name == "" {
+		return fmt.Errorf("save as template: no template name")
+	}
+	return r.send(osc.Message{Address: AddrClientSaveAsTemplate, Arguments: osc.Arguments{
+		nsm.NsmOscString(r.c.NsmGetClientId()), nsm.NsmOscString(name)}})
+}
+
+func (r *Ray) send(msg osc.Message) error {
+	if err := r.c.NsmSendExtension(msg); err != nil {
+		return fmt.Errorf("%s: %w", msg.Address, err)
+	}
+	return nil
+}
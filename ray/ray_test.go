@@ -0,0 +1,156 @@
+package ray
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/scgolang/osc"
+
+	nsm "nsm-notes/nsmclient"
+)
+
+// newRayClient announces a client with the monitor capability to a fake server
+// called serverName, and opens it as client nSELF.
+func newRayClient(t *testing.T, serverName string) (*Ray, *nsm.FakeConn) {
+	t.Helper()
+	conn := nsm.NewFakeConn()
+	c := nsm.NsmNewClient()
+	c.NsmSetLogger(nil)
+	c.NsmSetOpenCallback(func(path, displayName, clientId string) (string, error) { return "", nil })
+	r, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NsmInitWithConn(conn, conn.RemoteAddr()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.NsmStop() })
+	if err := c.NsmSetClientCapabilities(nsm.NSM_DIRTY, nsm.NSM_MONITOR); err != nil {
+		t.Fatal(err)
+	}
+	conn.AcceptAnnounce(serverName, ":server_control:optional-gui:")
+	c.NsmSetAnnounceTimeout(2000)
+	if err := c.NsmAnnounce(); err != nil {
+		t.Fatal(err)
+	}
+	conn.Deliver(osc.Message{Address: nsm.NsmAddrClientOpen, Arguments: osc.Arguments{
+		osc.String("/tmp/notes"), osc.String("Notes"), osc.String("nSELF")}})
+	if err := c.NsmCheckWait(2000); err != nil {
+		t.Fatal(err)
+	}
+	<-conn.Sent() // the reply to the open
+	return r, conn
+}
+
+// waitChanges polls until r saw n changes.
+func waitChanges(t *testing.T, r *Ray, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Changes() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d changes, want %d", r.Changes(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	r, conn := newRayClient(t, ServerName)
+	if !r.IsRay() || !r.Monitored() {
+		t.Fatal("RaySession not detected")
+	}
+
+	state := func(id, name string, started int32) osc.Message {
+		return osc.Message{Address: AddrMonitorClientState, Arguments: osc.Arguments{
+			nsm.NsmOscString(id), nsm.NsmOscString(name), osc.Int(started)}}
+	}
+	event := func(id, event string) osc.Message {
+		return osc.Message{Address: AddrMonitorClientEvent, Arguments: osc.Arguments{osc.String(id), osc.String(event)}}
+	}
+	for _, msg := range []osc.Message{
+		state("nSELF", "nsm-notes", 1),
+		state("nCARL", "Carla", 1),
+		state("nHYDR", "Hydrogen", 0),
+		state("", "", 3),
+		event("nHYDR", EventStarted),
+		event("nCARL", EventDirty),
+		event("nGONE", EventAdded),
+		event("nGONE", EventRemoved),
+	} {
+		if err := conn.Deliver(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only the session manager is listened to.
+	spoofed := state("nEVIL", "evil", 1)
+	spoofed.Sender = fakeSender("evil")
+	conn.Deliver(spoofed)
+	conn.Deliver(event("nLAST", EventAdded))
+	waitChanges(t, r, 8)
+
+	want := []SessionClient{
+		{ID: "nCARL", Name: "Carla", Started: true, Dirty: true, Event: EventDirty},
+		{ID: "nHYDR", Name: "Hydrogen", Started: true, Event: EventStarted},
+		{ID: "nLAST", Event: EventAdded},
+	}
+	got := r.Clients()
+	if len(got) != len(want) {
+		t.Fatalf("clients %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("client %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+type fakeSender string
+
+func (a fakeSender) Network() string { return "fake" }
+func (a fakeSender) String() string  { return string(a) }
+
+func TestRayRequests(t *testing.T) {
+	r, conn := newRayClient(t, ServerName)
+	if err := r.SetProperties(map[string]string{"label": "notes", "icon": "accessories-text-editor"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SaveAsTemplate("session notes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ResetMonitor(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		AddrClientSetProperties + " nSELF icon:accessories-text-editor\nlabel:notes",
+		AddrClientSaveAsTemplate + " nSELF session notes",
+		AddrServerMonitorReset,
+	} {
+		msg := <-conn.Sent()
+		got := msg.Address
+		for _, arg := range msg.Arguments {
+			s, _ := arg.ReadString()
+			got += " " + s
+		}
+		if got != want {
+			t.Errorf("sent %q, want %q", got, want)
+		}
+	}
+}
+
+func TestOtherServer(t *testing.T) {
+	r, _ := newRayClient(t, "New Session Manager")
+	if r.IsRay() || r.Monitored() {
+		t.Fatal("New Session Manager taken for RaySession")
+	}
+	for _, err := range []error{
+		r.SetProperties(map[string]string{"icon": "x"}),
+		r.SaveAsTemplate("x"),
+		r.ResetMonitor(),
+	} {
+		if !errors.Is(err, nsm.NsmUnsupportedErr) {
+			t.Errorf("err = %v, want %v", err, nsm.NsmUnsupportedErr)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/recovery"
+)
+
+// findRecovery remembers unsaved notes of the last run, they are offered from
+// the main loop because the open callback can't wait for a dialog.
+func (a *app) findRecovery() {
+	p, err := recovery.Find(a.fileName)
+	if err != nil {
+		a.logf("%v", err)
+	}
+	a.recoveryFile = p
+}
+
+// askRecovery offers the unsaved notes of the last run. Loaded notes replace
+// the buffer and are saved with the next save, which removes the recovery file.
+func (a *app) askRecovery() {
+	p := a.recoveryFile
+	a.recoveryFile = ""
+
+	msg := fmt.Sprintf("The last run left unsaved notes in %s.\nLoad them instead of the saved notes?", p)
+	if fltk.ChoiceDialog(msg, "Discard", "Load") != 1 {
+		if err := recovery.Clear(a.fileName); err != nil {
+			a.setStatusError(err)
+		}
+		return
+	}
+
+	data, err := recovery.Read(a.fileName)
+	if err != nil {
+		a.setStatusError(err)
+		return
+	}
+	text, err := a.decodeNotes(data)
+	if err != nil {
+		a.setStatusError(fmt.Errorf("%s: %v", p, err))
+		return
+	}
+	a.replaceNotes(text)
+	a.setAppDirty()
+}
+
+func (a *app) shutdown() error {
+	if !a.appIsDirty || a.fileName == "" || a.locked {
+		return nil
+	}
+	// encrypted notes stay encrypted in the recovery file.
+	data, err := a.encodeNotes(a.notesText())
+	if err != nil {
+		return err
+	}
+	return recovery.Write(a.fileName, data)
+}
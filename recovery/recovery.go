@@ -0,0 +1,55 @@
+// Package recovery keeps notes that couldn't be saved next to the notes file.
+// NSM sends SIGTERM also when the user aborts the session, so unsaved notes
+// don't go to the notes file, the user picks them up on the next open.
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+const Suffix = ".unsaved" // unsaved notes are written to the notes file name plus this
+
+// Name is the recovery file of the notes in fileName.
+func Name(fileName string) string {
+	return fileName + Suffix
+}
+
+// Write stores the unsaved notes of fileName.
+func Write(fileName string, data []byte) error {
+	if err := os.WriteFile(Name(fileName), data, 0644); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
+
+// Find returns the recovery file a previous run left for fileName, or "".
+func Find(fileName string) (string, error) {
+	p := Name(fileName)
+	if _, err := os.Stat(p); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("%v", err)
+	}
+	return p, nil
+}
+
+// Read returns the unsaved notes of fileName.
+func Read(fileName string) ([]byte, error) {
+	data, err := os.ReadFile(Name(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	return data, nil
+}
+
+// Clear removes the recovery file once the notes were loaded or saved over,
+// there being none is fine.
+func Clear(fileName string) error {
+	if err := os.Remove(Name(fileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
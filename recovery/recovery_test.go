@@ -0,0 +1,39 @@
+package recovery
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCycle(t *testing.T) {
+	notes := filepath.Join(t.TempDir(), "notes.md")
+
+	if p, err := Find(notes); err != nil || p != "" {
+		t.Fatalf("found %q, %v before writing", p, err)
+	}
+	if err := Clear(notes); err != nil {
+		t.Fatalf("clearing nothing: %v", err)
+	}
+
+	if err := Write(notes, []byte("unsaved verse")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Find(notes)
+	if err != nil || p != notes+Suffix {
+		t.Fatalf("found %q, %v, want %q", p, err, notes+Suffix)
+	}
+	data, err := Read(notes)
+	if err != nil || string(data) != "unsaved verse" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+
+	if err := Clear(notes); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := Find(notes); err != nil || p != "" {
+		t.Fatalf("found %q, %v after clearing", p, err)
+	}
+	if _, err := Read(notes); err == nil {
+		t.Fatal("read a cleared recovery file")
+	}
+}
@@ -0,0 +1,128 @@
+// Package search finds notes across the NSM sessions under a session root, by
+// the tags of their front matter and by words.
+package search
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"nsm-notes/crypt"
+	"nsm-notes/frontmatter"
+	nsm "nsm-notes/nsmclient"
+)
+
+// Query matches notes with all of Tags and all of Words, case-insensitively.
+// An empty query matches every notes file.
+type Query struct {
+	Tags  []string
+	Words []string // in the front matter or the body
+}
+
+type Result struct {
+	Session string // relative to the root, with slashes
+	Client  string // the client name from the session file
+	Path    string
+	Meta    frontmatter.Meta
+}
+
+// Sessions searches the notes of the clients started with executable in every
+// session under root. Encrypted notes can't be searched, their paths are
+// returned apart.
+func Sessions(root, executable string, q Query) (found []Result, encrypted []string, err error) {
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != nsm.NsmSessionFileName {
+			return nil
+		}
+		dir := filepath.Dir(p)
+		session, err := filepath.Rel(root, dir)
+		if err != nil || session == "." {
+			return nil
+		}
+		clients, err := notesClients(p, executable)
+		if err != nil {
+			return err
+		}
+		for _, c := range clients {
+			r := Result{Session: filepath.ToSlash(session), Client: c.name, Path: filepath.Join(dir, c.name+"."+c.id)}
+			data, err := os.ReadFile(r.Path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue // not saved yet
+			}
+			if err != nil {
+				return fmt.Errorf("%v", err)
+			}
+			if crypt.IsEncrypted(data) {
+				encrypted = append(encrypted, r.Path)
+				continue
+			}
+			var body string
+			r.Meta, _, body = frontmatter.Split(string(data))
+			if q.match(r.Meta, body) {
+				found = append(found, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v", err)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Session != found[j].Session {
+			return found[i].Session < found[j].Session
+		}
+		return found[i].Client < found[j].Client
+	})
+	return found, encrypted, nil
+}
+
+func (q Query) match(m frontmatter.Meta, body string) bool {
+	for _, t := range q.Tags {
+		if !m.HasTag(t) {
+			return false
+		}
+	}
+	text := strings.ToLower(m.Format() + body)
+	for _, w := range q.Words {
+		if !strings.Contains(text, strings.ToLower(w)) {
+			return false
+		}
+	}
+	return true
+}
+
+type sessionClient struct {
+	name, id string
+}
+
+// notesClients reads the clients of a session file that run executable,
+// other lines are skipped.
+func notesClients(sessionFile, executable string) ([]sessionClient, error) {
+	f, err := os.Open(sessionFile)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer f.Close()
+
+	var clients []sessionClient
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(parts) != 3 || filepath.Base(parts[1]) != executable {
+			continue
+		}
+		clients = append(clients, sessionClient{name: parts[0], id: parts[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	return clients, nil
+}
@@ -0,0 +1,81 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nsm-notes/crypt"
+)
+
+func writeFile(t *testing.T, p, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "album/night drive/session.nsm"),
+		"NSM-Notes:nsm-notes:nABCD\nCarla:carla:nEFGH\nNotes 2:/usr/bin/nsm-notes:nIJKL\n")
+	writeFile(t, filepath.Join(root, "album/night drive/NSM-Notes.nABCD"),
+		"---\ntitle: Night Drive\ntags: [vocals, mix]\n---\nreverb preset B\n")
+	writeFile(t, filepath.Join(root, "album/night drive/Carla.nEFGH"), "vocals mix reverb")
+	// Notes 2 was never saved.
+	writeFile(t, filepath.Join(root, "demo/session.nsm"), "NSM-Notes:nsm-notes:nMNOP\n")
+	writeFile(t, filepath.Join(root, "demo/NSM-Notes.nMNOP"), "vocals, no front matter\n")
+	writeFile(t, filepath.Join(root, "not a session/NSM-Notes.nQRST"), "---\ntags: [vocals]\n---\n")
+
+	k, err := crypt.NewKey("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := k.Encrypt([]byte("---\ntags: [vocals]\n---\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "secret/session.nsm"), "NSM-Notes:nsm-notes:nUVWX\n")
+	writeFile(t, filepath.Join(root, "secret/NSM-Notes.nUVWX"), string(secret))
+
+	for _, test := range []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"all", Query{}, []string{"album/night drive", "demo"}},
+		{"tag", Query{Tags: []string{"Vocals"}}, []string{"album/night drive"}},
+		{"tags", Query{Tags: []string{"vocals", "drums"}}, nil},
+		{"words", Query{Words: []string{"VOCALS"}}, []string{"album/night drive", "demo"}},
+		{"title", Query{Words: []string{"drive", "preset"}}, []string{"album/night drive"}},
+	} {
+		found, encrypted, err := Sessions(root, "nsm-notes", test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sessions []string
+		for _, r := range found {
+			sessions = append(sessions, r.Session)
+		}
+		if len(sessions) != len(test.want) {
+			t.Errorf("%s: found %q, want %q", test.name, sessions, test.want)
+			continue
+		}
+		for i := range sessions {
+			if sessions[i] != test.want[i] {
+				t.Errorf("%s: found %q, want %q", test.name, sessions, test.want)
+			}
+		}
+		if len(encrypted) != 1 || filepath.Base(encrypted[0]) != "NSM-Notes.nUVWX" {
+			t.Errorf("%s: encrypted = %q", test.name, encrypted)
+		}
+	}
+
+	found, _, _ := Sessions(root, "nsm-notes", Query{Tags: []string{"mix"}})
+	if len(found) != 1 || found[0].Meta.Title != "Night Drive" || found[0].Client != "NSM-Notes" {
+		t.Fatalf("found %+v", found)
+	}
+}
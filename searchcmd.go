@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nsm-notes/frontmatter"
+	"nsm-notes/search"
+)
+
+// runSearchCommand implements "nsm-notes search", it finds notes across the
+// sessions by tag and words without a NSM server.
+func runSearchCommand(args []string) int {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	root := fs.String("root", "", "session root directory (default ~/"+defaultSessionRoot+")")
+	tags := fs.String("tags", "", "comma separated tags the notes must all have")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s search [-root dir] [-tags tag,...] [word...]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *root == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		*root = filepath.Join(home, defaultSessionRoot)
+	}
+
+	q := search.Query{Tags: frontmatter.ParseTags(*tags), Words: fs.Args()}
+	found, encrypted, err := search.Sessions(*root, filepath.Base(os.Args[0]), q)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _, p := range encrypted {
+		fmt.Fprintf(os.Stderr, "%s is encrypted, not searched\n", p)
+	}
+	for _, r := range found {
+		fmt.Printf("%s\t%s\t%s\t%s\n", r.Session, r.Client, r.Meta.Title, strings.Join(r.Meta.Tags, ", "))
+	}
+
+	if len(found) == 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"nsm-notes/transport"
+	"nsm-notes/undo"
+)
+
+type confEntry struct {
+	key   string
+	value string
+	line  int
+}
+
+// configFile is the path of name in the nsm-notes directory of the user config dir.
+func configFile(name string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, configDirName, name)
+}
+
+// readConfFile reads "key = value" lines, # starts a comment line.
+// A missing file has no entries.
+func readConfFile(path string) ([]confEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer f.Close()
+
+	var entries []confEntry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		entries = append(entries, confEntry{strings.TrimSpace(key), strings.TrimSpace(value), n})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	return entries, nil
+}
+
+// loadTransportConfig reads the transport.* settings. Without transport.listen
+// no DAW position is received.
+func loadTransportConfig(path string) (transport.Config, error) {
+	cfg := transport.Config{
+		Address:    transport.DefaultAddress,
+		Format:     transport.FormatFrame,
+		SampleRate: transport.DefaultSampleRate,
+	}
+
+	entries, err := readConfFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	for _, e := range entries {
+		switch e.key {
+		case "transport.listen":
+			cfg.Listen = e.value
+		case "transport.address":
+			cfg.Address = e.value
+		case "transport.format":
+			cfg.Format = transport.Format(e.value)
+		case "transport.sample_rate":
+			rate, err := strconv.Atoi(e.value)
+			if err != nil {
+				return cfg, fmt.Errorf("%s:%d: %v", path, e.line, err)
+			}
+			cfg.SampleRate = rate
+		}
+	}
+	return cfg, nil
+}
+
+type spellConfig struct {
+	Enabled    bool
+	Dictionary string // .dic file or its path without extension, found by language when empty
+	Language   string
+}
+
+// loadSpellConfig reads the spell.* settings, by default the dictionary of the
+// LANG locale is used when one is installed.
+func loadSpellConfig(path string) (spellConfig, error) {
+	cfg := spellConfig{Enabled: true, Language: spellLanguage()}
+
+	entries, err := readConfFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	for _, e := range entries {
+		switch e.key {
+		case "spell.enabled":
+			enabled, err := strconv.ParseBool(e.value)
+			if err != nil {
+				return cfg, fmt.Errorf("%s:%d: %v", path, e.line, err)
+			}
+			cfg.Enabled = enabled
+		case "spell.dictionary":
+			cfg.Dictionary = e.value
+		case "spell.language":
+			cfg.Language = e.value
+		}
+	}
+	return cfg, nil
+}
+
+type undoConfig struct {
+	Persist bool // the history is saved next to the notes
+	Levels  int
+}
+
+// loadUndoConfig reads the undo.* settings, by default the history isn't saved.
+func loadUndoConfig(path string) (undoConfig, error) {
+	cfg := undoConfig{Levels: undo.DefaultLevels}
+
+	entries, err := readConfFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	for _, e := range entries {
+		switch e.key {
+		case "undo.persist":
+			persist, err := strconv.ParseBool(e.value)
+			if err != nil {
+				return cfg, fmt.Errorf("%s:%d: %v", path, e.line, err)
+			}
+			cfg.Persist = persist
+		case "undo.levels":
+			levels, err := strconv.Atoi(e.value)
+			if err != nil || levels <= 0 {
+				return cfg, fmt.Errorf("%s:%d: undo.levels must be a positive number", path, e.line)
+			}
+			cfg.Levels = levels
+		}
+	}
+	return cfg, nil
+}
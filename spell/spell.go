@@ -0,0 +1,433 @@
+// Package spell checks words against Hunspell .aff/.dic dictionaries, without
+// linking libhunspell. It knows the affix rules, REP, TRY, NEEDAFFIX and
+// FORBIDDENWORD, compound words aren't supported.
+package spell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultDirs are searched by Find for a language's dictionary.
+var DefaultDirs = []string{
+	"/usr/share/hunspell",
+	"/usr/share/myspell",
+	"/usr/share/myspell/dicts",
+	"/usr/local/share/hunspell",
+}
+
+var ErrNotFound = errors.New("no dictionary found")
+
+type affix struct {
+	flag  string
+	cross bool // combines with the affixes of the other kind
+	strip string
+	add   string
+	flags []string // continuation flags of the affixed word
+	cond  *regexp.Regexp
+}
+
+type Dictionary struct {
+	words     map[string][]string // word to its flags
+	prefixes  map[string][]*affix // by the added text
+	suffixes  map[string][]*affix
+	try       string
+	rep       [][2]string
+	flagType  string
+	needAffix string
+	forbidden string
+
+	mu           sync.Mutex // the personal words are added from the GUI
+	personal     map[string]bool
+	personalPath string
+}
+
+// Find returns the base path, without extension, of the dictionary of lang,
+// like "en_US", in dirs or DefaultDirs.
+func Find(lang string, dirs ...string) (string, error) {
+	if len(dirs) == 0 {
+		dirs = DefaultDirs
+	}
+	for _, dir := range dirs {
+		base := filepath.Join(dir, lang)
+		if _, err := os.Stat(base + ".dic"); err == nil {
+			return base, nil
+		}
+	}
+	return "", fmt.Errorf("%w for %s in %s", ErrNotFound, lang, strings.Join(dirs, ", "))
+}
+
+// Load reads base.aff and base.dic, base may also name the .dic file.
+func Load(base string) (*Dictionary, error) {
+	base = strings.TrimSuffix(base, ".dic")
+	d := &Dictionary{
+		words:    make(map[string][]string),
+		prefixes: make(map[string][]*affix),
+		suffixes: make(map[string][]*affix),
+		personal: make(map[string]bool),
+	}
+	decode, err := d.readAff(base + ".aff")
+	if err != nil {
+		return nil, err
+	}
+	if err := d.readDic(base+".dic", decode); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// readLines calls line with every line of path that isn't empty or a comment.
+func readLines(path string, decode func(string) string, line func(n int, text string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		if decode != nil {
+			text = decode(text)
+		}
+		if n == 1 {
+			text = strings.TrimPrefix(text, "\ufeff") // byte order mark
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := line(n, text); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
+
+// decoder converts lines in the SET encoding to UTF-8.
+func decoder(set string) (func(string) string, error) {
+	switch strings.ToUpper(set) {
+	case "", "UTF-8":
+		return nil, nil
+	case "ISO8859-1", "ISO-8859-1", "ISO8859-15", "ISO-8859-15":
+		return func(s string) string {
+			runes := make([]rune, len(s))
+			for i := 0; i < len(s); i++ {
+				runes[i] = rune(s[i])
+			}
+			return string(runes)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %s", set)
+}
+
+// readAff reads the affix file, its SET is returned to read the .dic.
+func (d *Dictionary) readAff(path string) (func(string) string, error) {
+	// the SET line comes first, the file is read again with its encoding.
+	var set string
+	readLines(path, nil, func(n int, text string) error {
+		if f := strings.Fields(text); len(f) > 1 && f[0] == "SET" && set == "" {
+			set = f[1]
+		}
+		return nil
+	})
+	decode, err := decoder(set)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	crossable := make(map[string]bool) // by kind and flag, the first line of a class is its header
+	err = readLines(path, decode, func(n int, text string) error {
+		f := strings.Fields(text)
+		switch f[0] {
+		case "FLAG":
+			if len(f) > 1 {
+				d.flagType = f[1]
+			}
+		case "TRY":
+			if len(f) > 1 {
+				d.try = f[1]
+			}
+		case "NEEDAFFIX":
+			if len(f) > 1 {
+				d.needAffix = f[1]
+			}
+		case "FORBIDDENWORD":
+			if len(f) > 1 {
+				d.forbidden = f[1]
+			}
+		case "REP":
+			if len(f) > 2 {
+				d.rep = append(d.rep, [2]string{strings.ReplaceAll(f[1], "_", " "), strings.ReplaceAll(f[2], "_", " ")})
+			}
+		case "PFX", "SFX":
+			if len(f) < 2 {
+				return fmt.Errorf("short affix line %q", text)
+			}
+			key := f[0] + " " + f[1]
+			if _, ok := crossable[key]; !ok {
+				crossable[key] = len(f) > 2 && f[2] == "Y" // "SFX flag cross count"
+				return nil
+			}
+			a, err := d.parseAffix(f, text)
+			if err != nil {
+				return err
+			}
+			a.cross = crossable[key]
+			if f[0] == "PFX" {
+				d.prefixes[a.add] = append(d.prefixes[a.add], a)
+			} else {
+				d.suffixes[a.add] = append(d.suffixes[a.add], a)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decode, nil
+}
+
+// parseAffix parses a rule line "SFX flag strip add[/flags] [condition]".
+func (d *Dictionary) parseAffix(f []string, text string) (*affix, error) {
+	if len(f) < 4 {
+		return nil, fmt.Errorf("short affix rule %q", text)
+	}
+	a := &affix{flag: f[1], strip: f[2], add: f[3]}
+	if a.strip == "0" {
+		a.strip = ""
+	}
+	if add, flags, found := strings.Cut(a.add, "/"); found {
+		a.add, a.flags = add, d.parseFlags(flags)
+	}
+	if a.add == "0" {
+		a.add = ""
+	}
+	cond := "."
+	if len(f) > 4 {
+		cond = f[4]
+	}
+	if cond != "." {
+		expr := cond + "$"
+		if f[0] == "PFX" {
+			expr = "^" + cond
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %v", cond, err)
+		}
+		a.cond = re
+	}
+	return a, nil
+}
+
+// parseFlags splits the flags of a word or affix as the FLAG type says.
+func (d *Dictionary) parseFlags(s string) []string {
+	var flags []string
+	switch d.flagType {
+	case "long":
+		for len(s) > 0 {
+			_, n1 := utf8.DecodeRuneInString(s)
+			_, n2 := utf8.DecodeRuneInString(s[n1:])
+			flags = append(flags, s[:n1+n2])
+			s = s[n1+n2:]
+		}
+	case "num":
+		for _, n := range strings.Split(s, ",") {
+			if _, err := strconv.Atoi(n); err == nil {
+				flags = append(flags, n)
+			}
+		}
+	default: // one character, bytes of ISO8859-1 files are runes here already
+		for _, r := range s {
+			flags = append(flags, string(r))
+		}
+	}
+	return flags
+}
+
+// readDic reads the word list, its first line is the number of words.
+func (d *Dictionary) readDic(path string, decode func(string) string) error {
+	first := true
+	return readLines(path, decode, func(n int, text string) error {
+		if first {
+			first = false
+			if _, err := strconv.Atoi(strings.TrimSpace(text)); err == nil {
+				return nil
+			}
+		}
+		// morphological fields follow after white space.
+		if i := strings.IndexAny(text, "\t "); i >= 0 {
+			text = text[:i]
+		}
+		word, flags := text, ""
+		for i := 0; i < len(text); i++ {
+			if text[i] == '\\' {
+				i++
+				continue
+			}
+			if text[i] == '/' && i > 0 {
+				word, flags = text[:i], text[i+1:]
+				break
+			}
+		}
+		word = strings.ReplaceAll(word, `\/`, "/")
+		d.words[word] = append(d.words[word], d.parseFlags(flags)...)
+		return nil
+	})
+}
+
+func hasFlag(flags []string, flag string) bool {
+	if flag == "" {
+		return false
+	}
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadPersonal reads the personal word list at path, one word per line,
+// AddPersonal appends to it. A missing file is an empty list.
+func (d *Dictionary) LoadPersonal(path string) error {
+	d.mu.Lock()
+	d.personalPath = path
+	d.mu.Unlock()
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return readLines(path, nil, func(n int, text string) error {
+		d.mu.Lock()
+		d.personal[strings.TrimSpace(text)] = true
+		d.mu.Unlock()
+		return nil
+	})
+}
+
+// AddPersonal accepts word from now on and stores it in the personal word list.
+func (d *Dictionary) AddPersonal(word string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.personal[word] {
+		return nil
+	}
+	d.personal[word] = true
+	if d.personalPath == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.personalPath), 0755); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	f, err := os.OpenFile(d.personalPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if _, err := fmt.Fprintln(f, word); err != nil {
+		f.Close()
+		return fmt.Errorf("%v", err)
+	}
+	return f.Close()
+}
+
+// Check reports whether word is spelled correctly. Words starting with a capital
+// letter or in capitals are also found in lower case, like at the start of a sentence.
+func (d *Dictionary) Check(word string) bool {
+	word = strings.ReplaceAll(word, "’", "'")
+	if word == "" || d.checkCase(word) {
+		return true
+	}
+	// mixed case like "mIx" is only found as is.
+	lower := strings.ToLower(word)
+	upper := strings.ToUpper(word) == word
+	if lower == word || (!upper && title(lower) != word) {
+		return false
+	}
+	if d.checkCase(lower) {
+		return true
+	}
+	// "NSM" for "Nsm" in the dictionary.
+	return upper && d.checkCase(title(lower))
+}
+
+func title(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToTitle(r)) + s[n:]
+}
+
+func (d *Dictionary) checkCase(word string) bool {
+	d.mu.Lock()
+	personal := d.personal[word]
+	d.mu.Unlock()
+	if personal {
+		return true
+	}
+	if flags, ok := d.words[word]; ok {
+		if hasFlag(flags, d.forbidden) {
+			return false
+		}
+		if !hasFlag(flags, d.needAffix) {
+			return true
+		}
+	}
+	return d.checkPrefixed(word) || d.checkSuffixed(word, nil)
+}
+
+// checkSuffixed looks for a stem of word with a suffix rule. With prefix set,
+// the word had that prefix removed already and both rules have to cross.
+func (d *Dictionary) checkSuffixed(word string, prefix *affix) bool {
+	for i := 0; i <= len(word); i++ {
+		for _, a := range d.suffixes[word[i:]] {
+			if prefix != nil && !(a.cross && prefix.cross) {
+				continue
+			}
+			stem := word[:i] + a.strip
+			if stem == "" || (a.cond != nil && !a.cond.MatchString(stem)) {
+				continue
+			}
+			flags, ok := d.words[stem]
+			if !ok || hasFlag(flags, d.forbidden) || !hasFlag(flags, a.flag) {
+				continue
+			}
+			// a prefix has to be allowed by the stem or by the suffix.
+			if prefix != nil && !hasFlag(flags, prefix.flag) && !hasFlag(a.flags, prefix.flag) {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dictionary) checkPrefixed(word string) bool {
+	for i := 0; i <= len(word); i++ {
+		for _, a := range d.prefixes[word[:i]] {
+			stem := a.strip + word[i:]
+			if stem == "" || (a.cond != nil && !a.cond.MatchString(stem)) {
+				continue
+			}
+			if flags, ok := d.words[stem]; ok && !hasFlag(flags, d.forbidden) && hasFlag(flags, a.flag) {
+				return true
+			}
+			if a.cross && d.checkSuffixed(stem, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package spell
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testAff = `SET UTF-8
+TRY esianrtolcdugmphbyfvkwz
+NEEDAFFIX X
+FORBIDDENWORD F
+REP 1
+REP f ph
+
+PFX A Y 1
+PFX A 0 re .
+
+SFX S Y 3
+SFX S y ies [^aeiou]y
+SFX S 0 s [aeiou]y
+SFX S 0 s [^y]
+
+SFX D N 1
+SFX D 0 ed .
+`
+
+const testDic = `8
+mix/ADS
+track/S
+body/S
+key/S
+phone
+NSM
+Ardour
+reverbs/F
+`
+
+func newTestDictionary(t *testing.T, aff, dic string) *Dictionary {
+	t.Helper()
+	dir := t.TempDir()
+	base := filepath.Join(dir, "xx_XX")
+	if err := os.WriteFile(base+".aff", []byte(aff), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(base+".dic", []byte(dic), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestCheck(t *testing.T) {
+	d := newTestDictionary(t, testAff, testDic)
+	for word, want := range map[string]bool{
+		"mix":      true,
+		"mixs":     true,
+		"remix":    true,
+		"remixs":   true, // prefix and suffix cross
+		"remixed":  false,
+		"mixed":    true,
+		"bodies":   true,
+		"bodys":    false,
+		"keys":     true,
+		"keies":    false,
+		"retrack":  false, // track has no A
+		"Mix":      true,
+		"MIX":      true,
+		"mIx":      false,
+		"Nsm":      false,
+		"NSM":      true,
+		"nsm":      false,
+		"ardour":   false,
+		"ARDOUR":   true,
+		"reverbs":  false,
+		"fone":     false,
+		"phone’s":  false,
+		"tracks":   true,
+		"trackses": false,
+	} {
+		if got := d.Check(word); got != want {
+			t.Errorf("Check(%q) = %v, want %v", word, got, want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	d := newTestDictionary(t, testAff, testDic)
+	for word, want := range map[string][]string{
+		"fone":     {"phone"},
+		"trakc":    {"track"},
+		"Mixx":     {"Mix", "Mixs"},
+		"bodyies":  {"bodies"},
+		"trackkey": {"track key"},
+	} {
+		if got := d.Suggest(word, 3); !reflect.DeepEqual(got, want) {
+			t.Errorf("Suggest(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+func TestFlagTypes(t *testing.T) {
+	long := newTestDictionary(t, "FLAG long\nSFX Ab Y 1\nSFX Ab 0 s .\n", "1\ntrack/Ab\n")
+	num := newTestDictionary(t, "FLAG num\nSFX 12 Y 1\nSFX 12 0 s .\n", "1\ntrack/3,12\n")
+	for _, d := range []*Dictionary{long, num} {
+		if !d.Check("tracks") || d.Check("trackss") {
+			t.Errorf("flag type %s: tracks not checked with the suffix", d.flagType)
+		}
+	}
+}
+
+func TestLatin1(t *testing.T) {
+	d := newTestDictionary(t, "SET ISO8859-1\nSFX S Y 1\nSFX S 0 s .\n", "1\ncaf\xe9/S\n")
+	if !d.Check("café") || !d.Check("cafés") {
+		t.Error("ISO8859-1 words not decoded")
+	}
+}
+
+func TestPersonal(t *testing.T) {
+	d := newTestDictionary(t, testAff, testDic)
+	path := filepath.Join(t.TempDir(), "spell", "words.txt")
+	if err := d.LoadPersonal(path); err != nil {
+		t.Fatal(err)
+	}
+	if d.Check("Carla") {
+		t.Fatal("Carla checked before it was added")
+	}
+	if err := d.AddPersonal("Carla"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Check("Carla") {
+		t.Fatal("added word not checked")
+	}
+
+	again := newTestDictionary(t, testAff, testDic)
+	if err := again.LoadPersonal(path); err != nil {
+		t.Fatal(err)
+	}
+	if !again.Check("Carla") || !again.Check("CARLA") {
+		t.Fatal("personal word list not read")
+	}
+}
+
+func TestWords(t *testing.T) {
+	text := "Carla's rack: reverb preset B2 see ![x](a.png) and {#client-nABCD} it’s\n-- mix_down"
+	var got []string
+	for _, w := range Words(text) {
+		if text[w.Start:w.End] != w.Text {
+			t.Fatalf("%q at %d:%d", w.Text, w.Start, w.End)
+		}
+		got = append(got, w.Text)
+	}
+	want := []string{"Carla's", "rack", "reverb", "preset", "see", "and", "it’s"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Words = %q, want %q", got, want)
+	}
+}
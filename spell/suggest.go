@@ -0,0 +1,114 @@
+package spell
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const defaultTry = "esianrtolcdugmphbyfvkwzESIANRTOLCDUGMPHBYFVKWZ'"
+
+// Suggest returns up to max correctly spelled words close to word: the REP
+// replacements of the dictionary first, then the words one edit away, with
+// the letters of its TRY line.
+func (d *Dictionary) Suggest(word string, max int) []string {
+	var (
+		found []string
+		seen  = map[string]bool{word: true}
+	)
+	add := func(s string) bool {
+		if seen[s] {
+			return false
+		}
+		seen[s] = true
+		if !d.checkWords(s) {
+			return false
+		}
+		found = append(found, s)
+		return len(found) >= max
+	}
+
+	for _, rep := range d.rep {
+		for i := strings.Index(word, rep[0]); i >= 0; {
+			if add(word[:i] + rep[1] + word[i+len(rep[0]):]) {
+				return d.matchCase(word, found)
+			}
+			next := strings.Index(word[i+1:], rep[0])
+			if next < 0 {
+				break
+			}
+			i += 1 + next
+		}
+	}
+
+	try := d.try
+	if try == "" {
+		try = defaultTry
+	}
+	runes := []rune(word)
+	for _, candidate := range edits(runes, []rune(try)) {
+		if add(candidate) {
+			break
+		}
+	}
+	return d.matchCase(word, found)
+}
+
+// checkWords checks every word of s, the REP replacements and the edits can split words.
+func (d *Dictionary) checkWords(s string) bool {
+	for _, w := range strings.Fields(s) {
+		if !d.Check(w) {
+			return false
+		}
+	}
+	return s != "" && strings.TrimSpace(s) == s
+}
+
+// edits are the words one edit away from word, the more likely typos first:
+// swapped letters, an extra letter, a wrong letter, a missing letter and a missing space.
+func edits(word, try []rune) []string {
+	var out []string
+	for i := 0; i+1 < len(word); i++ {
+		w := append([]rune(nil), word...)
+		w[i], w[i+1] = w[i+1], w[i]
+		out = append(out, string(w))
+	}
+	for i := range word {
+		out = append(out, string(word[:i])+string(word[i+1:]))
+	}
+	for i := range word {
+		for _, r := range try {
+			if r != word[i] {
+				w := append([]rune(nil), word...)
+				w[i] = r
+				out = append(out, string(w))
+			}
+		}
+	}
+	for i := 0; i <= len(word); i++ {
+		for _, r := range try {
+			out = append(out, string(word[:i])+string(r)+string(word[i:]))
+		}
+	}
+	for i := 1; i < len(word); i++ {
+		out = append(out, string(word[:i])+" "+string(word[i:]))
+	}
+	return out
+}
+
+// matchCase capitalizes the suggestions for a capitalized word.
+func (d *Dictionary) matchCase(word string, found []string) []string {
+	r, _ := utf8.DecodeRuneInString(word)
+	if !unicode.IsUpper(r) {
+		return found
+	}
+	upper := strings.ToUpper(word) == word && utf8.RuneCountInString(word) > 1
+	for i, s := range found {
+		if upper {
+			found[i] = strings.ToUpper(s)
+		} else {
+			found[i] = title(s)
+		}
+	}
+	return found
+}
@@ -0,0 +1,77 @@
+package spell
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Word is a word of a text, Start and End are byte offsets.
+type Word struct {
+	Start, End int
+	Text       string
+}
+
+// Words splits text into the words to check. Tokens with digits, paths, links,
+// file names, addresses and markup like "{#client-nABCD}" are skipped, an
+// apostrophe inside a word belongs to it.
+func Words(text string) []Word {
+	var words []Word
+	pos := 0
+	for _, token := range strings.FieldsFunc(text, unicode.IsSpace) {
+		start := pos + strings.Index(text[pos:], token)
+		pos = start + len(token)
+		if strings.ContainsAny(token, "/\\@#_{}<>=") || strings.Contains(token, "](") ||
+			strings.IndexFunc(token, unicode.IsDigit) >= 0 || innerDot(token) {
+			continue
+		}
+		words = append(words, tokenWords(token, start)...)
+	}
+	return words
+}
+
+func tokenWords(token string, offset int) []Word {
+	var (
+		words []Word
+		start = -1
+	)
+	for i, r := range token {
+		if unicode.IsLetter(r) || unicode.Is(unicode.Mn, r) || (start >= 0 && isApostrophe(r) && letterAfter(token, i)) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			words = append(words, Word{offset + start, offset + i, token[start:i]})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, Word{offset + start, offset + len(token), token[start:]})
+	}
+	return words
+}
+
+func isApostrophe(r rune) bool {
+	return r == '\'' || r == '’'
+}
+
+func letterAfter(s string, i int) bool {
+	_, n := utf8.DecodeRuneInString(s[i:])
+	r, _ := utf8.DecodeRuneInString(s[i+n:])
+	return unicode.IsLetter(r)
+}
+
+// innerDot reports a dot between letters, like in "take.wav" or "example.org".
+func innerDot(token string) bool {
+	for i := 1; i+1 < len(token); i++ {
+		if token[i] == '.' && letterAfter(token, i) {
+			r, _ := utf8.DecodeLastRuneInString(token[:i])
+			if unicode.IsLetter(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/spell"
+)
+
+// the style buffer holds one of these per byte of the text. go-fltk doesn't pass
+// the underline attribute of the style table, misspellings are drawn in red.
+const (
+	styleText        = 'A'
+	styleMisspelling = 'B'
+)
+
+type spellLoad struct {
+	dict *spell.Dictionary
+	err  error
+}
+
+type spellChecker struct {
+	dict     *spell.Dictionary
+	loaded   chan spellLoad
+	style    *fltk.TextBuffer
+	menu     *fltk.MenuButton
+	enabled  bool
+	from, to int // the text changed since the last check, from is -1 when it didn't
+}
+
+// buildSpellCheck sets the style buffer of the editor, it is kept as long as
+// the text from the start, the dictionary is loaded later.
+func (a *app) buildSpellCheck() {
+	s := &spellChecker{style: fltk.NewTextBuffer(), from: -1}
+	a.TextBuffer.AddModifyCallback(func(pos, inserted, deleted, restyled int, deletedText string) {
+		if inserted == 0 && deleted == 0 {
+			return
+		}
+		s.style.ReplaceRange(pos, pos+deleted, strings.Repeat(string(rune(styleText)), inserted))
+		s.changed(pos, inserted, deleted)
+	})
+
+	s.menu = fltk.NewMenuButton(0, 0, 0, 0)
+	s.menu.SetType(fltk.POPUP3)
+	s.menu.Hide()
+
+	a.spell = s
+	a.applySpellStyles()
+}
+
+// changed adds an edit to the range to check again.
+func (s *spellChecker) changed(pos, inserted, deleted int) {
+	if s.from < 0 {
+		s.from, s.to = pos, pos+inserted
+		return
+	}
+	if s.to > pos {
+		s.to = max(pos, s.to+inserted-deleted)
+	}
+	s.from = min(s.from, pos)
+	s.to = max(s.to, pos+inserted)
+}
+
+// applySpellStyles follows the editor font, the style table overrides it.
+func (a *app) applySpellStyles() {
+	if a.spell == nil {
+		return
+	}
+	font, size := a.TextEditor.TextFont(), a.TextEditor.TextSize()
+	a.TextEditor.SetHighlightData(a.spell.style, []fltk.StyleTableEntry{
+		{Color: a.TextEditor.TextColor(), Font: font, Size: size},
+		{Color: fltk.RED, Font: font, Size: size},
+	})
+}
+
+// startSpellCheck loads the dictionary in the background, checkSpelling picks it up.
+func (a *app) startSpellCheck() {
+	cfg, err := loadSpellConfig(configFile(settingsFileName))
+	if err != nil {
+		a.logf("%v", err)
+	}
+	if !cfg.Enabled {
+		return
+	}
+	a.spell.enabled = true
+	a.spell.loaded = make(chan spellLoad, 1)
+	go func() {
+		base := cfg.Dictionary
+		if base == "" {
+			var err error
+			if base, err = spell.Find(cfg.Language); err != nil {
+				a.spell.loaded <- spellLoad{err: err}
+				return
+			}
+		}
+		d, err := spell.Load(base)
+		if err == nil {
+			err = d.LoadPersonal(configFile(personalWordsFileName))
+		}
+		a.spell.loaded <- spellLoad{d, err}
+	}()
+}
+
+// checkSpelling is polled from the main loop, it checks the lines changed since
+// the last call.
+func (a *app) checkSpelling() {
+	s := a.spell
+	if s == nil {
+		return
+	}
+	select {
+	case l := <-s.loaded:
+		if l.err != nil {
+			a.logf("spell check: %v", l.err)
+			break
+		}
+		s.dict = l.dict
+		s.from, s.to = 0, a.TextBuffer.Length()
+	default:
+	}
+	if s.dict == nil || s.from < 0 {
+		return
+	}
+
+	text := a.TextBuffer.Text()
+	from, to := min(s.from, len(text)), min(s.to, len(text))
+	s.from = -1
+	from = strings.LastIndexByte(text[:from], '\n') + 1
+	if i := strings.IndexByte(text[to:], '\n'); i >= 0 {
+		to += i
+	} else {
+		to = len(text)
+	}
+
+	styles := []byte(strings.Repeat(string(rune(styleText)), to-from))
+	if s.enabled {
+		for _, w := range spell.Words(text[from:to]) {
+			if !s.dict.Check(w.Text) {
+				for i := w.Start; i < w.End; i++ {
+					styles[i] = styleMisspelling
+				}
+			}
+		}
+	}
+	if s.style.GetTextRange(from, to) != string(styles) {
+		s.style.ReplaceRange(from, to, string(styles))
+		a.TextEditor.Redraw()
+	}
+}
+
+func (a *app) toggleSpellCheck() {
+	s := a.spell
+	if s.loaded == nil {
+		a.startSpellCheck()
+		if s.loaded == nil {
+			a.logf("spell check is disabled in %s", configFile(settingsFileName))
+		}
+		return
+	}
+	s.enabled = !s.enabled
+	s.from, s.to = 0, a.TextBuffer.Length()
+}
+
+// handleSpellClick offers the suggestions for the misspelled word under the
+// mouse, it returns false for correct words.
+func (a *app) handleSpellClick() bool {
+	s := a.spell
+	if s == nil || s.dict == nil || !s.enabled {
+		return false
+	}
+	pos := a.TextEditor.XYToPosition(fltk.EventX(), fltk.EventY())
+	text := a.TextBuffer.Text()
+	if pos < 0 || pos > len(text) {
+		return false
+	}
+	start := strings.LastIndexByte(text[:pos], '\n') + 1
+	var word spell.Word
+	for _, w := range spell.Words(strings.SplitN(text[start:], "\n", 2)[0]) {
+		if start+w.Start <= pos && pos <= start+w.End {
+			word = w
+		}
+	}
+	if word.Text == "" || s.dict.Check(word.Text) {
+		return false
+	}
+	from, to := start+word.Start, start+word.End
+
+	for s.menu.Size() > 0 {
+		s.menu.Remove(0)
+	}
+	suggestions := s.dict.Suggest(word.Text, maxSpellSuggestions)
+	for i, suggestion := range suggestions {
+		suggestion := suggestion
+		flags := 0
+		if i == len(suggestions)-1 {
+			flags = fltk.MENU_DIVIDER
+		}
+		s.menu.AddEx(suggestion, 0, func() {
+			a.TextBuffer.ReplaceRange(from, to, suggestion)
+			a.setAppDirty()
+		}, flags)
+	}
+	s.menu.Add("Add \""+word.Text+"\" to the dictionary", func() {
+		if err := s.dict.AddPersonal(word.Text); err != nil {
+			a.logf("%v", err)
+		}
+		s.from, s.to = 0, a.TextBuffer.Length()
+	})
+	s.menu.Popup()
+	return true
+}
+
+// spellLanguage is the language of the LANG locale, like "en_US".
+func spellLanguage() string {
+	lang, _, _ := strings.Cut(os.Getenv("LANG"), ".")
+	lang, _, _ = strings.Cut(lang, "@")
+	if lang == "" || lang == "C" || lang == "POSIX" {
+		return defaultSpellLanguage
+	}
+	return lang
+}
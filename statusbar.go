@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+// statusBar shows the NSM connection and the state of the notes, the tooltip has the details.
+type statusBar struct {
+	box      *fltk.Box
+	lastSave time.Time
+	lastErr  error
+}
+
+func (a *app) buildStatusBar() {
+	a.status.box = fltk.NewBox(fltk.NO_BOX, 0, 0, widgetWidth-widgetPaddingWidth, statusBarHeight)
+	a.status.box.SetLabelSize(statusBarLabelSize)
+	a.status.box.SetAlign(fltk.ALIGN_LEFT | fltk.ALIGN_INSIDE | fltk.ALIGN_CLIP)
+}
+
+// setStatusError shows an error until the next successful save or open.
+func (a *app) setStatusError(err error) {
+	a.logf("%v", err)
+	a.status.lastErr = err
+	a.updateStatus()
+}
+
+func (a *app) clearStatusError() {
+	a.status.lastErr = nil
+	a.updateStatus()
+}
+
+func (a *app) setStatusSaved() {
+	a.status.lastSave = time.Now()
+	a.clearStatusError()
+}
+
+// shorten cuts s to n characters for the status bar, the tooltip has all of it.
+func shorten(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+func (a *app) updateStatus() {
+	if a.status.box == nil {
+		return
+	}
+
+	state := "saved"
+	if a.appIsDirty {
+		state = "unsaved changes"
+	}
+	if a.locked {
+		state = "locked"
+	} else if a.key != nil {
+		state += ", encrypted"
+	}
+	lastSave := "never"
+	if !a.status.lastSave.IsZero() {
+		lastSave = a.status.lastSave.Format("15:04:05")
+	}
+	server := a.NsmGetSessionManagerName()
+	if !a.NsmIsActive() {
+		server = "not connected"
+	}
+
+	major, minor := a.NsmGetApiVersion()
+	capabilities := strings.ReplaceAll(strings.Trim(a.NsmGetSessionManagerFeatures(), ":"), ":", " ")
+	if a.NsmIsActive() {
+		server = fmt.Sprintf("%s %d.%d [%s]", server, major, minor, shorten(capabilities, statusFieldLength))
+	}
+
+	label := fmt.Sprintf("%s | %s (%s) | %s, last save %s",
+		server, shorten(a.NsmGetDisplayName(), statusFieldLength), a.NsmGetClientId(), state, lastSave)
+	a.status.box.SetLabelColor(fltk.FOREGROUND_COLOR)
+	if a.status.lastErr != nil {
+		label += " | error: " + shorten(a.status.lastErr.Error(), statusFieldLength)
+		a.status.box.SetLabelColor(fltk.RED)
+	}
+	// a @ starts a symbol in fltk labels
+	a.status.box.SetLabel(strings.ReplaceAll(label, "@", "@@"))
+
+	tooltip := fmt.Sprintf("Session manager: %s (API %d.%d)\nWelcome: %s\nCapabilities: %s\nClient ID: %s\nDisplay name: %s\nState: %s\nLast save: %s\nEsc to hide",
+		server, major, minor, a.NsmGetServerWelcome(), a.NsmGetSessionManagerFeatures(), a.NsmGetClientId(), a.NsmGetDisplayName(), state, lastSave)
+	if a.status.lastErr != nil {
+		tooltip += "\nLast error: " + a.status.lastErr.Error()
+	}
+	a.status.box.SetTooltip(tooltip)
+	a.status.box.Redraw()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"nsm-notes/transport"
+)
+
+// startTransportListener listens for the DAW position when transport.listen is configured.
+func (a *app) startTransportListener() {
+	cfg, err := loadTransportConfig(configFile(settingsFileName))
+	if err != nil {
+		a.logf("%v", err)
+		return
+	}
+	if cfg.Listen == "" {
+		return
+	}
+
+	l, err := transport.Listen(cfg)
+	if err != nil {
+		a.logf("transport listener: %v", err)
+		return
+	}
+	a.transport = l
+}
+
+// insertTimestamp writes the last DAW position at the cursor, "at 02:13.5: ".
+func (a *app) insertTimestamp() {
+	if a.transport == nil {
+		a.logf("no transport listener, set transport.listen in %s", configFile(settingsFileName))
+		return
+	}
+	pos, ok := a.transport.Position()
+	if !ok {
+		a.logf("no transport position received on %v", a.transport.Addr())
+		return
+	}
+	a.TextEditor.InsertText("at " + pos.String() + ": ")
+	a.setAppDirty()
+}
@@ -0,0 +1,146 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// oscMessage is a decoded OSC message. The osc library used by nsmclient can't read
+// the 64 bit types ('h', 'd', 't') that DAWs use for sample positions, so the listener
+// decodes the few types it needs itself.
+type oscMessage struct {
+	address string
+	args    []interface{}
+}
+
+// decodePacket decodes a message or a (nested) bundle into its messages.
+func decodePacket(b []byte) ([]oscMessage, error) {
+	if bytes.HasPrefix(b, []byte("#bundle\x00")) {
+		return decodeBundle(b)
+	}
+	msg, err := decodeMessage(b)
+	if err != nil {
+		return nil, err
+	}
+	return []oscMessage{msg}, nil
+}
+
+func decodeBundle(b []byte) ([]oscMessage, error) {
+	const headerLen = 16 // "#bundle\0" and the time tag
+	if len(b) < headerLen {
+		return nil, fmt.Errorf("short bundle")
+	}
+	var msgs []oscMessage
+	for rest := b[headerLen:]; len(rest) > 0; {
+		if len(rest) < 4 {
+			return nil, fmt.Errorf("short bundle element")
+		}
+		n := int(binary.BigEndian.Uint32(rest))
+		rest = rest[4:]
+		if n < 0 || n > len(rest) {
+			return nil, fmt.Errorf("bundle element size %d out of range", n)
+		}
+		m, err := decodePacket(rest[:n])
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m...)
+		rest = rest[n:]
+	}
+	return msgs, nil
+}
+
+func decodeMessage(b []byte) (oscMessage, error) {
+	var msg oscMessage
+
+	address, b, err := readString(b)
+	if err != nil {
+		return msg, err
+	}
+	msg.address = address
+	if len(b) == 0 {
+		return msg, nil // no type tags at all, seen from old senders
+	}
+
+	tags, b, err := readString(b)
+	if err != nil {
+		return msg, err
+	}
+	if len(tags) == 0 || tags[0] != ',' {
+		return msg, fmt.Errorf("bad type tags %q", tags)
+	}
+
+	for _, tag := range tags[1:] {
+		switch tag {
+		case 'i':
+			if len(b) < 4 {
+				return msg, fmt.Errorf("short int32")
+			}
+			msg.args = append(msg.args, int32(binary.BigEndian.Uint32(b)))
+			b = b[4:]
+		case 'f':
+			if len(b) < 4 {
+				return msg, fmt.Errorf("short float32")
+			}
+			msg.args = append(msg.args, math.Float32frombits(binary.BigEndian.Uint32(b)))
+			b = b[4:]
+		case 'h', 't':
+			if len(b) < 8 {
+				return msg, fmt.Errorf("short int64")
+			}
+			msg.args = append(msg.args, int64(binary.BigEndian.Uint64(b)))
+			b = b[8:]
+		case 'd':
+			if len(b) < 8 {
+				return msg, fmt.Errorf("short float64")
+			}
+			msg.args = append(msg.args, math.Float64frombits(binary.BigEndian.Uint64(b)))
+			b = b[8:]
+		case 's', 'S':
+			var s string
+			if s, b, err = readString(b); err != nil {
+				return msg, err
+			}
+			msg.args = append(msg.args, s)
+		case 'b':
+			if len(b) < 4 {
+				return msg, fmt.Errorf("short blob")
+			}
+			n := int(binary.BigEndian.Uint32(b))
+			b = b[4:]
+			if n < 0 || pad4(n) > len(b) {
+				return msg, fmt.Errorf("blob size %d out of range", n)
+			}
+			msg.args = append(msg.args, b[:n])
+			b = b[pad4(n):]
+		case 'T':
+			msg.args = append(msg.args, true)
+		case 'F':
+			msg.args = append(msg.args, false)
+		case 'N', 'I':
+			msg.args = append(msg.args, nil)
+		default:
+			return msg, fmt.Errorf("unsupported type tag %q", tag)
+		}
+	}
+	return msg, nil
+}
+
+// readString reads a null terminated string padded to 4 bytes.
+func readString(b []byte) (string, []byte, error) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return "", nil, fmt.Errorf("unterminated string")
+	}
+	n := pad4(i + 1)
+	if n > len(b) {
+		n = len(b)
+	}
+	return string(b[:i]), b[n:], nil
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,196 @@
+// Package transport listens for the transport position a DAW sends over OSC,
+// so notes can be stamped with the current song position.
+package transport
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatFrame    Format = "frame"    // sample position, int or float, e.g. Ardour's /transport_frame
+	FormatSeconds  Format = "seconds"  // position in seconds
+	FormatTimecode Format = "timecode" // a string like Ardour's /position/smpte "00:02:13:12"
+)
+
+const (
+	DefaultAddress    = "/transport_frame"
+	DefaultSampleRate = 48000
+)
+
+type Config struct {
+	Listen     string // udp address to listen on, e.g. "127.0.0.1:9999"
+	Address    string // OSC address carrying the position
+	Format     Format
+	SampleRate int // for FormatFrame
+}
+
+// Position is the last position received.
+type Position struct {
+	Seconds  float64
+	Timecode string // set for FormatTimecode, shown as is
+	Received time.Time
+}
+
+// String formats the position as "02:13.5", or "1:02:13.5" past an hour.
+func (p Position) String() string {
+	if p.Timecode != "" {
+		return p.Timecode
+	}
+	tenths := int64(math.Round(p.Seconds * 10))
+	if tenths < 0 {
+		tenths = 0
+	}
+	h, m, s, t := tenths/36000, tenths/600%60, tenths/10%60, tenths%10
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d.%d", h, m, s, t)
+	}
+	return fmt.Sprintf("%02d:%02d.%d", m, s, t)
+}
+
+type Listener struct {
+	cfg  Config
+	conn *net.UDPConn
+
+	mu   sync.Mutex
+	pos  Position
+	have bool
+	err  error
+}
+
+// Listen starts a goroutine that receives positions on cfg.Listen.
+func Listen(cfg Config) (*Listener, error) {
+	if cfg.Address == "" {
+		cfg.Address = DefaultAddress
+	}
+	if cfg.Format == "" {
+		cfg.Format = FormatFrame
+	}
+	if cfg.SampleRate <= 0 {
+		cfg.SampleRate = DefaultSampleRate
+	}
+	switch cfg.Format {
+	case FormatFrame, FormatSeconds, FormatTimecode:
+	default:
+		return nil, fmt.Errorf("unknown transport format: %s", cfg.Format)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+
+	l := &Listener{cfg: cfg, conn: conn}
+	go l.run()
+	return l, nil
+}
+
+// Addr is the address the listener is bound to.
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Position returns the last position, false if none was received yet.
+func (l *Listener) Position() (Position, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pos, l.have
+}
+
+// Err returns the last decoding error, if any. Bad packets don't stop the listener.
+func (l *Listener) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+func (l *Listener) Close() error {
+	return l.conn.Close()
+}
+
+// goroutine
+func (l *Listener) run() {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			return // closed
+		}
+		msgs, err := decodePacket(buf[:n])
+		if err != nil {
+			l.setErr(err)
+			continue
+		}
+		for _, msg := range msgs {
+			if msg.address != l.cfg.Address {
+				continue
+			}
+			pos, err := l.position(msg)
+			if err != nil {
+				l.setErr(err)
+				continue
+			}
+			l.mu.Lock()
+			l.pos, l.have = pos, true
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (l *Listener) setErr(err error) {
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+}
+
+func (l *Listener) position(msg oscMessage) (Position, error) {
+	pos := Position{Received: time.Now()}
+	if len(msg.args) == 0 {
+		return pos, fmt.Errorf("%s: no arguments", msg.address)
+	}
+
+	if l.cfg.Format == FormatTimecode {
+		s, ok := msg.args[0].(string)
+		if !ok {
+			return pos, fmt.Errorf("%s: expected a string", msg.address)
+		}
+		pos.Timecode = s
+		return pos, nil
+	}
+
+	var v float64
+	switch a := msg.args[0].(type) {
+	case int32:
+		v = float64(a)
+	case int64:
+		v = float64(a)
+	case float32:
+		v = float64(a)
+	case float64:
+		v = a
+	case string:
+		f, err := strconv.ParseFloat(a, 64)
+		if err != nil {
+			return pos, fmt.Errorf("%s: %v", msg.address, err)
+		}
+		v = f
+	default:
+		return pos, fmt.Errorf("%s: expected a number", msg.address)
+	}
+
+	if l.cfg.Format == FormatFrame {
+		v /= float64(l.cfg.SampleRate)
+	}
+	pos.Seconds = v
+	return pos, nil
+}
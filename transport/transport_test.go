@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/scgolang/osc"
+)
+
+// waitPosition polls until the listener has a position or the test times out.
+func waitPosition(t *testing.T, l *Listener, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if pos, ok := l.Position(); ok && pos.String() == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	pos, _ := l.Position()
+	t.Fatalf("position = %q, want %q (err %v)", pos.String(), want, l.Err())
+}
+
+func newListener(t *testing.T, cfg Config) (*Listener, *osc.UDPConn) {
+	t.Helper()
+	cfg.Listen = "127.0.0.1:0"
+	l, err := Listen(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	sender, err := osc.DialUDP("udp", nil, l.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sender.Close() })
+	return l, sender
+}
+
+func TestFrame(t *testing.T) {
+	l, sender := newListener(t, Config{SampleRate: 48000})
+
+	if err := sender.Send(osc.Message{Address: "/other", Arguments: osc.Arguments{osc.Int(1)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(osc.Message{Address: DefaultAddress, Arguments: osc.Arguments{osc.Int(48000*133 + 24000)}}); err != nil {
+		t.Fatal(err)
+	}
+	waitPosition(t, l, "02:13.5")
+}
+
+func TestFrameInt64(t *testing.T) {
+	l, sender := newListener(t, Config{SampleRate: 44100})
+
+	// osc.Message can't hold an int64, build the packet by hand like Ardour sends it.
+	packet := append([]byte("/transport_frame\x00\x00\x00\x00,h\x00\x00"), make([]byte, 8)...)
+	binary.BigEndian.PutUint64(packet[len(packet)-8:], 44100*3725)
+	if _, err := sender.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+	waitPosition(t, l, "1:02:05.0")
+}
+
+func TestTimecode(t *testing.T) {
+	l, sender := newListener(t, Config{Address: "/position/smpte", Format: FormatTimecode})
+
+	if err := sender.Send(osc.Message{Address: "/position/smpte", Arguments: osc.Arguments{osc.String("00:02:13:12")}}); err != nil {
+		t.Fatal(err)
+	}
+	waitPosition(t, l, "00:02:13:12")
+}
+
+func TestBadPacketKeepsListening(t *testing.T) {
+	l, sender := newListener(t, Config{Format: FormatSeconds})
+
+	if _, err := sender.Write([]byte("/transport_frame\x00\x00\x00\x00,x\x00\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(osc.Message{Address: DefaultAddress, Arguments: osc.Arguments{osc.Float(7.25)}}); err != nil {
+		t.Fatal(err)
+	}
+	waitPosition(t, l, "00:07.3")
+	if l.Err() == nil {
+		t.Error("expected a decoding error for the bad packet")
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := Listen(Config{Listen: "127.0.0.1:0", Format: "bars"}); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,204 @@
+// Package undo keeps the edit history of a text for undo and redo, independent
+// of the widget, so it can be stored and restored with the text.
+package undo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	DefaultLevels = 500         // undo steps kept
+	GroupTimeout  = time.Second // typing pauses longer than this start a new step
+	formatVersion = 1
+)
+
+var ErrOtherText = errors.New("the undo history belongs to another text")
+
+// Edit replaced Deleted at Pos with Inserted, positions are byte offsets.
+type Edit struct {
+	Pos      int    `json:"pos"`
+	Inserted string `json:"ins,omitempty"`
+	Deleted  string `json:"del,omitempty"`
+}
+
+// step is what one undo reverts, the edits in the order they were made.
+type step []Edit
+
+// History records the edits of a text. Typing and deleting one character after
+// the other are grouped into one step until a pause or the end of a word.
+type History struct {
+	undo, redo []step
+	levels     int
+	last       time.Time // of the last recorded edit, for grouping
+	grouping   bool      // the last step may be extended
+}
+
+func New(levels int) *History {
+	if levels <= 0 {
+		levels = DefaultLevels
+	}
+	return &History{levels: levels}
+}
+
+// Record adds an edit made to the text at time now. The redo steps are dropped.
+func (h *History) Record(e Edit, now time.Time) {
+	if e.Inserted == "" && e.Deleted == "" {
+		return
+	}
+	h.redo = nil
+	if h.grouping && now.Sub(h.last) < GroupTimeout && len(h.undo) > 0 {
+		s := h.undo[len(h.undo)-1]
+		if merged, ok := merge(s[len(s)-1], e); ok {
+			s[len(s)-1] = merged
+			h.last = now
+			h.grouping = !wordEnd(e)
+			return
+		}
+	}
+	h.undo = append(h.undo, step{e})
+	if len(h.undo) > h.levels {
+		h.undo = h.undo[len(h.undo)-h.levels:]
+	}
+	h.last = now
+	h.grouping = single(e) && !wordEnd(e)
+}
+
+// Break ends the current step, the next edit starts a new one.
+func (h *History) Break() {
+	h.grouping = false
+}
+
+// single is a typed character or one removed with backspace or delete.
+func single(e Edit) bool {
+	return (utf8.RuneCountInString(e.Inserted) == 1 && e.Deleted == "") ||
+		(utf8.RuneCountInString(e.Deleted) == 1 && e.Inserted == "")
+}
+
+// wordEnd is a typed space or punctuation, the next word is undone separately.
+func wordEnd(e Edit) bool {
+	r, _ := utf8.DecodeLastRuneInString(e.Inserted)
+	return e.Inserted != "" && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// merge joins a typed character to the text typed before it, and a character
+// removed with backspace or delete to the ones removed before.
+func merge(prev, e Edit) (Edit, bool) {
+	if !single(e) {
+		return prev, false
+	}
+	switch {
+	case prev.Deleted == "" && e.Deleted == "" && e.Pos == prev.Pos+len(prev.Inserted):
+		prev.Inserted += e.Inserted
+		return prev, true
+	case prev.Inserted == "" && e.Inserted == "" && e.Pos+len(e.Deleted) == prev.Pos: // backspace
+		return Edit{Pos: e.Pos, Deleted: e.Deleted + prev.Deleted}, true
+	case prev.Inserted == "" && e.Inserted == "" && e.Pos == prev.Pos: // delete
+		prev.Deleted += e.Deleted
+		return prev, true
+	}
+	return prev, false
+}
+
+func (h *History) CanUndo() bool { return len(h.undo) > 0 }
+func (h *History) CanRedo() bool { return len(h.redo) > 0 }
+
+// Undo returns the edits that revert the last step, to be applied in order.
+// They aren't recorded again.
+func (h *History) Undo() []Edit {
+	if len(h.undo) == 0 {
+		return nil
+	}
+	s := h.undo[len(h.undo)-1]
+	h.undo = h.undo[:len(h.undo)-1]
+	h.redo = append(h.redo, s)
+	h.grouping = false
+
+	edits := make([]Edit, 0, len(s))
+	for i := len(s) - 1; i >= 0; i-- {
+		edits = append(edits, Edit{Pos: s[i].Pos, Inserted: s[i].Deleted, Deleted: s[i].Inserted})
+	}
+	return edits
+}
+
+// Redo returns the edits of the last undone step, to be applied in order.
+func (h *History) Redo() []Edit {
+	if len(h.redo) == 0 {
+		return nil
+	}
+	s := h.redo[len(h.redo)-1]
+	h.redo = h.redo[:len(h.redo)-1]
+	h.undo = append(h.undo, s)
+	h.grouping = false
+	return append([]Edit(nil), s...)
+}
+
+// Apply makes e on text, it returns false when the text doesn't have the
+// deleted text at the position.
+func Apply(text string, e Edit) (string, bool) {
+	end := e.Pos + len(e.Deleted)
+	if e.Pos < 0 || end > len(text) || text[e.Pos:end] != e.Deleted {
+		return text, false
+	}
+	return text[:e.Pos] + e.Inserted + text[end:], true
+}
+
+type file struct {
+	Version int      `json:"version"`
+	Text    string   `json:"text"` // sha256 of the text the history ends with
+	Undo    [][]Edit `json:"undo"`
+	Redo    [][]Edit `json:"redo,omitempty"`
+}
+
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// Marshal stores the history of text.
+func (h *History) Marshal(text string) ([]byte, error) {
+	f := file{Version: formatVersion, Text: textHash(text)}
+	for _, s := range h.undo {
+		f.Undo = append(f.Undo, s)
+	}
+	for _, s := range h.redo {
+		f.Redo = append(f.Redo, s)
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	return data, nil
+}
+
+// Unmarshal restores a history stored with text. A history of another text,
+// like notes changed by another program since, gives ErrOtherText.
+func Unmarshal(data []byte, text string, levels int) (*History, error) {
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	if f.Version != formatVersion {
+		return nil, fmt.Errorf("undo history version %d, want %d", f.Version, formatVersion)
+	}
+	if f.Text != textHash(text) {
+		return nil, ErrOtherText
+	}
+	h := New(levels)
+	for _, s := range f.Undo {
+		h.undo = append(h.undo, s)
+	}
+	for _, s := range f.Redo {
+		h.redo = append(h.redo, s)
+	}
+	if len(h.undo) > h.levels {
+		h.undo = h.undo[len(h.undo)-h.levels:]
+	}
+	return h, nil
+}
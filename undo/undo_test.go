@@ -0,0 +1,152 @@
+package undo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// editor applies edits to a text and records them, like the modify callback of
+// the text buffer does.
+type editor struct {
+	t    *testing.T
+	text string
+	h    *History
+	now  time.Time
+}
+
+func newEditor(t *testing.T, text string) *editor {
+	return &editor{t: t, text: text, h: New(0), now: time.Unix(1700000000, 0)}
+}
+
+func (ed *editor) edit(e Edit) {
+	ed.t.Helper()
+	text, ok := Apply(ed.text, e)
+	if !ok {
+		ed.t.Fatalf("edit %+v doesn't apply to %q", e, ed.text)
+	}
+	ed.text = text
+	ed.h.Record(e, ed.now)
+	ed.now = ed.now.Add(100 * time.Millisecond)
+}
+
+func (ed *editor) typeText(pos int, s string) {
+	ed.t.Helper()
+	for _, r := range s {
+		ed.edit(Edit{Pos: pos, Inserted: string(r)})
+		pos += len(string(r))
+	}
+}
+
+func (ed *editor) apply(edits []Edit) {
+	ed.t.Helper()
+	for _, e := range edits {
+		text, ok := Apply(ed.text, e)
+		if !ok {
+			ed.t.Fatalf("undo edit %+v doesn't apply to %q", e, ed.text)
+		}
+		ed.text = text
+	}
+}
+
+func (ed *editor) want(text string) {
+	ed.t.Helper()
+	if ed.text != text {
+		ed.t.Fatalf("text = %q, want %q", ed.text, text)
+	}
+}
+
+func TestTypingIsGroupedByWord(t *testing.T) {
+	ed := newEditor(t, "")
+	ed.typeText(0, "reverb preset")
+	ed.apply(ed.h.Undo())
+	ed.want("reverb ")
+	ed.apply(ed.h.Undo())
+	ed.want("")
+	if ed.h.CanUndo() {
+		t.Fatal("more to undo")
+	}
+
+	ed.apply(ed.h.Redo())
+	ed.apply(ed.h.Redo())
+	ed.want("reverb preset")
+	if ed.h.CanRedo() {
+		t.Fatal("more to redo")
+	}
+}
+
+func TestPauseStartsStep(t *testing.T) {
+	ed := newEditor(t, "")
+	ed.typeText(0, "rev")
+	ed.now = ed.now.Add(GroupTimeout)
+	ed.typeText(3, "erb")
+	ed.apply(ed.h.Undo())
+	ed.want("rev")
+}
+
+func TestDeletesAreGrouped(t *testing.T) {
+	ed := newEditor(t, "preset B2")
+	// backspace from the end, then delete at the start.
+	ed.edit(Edit{Pos: 8, Deleted: "2"})
+	ed.edit(Edit{Pos: 7, Deleted: "B"})
+	ed.h.Break()
+	ed.edit(Edit{Pos: 0, Deleted: "p"})
+	ed.edit(Edit{Pos: 0, Deleted: "r"})
+	ed.want("eset ")
+
+	ed.apply(ed.h.Undo())
+	ed.want("preset ")
+	ed.apply(ed.h.Undo())
+	ed.want("preset B2")
+}
+
+func TestEditDropsRedo(t *testing.T) {
+	ed := newEditor(t, "mix")
+	ed.edit(Edit{Pos: 0, Deleted: "mix", Inserted: "master"}) // a paste over a selection
+	ed.apply(ed.h.Undo())
+	ed.want("mix")
+	ed.edit(Edit{Pos: 3, Inserted: "down"})
+	if ed.h.CanRedo() {
+		t.Fatal("redo kept after an edit")
+	}
+	ed.apply(ed.h.Undo())
+	ed.want("mix")
+}
+
+func TestLevels(t *testing.T) {
+	ed := newEditor(t, "")
+	ed.h = New(2)
+	for i, s := range []string{"a", "b", "c"} {
+		ed.edit(Edit{Pos: i, Inserted: s + s})
+	}
+	ed.apply(ed.h.Undo())
+	ed.apply(ed.h.Undo())
+	ed.want("aa")
+	if ed.h.Undo() != nil {
+		t.Fatal("more steps than levels")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	ed := newEditor(t, "")
+	ed.typeText(0, "reverb preset")
+	ed.apply(ed.h.Undo())
+
+	data, err := ed.h.Marshal(ed.text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Unmarshal(data, ed.text+"changed elsewhere", 0); !errors.Is(err, ErrOtherText) {
+		t.Fatalf("err = %v, want %v", err, ErrOtherText)
+	}
+	h, err := Unmarshal(data, ed.text, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed.h = h
+	ed.apply(ed.h.Redo())
+	ed.want("reverb preset")
+	ed.apply(ed.h.Undo())
+	ed.apply(ed.h.Undo())
+	ed.want("")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/undo"
+)
+
+// undoState replaces the undo of the fltk editor, which has one step and is
+// lost with the client. With undo.persist the history is saved next to the notes.
+type undoState struct {
+	h        *undo.History
+	cfg      undoConfig
+	applying bool // the buffer is changed by undo, redo or a load, not recorded
+}
+
+func (a *app) buildUndo() {
+	cfg, err := loadUndoConfig(configFile(settingsFileName))
+	if err != nil {
+		a.logf("%v", err)
+	}
+	u := &undoState{h: undo.New(cfg.Levels), cfg: cfg}
+	a.TextBuffer.AddModifyCallback(func(pos, inserted, deleted, restyled int, deletedText string) {
+		if u.applying || (inserted == 0 && deleted == 0) {
+			return
+		}
+		u.h.Record(undo.Edit{Pos: pos, Inserted: a.TextBuffer.GetTextRange(pos, pos+inserted), Deleted: deletedText}, time.Now())
+	})
+	a.undo = u
+}
+
+// setNotesText replaces the buffer with notes read from the file. The history
+// starts over, or continues from the stored one when it ends with this text.
+func (a *app) setNotesText(text string) {
+	body := a.splitNotes(text)
+	u := a.undo
+	u.applying = true
+	a.TextBuffer.SetText(body)
+	u.applying = false
+
+	u.h = undo.New(u.cfg.Levels)
+	if err := a.loadUndoHistory(body); err != nil {
+		a.logf("%v", err)
+	}
+}
+
+func (a *app) undoFile() string {
+	return a.fileName + undoSuffix
+}
+
+func (a *app) loadUndoHistory(text string) error {
+	if !a.undo.cfg.Persist || a.fileName == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.undoFile())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("%v", err)
+	}
+	plain, err := a.decodeNotes(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.undoFile(), err)
+	}
+	h, err := undo.Unmarshal([]byte(plain), text, a.undo.cfg.Levels)
+	if errors.Is(err, undo.ErrOtherText) {
+		return nil // the notes were changed without us, the history doesn't fit
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", a.undoFile(), err)
+	}
+	a.undo.h = h
+	return nil
+}
+
+// saveUndoHistory is called after the notes were saved, encrypted notes get an
+// encrypted history.
+func (a *app) saveUndoHistory() error {
+	if !a.undo.cfg.Persist || a.fileName == "" || a.locked {
+		return nil
+	}
+	data, err := a.undo.h.Marshal(a.TextBuffer.Text())
+	if err != nil {
+		return err
+	}
+	if data, err = a.encodeNotes(string(data)); err != nil {
+		return err
+	}
+	if err := os.WriteFile(a.undoFile(), data, 0644); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
+
+func (a *app) undoEdit() {
+	a.applyEdits(a.undo.h.Undo())
+}
+
+func (a *app) redoEdit() {
+	a.applyEdits(a.undo.h.Redo())
+}
+
+func (a *app) applyEdits(edits []undo.Edit) {
+	if len(edits) == 0 || a.locked {
+		return
+	}
+	u := a.undo
+	u.applying = true
+	defer func() { u.applying = false }()
+
+	for _, e := range edits {
+		if a.TextBuffer.GetTextRange(e.Pos, e.Pos+len(e.Deleted)) != e.Deleted {
+			// can't happen as long as every change is recorded.
+			a.logf("undo history doesn't match the notes, it is cleared")
+			u.h = undo.New(u.cfg.Levels)
+			return
+		}
+		a.TextBuffer.ReplaceRange(e.Pos, e.Pos+len(e.Deleted), e.Inserted)
+	}
+	last := edits[len(edits)-1]
+	a.TextEditor.SetInsertPosition(last.Pos + len(last.Inserted))
+	a.TextEditor.ShowInsertPosition()
+	a.setAppDirty()
+}
+
+// handleUndoKey runs undo and redo with their shortcuts before the editor
+// does its own undo.
+func (a *app) handleUndoKey() bool {
+	pressed := fltk.EventState()&(fltk.CTRL|fltk.SHIFT|fltk.ALT|fltk.META) + fltk.EventKey()
+	for id, action := range map[string]func(){"edit.undo": a.undoEdit, "edit.redo": a.redoEdit} {
+		if c := a.commands.lookup(id); c != nil && c.shortcut != 0 && c.shortcut == pressed {
+			action()
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/pwiecz/go-fltk"
+
+	"nsm-notes/viewstate"
+)
+
+func editorFonts() map[string]fltk.Font {
+	return map[string]fltk.Font{
+		"helvetica": fltk.HELVETICA,
+		"courier":   fltk.COURIER,
+		"times":     fltk.TIMES,
+		"screen":    fltk.SCREEN,
+	}
+}
+
+// loadViewState reads the state file, without one the current layout is kept.
+func (a *app) loadViewState() error {
+	v, err := viewstate.Read(a.fileName, a.view)
+	if err != nil {
+		return err
+	}
+	a.view = v
+	a.applyViewState()
+
+	return nil
+}
+
+func (a *app) saveViewState() error {
+	if a.fileName == "" {
+		return nil
+	}
+
+	a.view.X, a.view.Y, a.view.W, a.view.H = a.Win.X(), a.Win.Y(), a.Win.W(), a.Win.H()
+	return viewstate.Write(a.fileName, a.view)
+}
+
+func (a *app) applyViewState() {
+	if a.view.W >= minWidgetWidth && a.view.H >= minWidgetHeight {
+		a.Win.Resize(a.view.X, a.view.Y, a.view.W, a.view.H)
+	}
+
+	if font, ok := editorFonts()[a.view.Font]; ok {
+		a.TextEditor.SetTextFont(font)
+	}
+	a.view.CheckFontSize()
+	a.TextEditor.SetTextSize(a.view.FontSize)
+	a.applySpellStyles()
+
+	if a.view.WrapAtWindow {
+		a.TextEditor.SetWrapMode(fltk.WRAP_AT_BOUNDS)
+	} else {
+		a.TextEditor.SetWrapMode(fltk.WRAP_AT_COLUMN, wrapTextAtLine)
+	}
+
+	a.showClientNotes(a.view.ClientNotes)
+	a.TextEditor.Redraw()
+}
+
+func (a *app) setEditorFont(name string) {
+	a.view.Font = name
+	a.applyViewState()
+}
+
+func (a *app) setEditorFontSize(size int) {
+	a.view.FontSize = size
+	a.applyViewState()
+}
+
+func (a *app) zoomEditor(step int) {
+	if size, ok := a.view.Zoom(step); ok {
+		a.setEditorFontSize(size)
+	}
+}
+
+func (a *app) toggleWrapAtWindow() {
+	a.view.WrapAtWindow = !a.view.WrapAtWindow
+	a.applyViewState()
+}
@@ -0,0 +1,79 @@
+// Package viewstate is the per session layout of the notes window, stored next
+// to the notes file.
+package viewstate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	Suffix          = ".view.json" // the window layout is stored in the notes file name plus this
+	DefaultFontSize = 14
+	MinFontSize     = 6
+	MaxFontSize     = 72
+)
+
+// State is the layout of the notes window, with the editor font and sidebar.
+type State struct {
+	X            int    `json:"x"`
+	Y            int    `json:"y"`
+	W            int    `json:"w"`
+	H            int    `json:"h"`
+	Font         string `json:"font"`
+	FontSize     int    `json:"font_size"`
+	WrapAtWindow bool   `json:"wrap_at_window"`
+	ClientNotes  bool   `json:"client_notes"` // the client notes sidebar is shown
+}
+
+// Name is the state file of the notes in fileName.
+func Name(fileName string) string {
+	return fileName + Suffix
+}
+
+// Read returns the state of the notes in fileName on top of v, without a state
+// file v is returned.
+func Read(fileName string, v State) (State, error) {
+	data, err := os.ReadFile(Name(fileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return v, nil
+		}
+		return v, fmt.Errorf("%v", err)
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("%s: %v", Name(fileName), err)
+	}
+	return v, nil
+}
+
+// Write stores v for the notes in fileName.
+func Write(fileName string, v State) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if err := os.WriteFile(Name(fileName), data, 0644); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
+
+// CheckFontSize resets a font size outside MinFontSize and MaxFontSize, like
+// one edited into the file, to the default.
+func (v *State) CheckFontSize() {
+	if v.FontSize < MinFontSize || v.FontSize > MaxFontSize {
+		v.FontSize = DefaultFontSize
+	}
+}
+
+// Zoom returns the font size step points larger, false when that is out of range.
+func (v State) Zoom(step int) (int, bool) {
+	size := v.FontSize + step
+	if size < MinFontSize || size > MaxFontSize {
+		return v.FontSize, false
+	}
+	return size, true
+}
@@ -0,0 +1,72 @@
+package viewstate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	notes := filepath.Join(t.TempDir(), "notes.md")
+	defaults := State{Font: "helvetica", FontSize: DefaultFontSize}
+
+	// without a state file the defaults stay.
+	v, err := Read(notes, defaults)
+	if err != nil || v != defaults {
+		t.Fatalf("no state file: %+v, %v", v, err)
+	}
+
+	want := State{X: 10, Y: 20, W: 400, H: 300, Font: "courier", FontSize: 18, WrapAtWindow: true, ClientNotes: true}
+	if err := Write(notes, want); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := Read(notes, defaults); err != nil || v != want {
+		t.Fatalf("got %+v, %v, want %+v", v, err, want)
+	}
+
+	// fields missing from an older file keep their defaults.
+	if err := os.WriteFile(Name(notes), []byte(`{"w": 500, "h": 400}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := Read(notes, defaults); err != nil || v.Font != "helvetica" || v.FontSize != DefaultFontSize || v.W != 500 {
+		t.Fatalf("older file: %+v, %v", v, err)
+	}
+
+	if err := os.WriteFile(Name(notes), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(notes, defaults); err == nil {
+		t.Fatal("no error for a broken state file")
+	}
+}
+
+func TestFontSize(t *testing.T) {
+	for _, test := range []struct{ size, want int }{
+		{12, 12},
+		{MinFontSize, MinFontSize},
+		{MaxFontSize, MaxFontSize},
+		{0, DefaultFontSize},
+		{MaxFontSize + 1, DefaultFontSize},
+	} {
+		v := State{FontSize: test.size}
+		v.CheckFontSize()
+		if v.FontSize != test.want {
+			t.Errorf("%d: got %d, want %d", test.size, v.FontSize, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		size, step, want int
+		ok               bool
+	}{
+		{14, 1, 15, true},
+		{14, -1, 13, true},
+		{MaxFontSize, 1, MaxFontSize, false},
+		{MinFontSize, -1, MinFontSize, false},
+	} {
+		got, ok := State{FontSize: test.size}.Zoom(test.step)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%d%+d: got %d, %t, want %d, %t", test.size, test.step, got, ok, test.want, test.ok)
+		}
+	}
+}